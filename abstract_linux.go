@@ -0,0 +1,78 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nerdctld
+
+import (
+	"log"
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerCredListener only accepts connections from the same user (or root),
+// since abstract sockets have no file permissions
+type peerCredListener struct {
+	net.Listener
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err == nil && (uid == 0 || uid == os.Geteuid()) {
+			return conn, nil
+		}
+		log.Printf("refused connection from uid %d: %v", uid, err)
+		conn.Close()
+	}
+}
+
+// peerUID returns the user of the process at the other end of the unix socket
+func peerUID(conn net.Conn) (int, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return -1, syscall.EINVAL
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return -1, err
+	}
+	if credErr != nil {
+		return -1, credErr
+	}
+	return int(cred.Uid), nil
+}
+
+// abstractListener listens on the abstract unix socket (without a file), like "@nerdctld"
+func abstractListener(name string) (net.Listener, error) {
+	l, err := net.Listen("unix", "@"+name)
+	if err != nil {
+		return nil, err
+	}
+	return &peerCredListener{l}, nil
+}
@@ -0,0 +1,230 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package api translates the Docker Engine API to nerdctl commands.
+package api
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// regular expression for slashes-in-parameter workaround
+var reImagesPush = regexp.MustCompile(`^/(?P<ver>.*)/images/(?P<name>.*)/push$`)
+var reImagesName = regexp.MustCompile(`^/(?P<ver>[^/]*)/images/(?P<name>.*)/(?P<action>json|history|tag|get)$`)
+var reDistribution = regexp.MustCompile(`^/(?P<ver>[^/]*)/distribution/(?P<name>.*)/json$`)
+
+// regular expression for starting version number in url
+var reApiVersion = regexp.MustCompile(`^/(?P<ver>[0-9][.][0-9]+)/.*$`)
+
+const CurrentAPIVersion = "1.46" // 27.0
+const MinimumAPIVersion = "1.24" // 1.12
+
+// apiVersion returns the requested API version, without any "v" prefix
+func apiVersion(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("ver"), "v")
+}
+
+// CompareVersions compares two version strings
+// returns -1 if v1 < v2, 1 if v1 > v2, 0 otherwise.
+func CompareVersions(v1, v2 string) int {
+	var (
+		currTab  = strings.Split(v1, ".")
+		otherTab = strings.Split(v2, ".")
+	)
+
+	max := len(currTab)
+	if len(otherTab) > max {
+		max = len(otherTab)
+	}
+	for i := 0; i < max; i++ {
+		var currInt, otherInt int
+
+		if len(currTab) > i {
+			currInt, _ = strconv.Atoi(currTab[i])
+		}
+		if len(otherTab) > i {
+			otherInt, _ = strconv.Atoi(otherTab[i])
+		}
+		if currInt > otherInt {
+			return 1
+		}
+		if otherInt > currInt {
+			return -1
+		}
+	}
+	return 0
+}
+
+// NewRouter returns the handler for all the routes of the Docker API
+func NewRouter() *gin.Engine {
+
+	r := gin.New()
+	r.Use(requestID(), gin.LoggerWithFormatter(accessLog), gin.Recovery())
+	err := r.SetTrustedProxies(nil)
+	if err != nil {
+		log.Print(err)
+	}
+
+	// these answer even without nerdctl, so are added before the middleware
+	r.HEAD("/_ping", headPing)
+	r.GET("/_ping", getPing)
+	r.GET("/healthz", getHealthz)
+	r.GET("/nerdctld/openapi.json", getOpenAPI)
+	r.GET("/nerdctld/capabilities", getCapabilities(r))
+	r.Use(requireNerdctl())
+	if ValidateRequests {
+		r.Use(validateRequest())
+	}
+	r.Use(resolveContainer())
+
+	// new in 1.40 API:
+	r.GET("/:ver/version", getVersion)
+	r.GET("/:ver/info", getInfo)
+	r.POST("/:ver/auth", postAuth)
+	r.GET("/:ver/images/json", conditionalList(), gzipResponse(), getImages)
+	r.GET("/:ver/images/:name/json", gzipResponse(), inspectImage)
+	r.GET("/:ver/images/:name/history", getImageHistory)
+	r.POST("/:ver/images/:name/tag", tagImage)
+	r.POST("/:ver/images/:name/push", pushImage)
+	r.POST("/:ver/images/create", detachable("pull"), pullImage)
+	r.DELETE("/:ver/images/*name", removeImage)
+	r.POST("/:ver/images/load", loadImage)
+	r.POST("/:ver/images/prune", detachable("prune"), pruneImages)
+	r.GET("/:ver/images/get", saveImages)
+	r.GET("/:ver/images/:name/get", saveImages)
+	r.GET("/:ver/containers/json", conditionalList(), gzipResponse(), getContainers)
+	r.GET("/:ver/containers/:name/json", gzipResponse(), inspectContainer)
+	r.GET("/:ver/containers/:name/logs", getContainerLogs)
+	r.POST("/:ver/containers/create", createContainer)
+	r.POST("/:ver/containers/:name/start", startContainer)
+	r.POST("/:ver/containers/:name/stop", stopContainer)
+	r.POST("/:ver/containers/:name/restart", restartContainer)
+	r.POST("/:ver/containers/:name/kill", killContainer)
+	r.POST("/:ver/containers/:name/wait", waitContainer)
+	r.POST("/:ver/containers/:name/update", updateContainer)
+	r.POST("/:ver/containers/:name/pause", pauseContainer)
+	r.POST("/:ver/containers/:name/unpause", unpauseContainer)
+	r.POST("/:ver/containers/:name/rename", requireFeature("rename"), renameContainer)
+	r.POST("/:ver/containers/:name/attach", requireFeature("attach"), attachContainer)
+	r.GET("/:ver/containers/:name/export", exportContainer)
+	r.POST("/:ver/commit", commitContainer)
+	r.HEAD("/:ver/containers/:name/archive", headArchive)
+	r.GET("/:ver/containers/:name/archive", getArchive)
+	r.PUT("/:ver/containers/:name/archive", putArchive)
+	r.DELETE("/:ver/containers/:name", removeContainer)
+	r.POST("/:ver/containers/prune", pruneContainers)
+	r.GET("/:ver/containers/:name/stats", getContainerStats)
+	r.GET("/:ver/containers/:name/top", topContainer)
+	r.POST("/:ver/containers/:name/exec", createExec)
+	r.POST("/:ver/exec/:id/start", startExec)
+	r.POST("/:ver/exec/:id/resize", resizeExec)
+	r.GET("/:ver/exec/:id/json", inspectExec)
+	r.GET("/:ver/events", getEvents)
+	r.GET("/:ver/volumes", getVolumes)
+	r.GET("/:ver/volumes/:name", gzipResponse(), inspectVolume)
+	r.POST("/:ver/volumes/create", createVolume)
+	r.DELETE("/:ver/volumes/:name", removeVolume)
+	r.POST("/:ver/volumes/prune", pruneVolumes)
+	r.GET("/:ver/networks", getNetworks)
+	r.GET("/:ver/networks/:name", gzipResponse(), inspectNetwork)
+	r.POST("/:ver/networks/create", createNetwork)
+	r.DELETE("/:ver/networks/:name", removeNetwork)
+	r.POST("/:ver/networks/prune", pruneNetworks)
+	r.POST("/:ver/networks/:name/connect", connectNetwork)
+	r.POST("/:ver/networks/:name/disconnect", disconnectNetwork)
+	r.GET("/:ver/distribution/:name/json", inspectDistribution)
+	r.GET("/:ver/system/df", getDiskUsage)
+	r.POST("/:ver/build", detachable("build"), buildImage)
+	r.POST("/:ver/build/prune", detachable("prune"), pruneBuildCache)
+
+	// nerdctld extensions:
+	r.GET("/nerdctld/namespaces", getNamespaces)
+	r.GET("/nerdctld/namespace", getNamespace)
+	r.POST("/nerdctld/namespaces/:name/use", useNamespace)
+	r.POST("/nerdctld/uploads", createUpload)
+	r.HEAD("/nerdctld/uploads/:id", getUpload)
+	r.GET("/nerdctld/uploads/:id", getUpload)
+	r.PATCH("/nerdctld/uploads/:id", appendUpload)
+	r.DELETE("/nerdctld/uploads/:id", removeUpload)
+	r.GET("/nerdctld/jobs", getJobs)
+	r.GET("/nerdctld/jobs/:id", inspectJob)
+	r.GET("/nerdctld/jobs/:id/logs", getJobLogs)
+	r.DELETE("/nerdctld/jobs/:id", removeJob)
+	r.GET("/nerdctld/exec/:id/ws", startExecWebsocket)
+	r.POST("/nerdctld/system/prune", detachable("prune"), pruneSystem)
+
+	r.NoRoute(func(c *gin.Context) {
+		// the "push" route doesn't match name containing slashes (like repo)
+		if m := reImagesPush.FindStringSubmatch(c.Request.URL.Path); m != nil {
+			c.Params = gin.Params{
+				{Key: "ver", Value: m[reImagesPush.SubexpIndex("ver")]},
+				{Key: "name", Value: m[reImagesPush.SubexpIndex("name")]},
+			}
+			pushImage(c)
+			return
+		}
+		// the other image routes don't match names containing slashes either
+		if m := reImagesName.FindStringSubmatch(c.Request.URL.Path); m != nil {
+			c.Params = gin.Params{
+				{Key: "ver", Value: m[reImagesName.SubexpIndex("ver")]},
+				{Key: "name", Value: m[reImagesName.SubexpIndex("name")]},
+			}
+			switch m[reImagesName.SubexpIndex("action")] {
+			case "json":
+				if c.Request.Method == http.MethodGet {
+					inspectImage(c)
+				}
+			case "history":
+				if c.Request.Method == http.MethodGet {
+					getImageHistory(c)
+				}
+			case "tag":
+				if c.Request.Method == http.MethodPost {
+					tagImage(c)
+				}
+			case "get":
+				if c.Request.Method == http.MethodGet {
+					saveImages(c)
+				}
+			}
+			return
+		}
+		if m := reDistribution.FindStringSubmatch(c.Request.URL.Path); m != nil && c.Request.Method == http.MethodGet {
+			c.Params = gin.Params{
+				{Key: "ver", Value: m[reDistribution.SubexpIndex("ver")]},
+				{Key: "name", Value: m[reDistribution.SubexpIndex("name")]},
+			}
+			inspectDistribution(c)
+			return
+		}
+		// some clients don't negotiate for the API version, before commands
+		if m := reApiVersion.FindStringSubmatch(c.Request.URL.Path); m == nil {
+			c.Request.URL.Path = "/" + CurrentAPIVersion + c.Request.URL.Path
+			r.HandleContext(c)
+			// don't continue with the remaining handlers of the new route
+			c.Abort()
+		}
+	})
+
+	return r
+}
@@ -0,0 +1,335 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+// decompress returns a reader for the (possibly gzip or bzip2 compressed) archive
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return gzip.NewReader(br)
+	case len(magic) >= 3 && string(magic) == "BZh":
+		return bzip2.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// archivePath returns the path of the archive entry in dst, or an error if it is outside of dst
+func archivePath(dst string, name string) (string, error) {
+	target := filepath.Join(dst, name)
+	if target != dst && !strings.HasPrefix(target, dst+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path in archive: %s", name)
+	}
+	return target, nil
+}
+
+// checkParents makes sure that none of the parents of target (below dst) is a symlink,
+// since writing through one could write outside of dst
+func checkParents(dst string, target string) error {
+	rel, err := filepath.Rel(dst, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := dst
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid path in archive: %s is a symlink", dir[len(dst)+1:])
+		}
+	}
+	return nil
+}
+
+// replaceFile removes the existing target (but not a directory), so that it is not followed
+func replaceFile(target string) error {
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("cannot replace directory: %s", target)
+	}
+	return os.Remove(target)
+}
+
+// extractTar extracts the tar archive to the dst directory
+func extractTar(dst string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break // End of archive
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := archivePath(dst, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(dst, target); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(target); err != nil {
+				if err := os.MkdirAll(target, os.FileMode(header.Mode)|0700); err != nil {
+					return err
+				}
+			} else if !fi.IsDir() {
+				return fmt.Errorf("invalid path in archive: %s is not a directory", header.Name)
+			}
+		case tar.TypeReg:
+			if err := replaceFile(target); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		case tar.TypeSymlink:
+			// the link must point inside of dst, relative to where it is
+			if filepath.IsAbs(header.Linkname) {
+				return fmt.Errorf("invalid link in archive: %s -> %s", header.Name, header.Linkname)
+			}
+			if _, err := archivePath(dst, filepath.Join(filepath.Dir(header.Name), header.Linkname)); err != nil {
+				return fmt.Errorf("invalid link in archive: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := replaceFile(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source, err := archivePath(dst, header.Linkname)
+			if err != nil {
+				return fmt.Errorf("invalid link in archive: %s => %s", header.Name, header.Linkname)
+			}
+			if err := checkParents(dst, source); err != nil {
+				return err
+			}
+			if err := replaceFile(target); err != nil {
+				return err
+			}
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeTar writes the file or directory src to the tar archive, named as name
+func writeTar(w io.Writer, src string, name string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(name, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// pathStat is the stat of the path in the container, as a base64 encoded header
+func pathStat(fi os.FileInfo, name string, link string) string {
+	stat := struct {
+		Name       string      `json:"name"`
+		Size       int64       `json:"size"`
+		Mode       os.FileMode `json:"mode"`
+		Mtime      time.Time   `json:"mtime"`
+		LinkTarget string      `json:"linkTarget"`
+	}{name, fi.Size(), fi.Mode(), fi.ModTime(), link}
+	data, _ := json.Marshal(stat)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// copyFromContainer copies the path from the container to a new temporary directory,
+// and returns the directory and the local copy (which the caller must remove)
+func copyFromContainer(c *gin.Context) (string, string, bool) {
+	name := c.Param("name")
+	p := c.Query("path")
+	if p == "" {
+		httpError(c.Writer, "path is required", http.StatusBadRequest)
+		return "", "", false
+	}
+	if _, err := backend.Container(name); err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return "", "", false
+	}
+	dir, err := sharedTempDir("archive")
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return "", "", false
+	}
+	base := path.Base(p)
+	if base == "/" || base == "." {
+		base = "root"
+	}
+	local := filepath.Join(dir, base)
+	if err := backend.CopyFrom(name, p, local); err != nil {
+		removeTempDir(dir)
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return "", "", false
+	}
+	return dir, local, true
+}
+
+func headArchive(c *gin.Context) {
+	dir, local, ok := copyFromContainer(c)
+	if !ok {
+		return
+	}
+	defer removeTempDir(dir)
+	fi, err := os.Lstat(local)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	link, _ := os.Readlink(local)
+	c.Writer.Header().Set("X-Docker-Container-Path-Stat", pathStat(fi, path.Base(c.Query("path")), link))
+	c.Status(http.StatusOK)
+}
+
+func getArchive(c *gin.Context) {
+	dir, local, ok := copyFromContainer(c)
+	if !ok {
+		return
+	}
+	defer removeTempDir(dir)
+	fi, err := os.Lstat(local)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	link, _ := os.Readlink(local)
+	c.Writer.Header().Set("X-Docker-Container-Path-Stat", pathStat(fi, path.Base(c.Query("path")), link))
+	c.Writer.Header().Set("Content-Type", "application/x-tar")
+	c.Writer.WriteHeader(http.StatusOK)
+	if err := writeTar(c.Writer, local, filepath.Base(local)); err != nil {
+		logRequest(c, "archive %s: %v", c.Query("path"), err)
+	}
+}
+
+func putArchive(c *gin.Context) {
+	name := c.Param("name")
+	p := c.Query("path")
+	if p == "" {
+		httpError(c.Writer, "path is required", http.StatusBadRequest)
+		return
+	}
+	if _, err := backend.Container(name); err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return
+	}
+	dir, err := sharedTempDir("archive")
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer removeTempDir(dir)
+	r, err := decompress(c.Request.Body)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := extractTar(dir, r); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// copy the contents of the directory, into the path
+	if err := backend.CopyTo(dir+"/.", name, p); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,106 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTar(t *testing.T) {
+	dst := t.TempDir()
+	err := extractTar(dst, makeTar(t, []tarEntry{
+		{name: "dir/", typeflag: tar.TypeDir},
+		{name: "dir/file", typeflag: tar.TypeReg, body: "hello"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "dir/file"},
+		{name: "hard", typeflag: tar.TypeLink, linkname: "dir/file"},
+		{name: "link", typeflag: tar.TypeSymlink, linkname: "dir"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "hard")); err != nil || string(data) != "hello" {
+		t.Errorf("hard link: %q %v", data, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "link")); err != nil || link != "dir" {
+		t.Errorf("replaced symlink: %q %v", link, err)
+	}
+}
+
+func TestExtractTarEscape(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"dotdot", []tarEntry{{name: "../x", typeflag: tar.TypeReg}}},
+		{"absolute link", []tarEntry{{name: "a", typeflag: tar.TypeSymlink, linkname: "/etc"}}},
+		{"escaping link", []tarEntry{{name: "d/a", typeflag: tar.TypeSymlink, linkname: "../../etc"}}},
+		{"write through link", []tarEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a/x", typeflag: tar.TypeReg},
+		}},
+		{"dir through link", []tarEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a/d/", typeflag: tar.TypeDir},
+		}},
+		{"escaping hard link", []tarEntry{{name: "h", typeflag: tar.TypeLink, linkname: "../x"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "dst")
+			if err := os.Mkdir(dst, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := extractTar(dst, makeTar(t, tc.entries)); err == nil {
+				t.Error("expected an error")
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(dst), "x")); err == nil {
+				t.Error("wrote outside of dst")
+			}
+		})
+	}
+}
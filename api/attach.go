@@ -0,0 +1,248 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+// attachSession is an attach to a created container, waiting for it to be started
+type attachSession struct {
+	ctx    context.Context
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	done   chan error
+}
+
+var attaches = struct {
+	sync.Mutex
+	m map[string]*attachSession
+}{m: map[string]*attachSession{}}
+
+// takeAttach returns (and removes) the attach waiting for the container, if any
+func takeAttach(id string) *attachSession {
+	attaches.Lock()
+	defer attaches.Unlock()
+	session := attaches.m[id]
+	delete(attaches.m, id)
+	return session
+}
+
+// attachStdinTimeout is how long a session waits for the start, after the end of the stdin
+// (since a client that is gone can't be told from one that only closed its stdin)
+const attachStdinTimeout = time.Minute
+
+// dropAttach removes the session, if it is still waiting for the start
+func dropAttach(id string, session *attachSession) {
+	attaches.Lock()
+	defer attaches.Unlock()
+	if attaches.m[id] == session {
+		delete(attaches.m, id)
+	}
+}
+
+// waitingAttach checks that the session is still waiting for the start
+func waitingAttach(id string, session *attachSession) bool {
+	attaches.Lock()
+	defer attaches.Unlock()
+	return attaches.m[id] == session
+}
+
+// watchAttachStdin copies the stdin of the client to the session, and cancels the session
+// if the client goes away before the container is started
+func watchAttachStdin(ctx context.Context, cancel context.CancelFunc, id string, session *attachSession, r io.Reader, pw *io.PipeWriter) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// blocks until the container is started, and reads it
+			if _, werr := pw.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+		pw.Close()
+		if err != io.EOF {
+			// the connection is broken
+			cancel()
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(attachStdinTimeout):
+			if waitingAttach(id, session) {
+				cancel()
+			}
+		}
+		return
+	}
+}
+
+// startAttached starts the container with "nerdctl start --attach", for the session
+func startAttached(name string, session *attachSession) error {
+	cmd := backend.StartAttach(session.ctx, name)
+	cmd.Stdout = session.stdout
+	cmd.Stderr = session.stderr
+	var stdin io.WriteCloser
+	if session.stdin != nil {
+		var err error
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		session.done <- err
+		return err
+	}
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(stdin, session.stdin)
+			stdin.Close()
+		}()
+	}
+	go func() {
+		session.done <- cmd.Wait()
+	}()
+	// return when the container has started, so that wait works after start
+	for i := 0; i < 50; i++ {
+		if _, status, err := containerState(name); err != nil || status != "created" {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil
+}
+
+func attachContainer(c *gin.Context) {
+	name := c.Param("name")
+	isTrue := func(key string) bool {
+		v := c.Query(key)
+		return v == "1" || v == "true"
+	}
+	container, err := backend.Container(name)
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return
+	}
+	id, _ := container["Id"].(string)
+	state, _ := container["State"].(map[string]interface{})
+	status, _ := state["Status"].(string)
+	tty := false
+	if config, ok := container["Config"].(map[string]interface{}); ok {
+		tty, _ = config["Tty"].(bool)
+	}
+	since := time.Now()
+	conn, rw, err := stream.Hijack(c.Writer, c.Request)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	stdout, stderr := stream.NewStdWriters(conn)
+	if tty {
+		stdout, stderr = stream.NewRawWriters(conn)
+	}
+	var wout, werr io.Writer = io.Discard, io.Discard
+	if isTrue("stdout") {
+		wout = stdout
+	}
+	if isTrue("stderr") {
+		werr = stderr
+	}
+
+	if !isTrue("stream") {
+		if isTrue("logs") {
+			_ = backend.Logs(context.Background(), name, wout, werr, backend.LogsOptions{})
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if status == "created" {
+		// wait for the start, which runs the container attached to this session
+		session := &attachSession{ctx: ctx, stdout: wout, stderr: werr, done: make(chan error, 1)}
+		if isTrue("stdin") {
+			pr, pw := io.Pipe()
+			session.stdin = pr
+			go watchAttachStdin(ctx, cancel, id, session, rw, pw)
+		} else {
+			go func() {
+				// the client closes the connection, when it is done
+				_, _ = io.Copy(io.Discard, rw)
+				cancel()
+			}()
+		}
+		attaches.Lock()
+		attaches.m[id] = session
+		attaches.Unlock()
+		select {
+		case <-ctx.Done():
+			dropAttach(id, session)
+		case <-session.done:
+		}
+		stream.CloseWrite(conn)
+		return
+	}
+
+	if isTrue("stdin") {
+		// nerdctl attach only works for containers created with stdin open
+		cmd := backend.Attach(name)
+		cmd.Stdout = wout
+		cmd.Stderr = werr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintf(werr, "%s\n", err)
+			return
+		}
+		go func() {
+			_, _ = io.Copy(stdin, rw)
+			stdin.Close()
+		}()
+		_ = cmd.Wait()
+		stream.CloseWrite(conn)
+		return
+	}
+
+	// without stdin, follow the logs instead
+	go func() {
+		// the client closes the connection, when it is done
+		_, _ = io.Copy(io.Discard, rw)
+		cancel()
+	}()
+	opts := backend.LogsOptions{Follow: true}
+	if !isTrue("logs") {
+		opts.Since = since.Format(time.RFC3339Nano)
+	}
+	_ = backend.Logs(ctx, name, wout, werr, opts)
+	stream.CloseWrite(conn)
+}
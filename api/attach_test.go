@@ -0,0 +1,70 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestWatchAttachStdin(t *testing.T) {
+	// the input is passed on, and the end of it does not cancel the session
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	go watchAttachStdin(ctx, cancel, "input", &attachSession{}, strings.NewReader("hello"), pw)
+	if data, err := io.ReadAll(pr); err != nil || string(data) != "hello" {
+		t.Errorf("stdin %q: %v", data, err)
+	}
+	if ctx.Err() != nil {
+		t.Error("session was cancelled at the end of stdin")
+	}
+
+	// a broken connection cancels the session
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw = io.Pipe()
+	go watchAttachStdin(ctx, cancel, "broken", &attachSession{}, iotest.ErrReader(errors.New("connection reset")), pw)
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not cancelled")
+	}
+	if _, err := io.ReadAll(pr); err != nil {
+		t.Errorf("stdin: %v", err)
+	}
+}
+
+func TestDropAttach(t *testing.T) {
+	old, current := &attachSession{}, &attachSession{}
+	attaches.Lock()
+	attaches.m["drop"] = current
+	attaches.Unlock()
+	dropAttach("drop", old)
+	if !waitingAttach("drop", current) {
+		t.Error("another session was dropped")
+	}
+	dropAttach("drop", current)
+	if waitingAttach("drop", current) {
+		t.Error("session was not dropped")
+	}
+}
@@ -0,0 +1,297 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the keepalive of the bridge connections, which send pings when idle
+const bridgeKeepAlive = 30 * time.Second
+
+// bridgeTimeout is how long to wait for the broker, when connecting or writing
+// (so that a stuck broker does not hold up the events)
+var bridgeTimeout = 10 * time.Second
+
+// bridge is a connection to a message broker (NATS or MQTT), that the events are published to
+type bridge interface {
+	publish(data []byte) error
+	close()
+}
+
+// dialBridge connects to the broker of the url, like "nats://localhost:4222/docker.events"
+// or "mqtt://localhost:1883/docker/events" (the path is the subject or topic)
+func dialBridge(u *url.URL) (bridge, error) {
+	topic := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "nats":
+		if topic == "" {
+			topic = "docker.events"
+		}
+		return dialNATS(hostPort(u, "4222"), u.User, strings.ReplaceAll(topic, "/", "."))
+	case "mqtt":
+		if topic == "" {
+			topic = "docker/events"
+		}
+		return dialMQTT(hostPort(u, "1883"), u.User, topic)
+	}
+	return nil, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// publishEvents publishes the events to the broker, connecting again after errors
+func publishEvents(hook Webhook, queue chan *Event) {
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		log.Printf("webhook %s: %v", hook.URL, err)
+		return
+	}
+	var b bridge
+	for ev := range queue {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("webhook %s: %v", u.Redacted(), err)
+			continue
+		}
+		// try twice, in case the connection was lost since the last event
+		for i := 0; i < 2; i++ {
+			if b == nil {
+				if b, err = dialBridge(u); err != nil {
+					break
+				}
+			}
+			if err = b.publish(data); err == nil {
+				break
+			}
+			b.close()
+			b = nil
+		}
+		if err != nil {
+			log.Printf("webhook %s: %v", u.Redacted(), err)
+		}
+	}
+}
+
+// natsBridge publishes with the NATS text protocol
+type natsBridge struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	subject string
+	err     error
+}
+
+func dialNATS(addr string, user *url.Userinfo, subject string) (bridge, error) {
+	conn, err := net.DialTimeout("tcp", addr, bridgeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(bridgeTimeout))
+	r := bufio.NewReader(conn)
+	// the server starts with INFO {...}
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, fmt.Errorf("nats %s: no INFO from server", addr)
+	}
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "nerdctld", "lang": "go"}
+	if user != nil {
+		options["user"] = user.Username()
+		options["pass"], _ = user.Password()
+	}
+	data, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", data); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if line, err = r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "PONG") {
+		conn.Close()
+		return nil, fmt.Errorf("nats %s: %s", addr, strings.TrimSpace(line))
+	}
+	_ = conn.SetDeadline(time.Time{})
+	n := &natsBridge{conn: conn, subject: subject}
+	go n.read(r)
+	return n, nil
+}
+
+// read answers the pings of the server, and keeps the errors
+func (n *natsBridge) read(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		n.mu.Lock()
+		switch {
+		case err != nil:
+			n.err = err
+		case strings.HasPrefix(line, "PING"):
+			_ = n.conn.SetWriteDeadline(time.Now().Add(bridgeTimeout))
+			if _, err = io.WriteString(n.conn, "PONG\r\n"); err != nil {
+				n.err = err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			n.err = fmt.Errorf("nats: %s", strings.TrimSpace(line[4:]))
+		}
+		n.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (n *natsBridge) publish(data []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	_ = n.conn.SetWriteDeadline(time.Now().Add(bridgeTimeout))
+	_, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\n", n.subject, len(data), data)
+	return err
+}
+
+func (n *natsBridge) close() {
+	n.conn.Close()
+}
+
+// mqttBridge publishes with MQTT 3.1.1, at most once (QoS 0)
+type mqttBridge struct {
+	mu    sync.Mutex
+	conn  net.Conn
+	topic string
+	done  chan struct{}
+	err   error
+}
+
+// mqttString is a string, prefixed with its length
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// mqttPacket is the packet, with the fixed header (type and remaining length)
+func mqttPacket(typ byte, body []byte) []byte {
+	packet := []byte{typ}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func dialMQTT(addr string, user *url.Userinfo, topic string) (bridge, error) {
+	conn, err := net.DialTimeout("tcp", addr, bridgeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(bridgeTimeout))
+	flags := byte(0x02) // clean session
+	payload := mqttString(fmt.Sprintf("nerdctld-%d", os.Getpid()))
+	if user != nil {
+		flags |= 0x80
+		payload = append(payload, mqttString(user.Username())...)
+		if password, ok := user.Password(); ok {
+			flags |= 0x40
+			payload = append(payload, mqttString(password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(2*bridgeKeepAlive/time.Second))
+	body = append(body, payload...)
+	if _, err := conn.Write(mqttPacket(0x10, body)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if connack[0] != 0x20 || connack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt %s: connection refused (%d)", addr, connack[3])
+	}
+	_ = conn.SetDeadline(time.Time{})
+	m := &mqttBridge{conn: conn, topic: topic, done: make(chan struct{})}
+	go m.read()
+	go m.ping()
+	return m, nil
+}
+
+// read discards the ping responses, and keeps the error when the connection is lost
+func (m *mqttBridge) read() {
+	_, err := io.Copy(io.Discard, m.conn)
+	if err == nil {
+		err = io.EOF
+	}
+	m.mu.Lock()
+	m.err = err
+	m.mu.Unlock()
+}
+
+func (m *mqttBridge) ping() {
+	ticker := time.NewTicker(bridgeKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			_ = m.conn.SetWriteDeadline(time.Now().Add(bridgeTimeout))
+			_, _ = m.conn.Write([]byte{0xc0, 0x00})
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *mqttBridge) publish(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	_ = m.conn.SetWriteDeadline(time.Now().Add(bridgeTimeout))
+	_, err := m.conn.Write(mqttPacket(0x30, append(mqttString(m.topic), data...)))
+	return err
+}
+
+func (m *mqttBridge) close() {
+	close(m.done)
+	m.conn.Close()
+}
@@ -0,0 +1,193 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts one connection, and runs the broker side of it
+func fakeBroker(t *testing.T, serve func(conn net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn)
+	}()
+	return l.Addr().String()
+}
+
+// shortBridgeTimeout makes the timeouts short, for the brokers that don't answer
+func shortBridgeTimeout(t *testing.T) {
+	saved := bridgeTimeout
+	bridgeTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { bridgeTimeout = saved })
+}
+
+func TestNATSBridge(t *testing.T) {
+	received := make(chan string, 1)
+	addr := fakeBroker(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {\"server_id\":\"fake\"}\r\n")
+		connect, _ := r.ReadString('\n')
+		ping, _ := r.ReadString('\n')
+		if !strings.HasPrefix(connect, "CONNECT {") || ping != "PING\r\n" {
+			t.Errorf("handshake %q %q", connect, ping)
+			return
+		}
+		io.WriteString(conn, "PONG\r\n")
+		pub, _ := r.ReadString('\n')
+		payload, _ := r.ReadString('\n')
+		received <- pub + payload
+	})
+	b, err := dialBridge(&url.URL{Scheme: "nats", Host: addr, Path: "/docker/events"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+	if err := b.publish([]byte(`{"Type":"container"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg != "PUB docker.events 20\r\n{\"Type\":\"container\"}\r\n" {
+			t.Errorf("published %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing published")
+	}
+}
+
+func TestNATSBridgeTimeout(t *testing.T) {
+	shortBridgeTimeout(t)
+	for name, serve := range map[string]func(net.Conn){
+		"no INFO": func(conn net.Conn) { time.Sleep(2 * time.Second) },
+		"no PONG": func(conn net.Conn) {
+			io.WriteString(conn, "INFO {}\r\n")
+			time.Sleep(2 * time.Second)
+		},
+	} {
+		addr := fakeBroker(t, serve)
+		start := time.Now()
+		if _, err := dialNATS(addr, nil, "docker.events"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("%s: took %v", name, time.Since(start))
+		}
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	received := make(chan []byte, 1)
+	addr := fakeBroker(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(r, header); err != nil || header[0] != 0x10 {
+			t.Errorf("connect %x: %v", header, err)
+			return
+		}
+		io.ReadFull(r, make([]byte, header[1]))
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		if _, err := io.ReadFull(r, header); err != nil || header[0] != 0x30 {
+			t.Errorf("publish %x: %v", header, err)
+			return
+		}
+		body := make([]byte, header[1])
+		io.ReadFull(r, body)
+		received <- body
+	})
+	b, err := dialBridge(&url.URL{Scheme: "mqtt", Host: addr, User: url.UserPassword("user", "pass")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+	if err := b.publish([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-received:
+		if want := append(mqttString("docker/events"), "{}"...); string(body) != string(want) {
+			t.Errorf("published %q, want %q", body, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing published")
+	}
+}
+
+func TestMQTTBridgeRefused(t *testing.T) {
+	addr := fakeBroker(t, func(conn net.Conn) {
+		io.ReadFull(conn, make([]byte, 2))
+		// not authorized
+		conn.Write([]byte{0x20, 0x02, 0x00, 0x05})
+	})
+	if _, err := dialMQTT(addr, nil, "docker/events"); err == nil || !strings.Contains(err.Error(), "refused (5)") {
+		t.Errorf("expected connection refused, got %v", err)
+	}
+}
+
+func TestMQTTBridgeTimeout(t *testing.T) {
+	shortBridgeTimeout(t)
+	addr := fakeBroker(t, func(conn net.Conn) { time.Sleep(2 * time.Second) })
+	start := time.Now()
+	if _, err := dialMQTT(addr, nil, "docker/events"); err == nil {
+		t.Error("expected an error without CONNACK")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("took %v", time.Since(start))
+	}
+}
+
+func TestNATSBridgePublishTimeout(t *testing.T) {
+	addr := fakeBroker(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, "INFO {}\r\n")
+		r.ReadString('\n')
+		r.ReadString('\n')
+		io.WriteString(conn, "PONG\r\n")
+		// and then stop reading
+		time.Sleep(5 * time.Second)
+	})
+	b, err := dialNATS(addr, nil, "docker.events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+	shortBridgeTimeout(t)
+	data := make([]byte, 1024*1024)
+	for i := 0; i < 256; i++ {
+		if err = b.publish(data); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		t.Error("expected a timeout, when the broker does not read")
+	}
+}
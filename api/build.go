@@ -0,0 +1,208 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+func parseObject(param []byte) (map[string]interface{}, error) {
+	if len(param) == 0 {
+		return nil, nil
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal(param, &args); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// proxyArgs are the predefined build args for the proxy, which are added from the daemon environment
+var proxyArgs = []string{"HTTP_PROXY", "HTTPS_PROXY", "FTP_PROXY", "NO_PROXY", "ALL_PROXY"}
+
+// daemonProxy returns the proxy variable of the daemon, in upper or lower case
+func daemonProxy(name string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+// proxyBuildArgs adds the proxy of the daemon to the build args, unless the client has set them,
+// so that builds work behind a proxy without configuring the clients
+func proxyBuildArgs(buildargs map[string]interface{}) map[string]interface{} {
+	if buildargs == nil {
+		buildargs = map[string]interface{}{}
+	}
+	for _, name := range proxyArgs {
+		_, upper := buildargs[name]
+		_, lower := buildargs[strings.ToLower(name)]
+		if value := daemonProxy(name); value != "" && !upper && !lower {
+			buildargs[name] = value
+		}
+	}
+	return buildargs
+}
+
+// redactProxy hides the credentials of the proxy url, like docker info
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	u.User = url.UserPassword("xxxxx", "xxxxx")
+	return u.String()
+}
+
+// MaxConcurrentBuilds is the number of builds that can run at the same time,
+// the others wait for their turn (0 is no limit)
+var MaxConcurrentBuilds int
+
+var buildSlots chan struct{}
+var buildSlotsOnce sync.Once
+
+// acquireBuild waits for a build slot, or until the client goes away.
+// It returns the function to release the slot with, or nil if it gave up.
+func acquireBuild(ctx context.Context) func() {
+	buildSlotsOnce.Do(func() {
+		if MaxConcurrentBuilds > 0 {
+			buildSlots = make(chan struct{}, MaxConcurrentBuilds)
+		}
+	})
+	if buildSlots == nil {
+		return func() {}
+	}
+	select {
+	case buildSlots <- struct{}{}:
+		return func() { <-buildSlots }
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func buildImage(c *gin.Context) {
+	contentType := c.Request.Header.Get("Content-Type")
+	if contentType != "application/tar" && contentType != "application/x-tar" {
+		httpError(c.Writer, fmt.Sprintf("%s not tar", contentType), http.StatusBadRequest)
+		return
+	}
+	buildargs, err := parseObject([]byte(c.Query("buildargs")))
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("invalid buildargs: %v", err), http.StatusBadRequest)
+		return
+	}
+	buildargs = proxyBuildArgs(buildargs)
+	labels, err := parseObject([]byte(c.Query("labels")))
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("invalid labels: %v", err), http.StatusBadRequest)
+		return
+	}
+	release := acquireBuild(c.Request.Context())
+	if release == nil {
+		return
+	}
+	defer release()
+	r, err := decompress(c.Request.Body)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dir, err := sharedTempDir("build")
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// also removed on failures (and panics), and on shutdown
+	defer removeTempDir(dir)
+	err = extractTar(dir, r)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tag := c.Query("t")
+	dockerfile := c.Query("dockerfile")
+	output := ""
+	platform := c.Query("platform")
+	if backend.BuildWorker() == "containerd" && platform == "" {
+		output = "type=image"
+		if tag != "" {
+			output += ",name=" + tag
+		}
+	}
+	sw := stream.NewWriter(c.Writer)
+	err = backend.Build(dir, sw, tag, dockerfile, output, platform, buildargs, labels)
+	if err != nil {
+		logRequest(c, "build %s: %v", tag, err)
+		sw.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if tag != "" {
+		// clients (like docker-py) look for the image ID, in the aux message
+		if image, err := backend.Image(tag); err == nil {
+			id, _ := image["Id"].(string)
+			_ = sw.WriteJSON(map[string]interface{}{"aux": map[string]string{"ID": id}})
+			short := strings.TrimPrefix(id, "sha256:")
+			if len(short) > 12 {
+				short = short[:12]
+			}
+			_ = sw.WriteJSON(map[string]string{"stream": "Successfully built " + short + "\n"})
+			_ = sw.WriteJSON(map[string]string{"stream": "Successfully tagged " + tag + "\n"})
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+func pruneBuildCache(c *gin.Context) {
+	cache := backend.BuildCache()
+	space, err := backend.BuildPrune()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var bp struct {
+		CachesDeleted  []string
+		SpaceReclaimed int64
+	}
+	bp.CachesDeleted = prunableCaches(cache)
+	bp.SpaceReclaimed = space
+	c.JSON(http.StatusOK, bp)
+}
+
+// prunableCaches returns the IDs of the build cache records, that are removed by prune
+func prunableCaches(cache []map[string]interface{}) []string {
+	caches := []string{}
+	for _, r := range cache {
+		t := r["Type"].(string)
+		if t == "internal" || t == "frontend" {
+			continue
+		}
+		caches = append(caches, r["ID"].(string))
+	}
+	return caches
+}
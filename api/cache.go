@@ -0,0 +1,146 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+// listCacheTime is how long a list is reused, when there have been no events
+// (the container status has the time since started, like "Up 5 seconds")
+const listCacheTime = 10 * time.Second
+
+// cachedList is a response of a list endpoint, with its ETag
+type cachedList struct {
+	etag       string
+	header     http.Header
+	body       []byte
+	generation uint64
+	time       time.Time
+}
+
+// maxCachedLists is how many of the lists are kept, since every query string is its own list
+const maxCachedLists = 64
+
+var listCache = struct {
+	sync.Mutex
+	m map[string]*cachedList
+}{m: map[string]*cachedList{}}
+
+// captureWriter keeps the response body, instead of writing it
+type captureWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *captureWriter) WriteHeaderNow() {}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *captureWriter) Status() int {
+	return w.status
+}
+
+func (w *captureWriter) Written() bool {
+	return w.body.Len() > 0 || w.status != 0
+}
+
+// conditionalList adds an ETag to the list responses, and replies "304 Not Modified" for If-None-Match.
+// While the events are watched, the list is reused until something changes (instead of running nerdctl).
+func conditionalList() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := backend.Namespace() + " " + c.Request.URL.Path + "?" + c.Request.URL.RawQuery
+		if strings.Contains(c.Request.Header.Get("Accept-Encoding"), "gzip") {
+			key += " gzip"
+		}
+		generation, watching := events.generation()
+		listCache.Lock()
+		cached := listCache.m[key]
+		listCache.Unlock()
+		if cached == nil || !watching || cached.generation != generation || time.Since(cached.time) > listCacheTime {
+			w := &captureWriter{ResponseWriter: c.Writer}
+			c.Writer = w
+			c.Next()
+			c.Writer = w.ResponseWriter
+			if w.status != 0 && w.status != http.StatusOK {
+				c.Writer.WriteHeader(w.status)
+				_, _ = c.Writer.Write(w.body.Bytes())
+				return
+			}
+			h := fnv.New64a()
+			_, _ = h.Write(w.body.Bytes())
+			cached = &cachedList{etag: fmt.Sprintf(`"%x"`, h.Sum64()), header: c.Writer.Header().Clone(),
+				body: w.body.Bytes(), generation: generation, time: time.Now()}
+			storeList(key, cached)
+		} else {
+			for k, v := range cached.header {
+				c.Writer.Header()[k] = v
+			}
+			c.Abort()
+		}
+		c.Writer.Header().Set("ETag", cached.etag)
+		if c.Request.Header.Get("If-None-Match") == cached.etag {
+			c.Writer.Header().Del("Content-Encoding")
+			c.Writer.Header().Del("Content-Length")
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.Data(http.StatusOK, c.Writer.Header().Get("Content-Type"), cached.body)
+	}
+}
+
+// storeList caches the list, dropping the ones that can't be reused anymore (from an older
+// generation, or too old), and the oldest ones when there are too many
+func storeList(key string, list *cachedList) {
+	listCache.Lock()
+	defer listCache.Unlock()
+	for k, cached := range listCache.m {
+		if cached.generation != list.generation || time.Since(cached.time) > listCacheTime {
+			delete(listCache.m, k)
+		}
+	}
+	for len(listCache.m) >= maxCachedLists {
+		oldest := ""
+		for k, cached := range listCache.m {
+			if oldest == "" || cached.time.Before(listCache.m[oldest].time) {
+				oldest = k
+			}
+		}
+		delete(listCache.m, oldest)
+	}
+	listCache.m[key] = list
+}
@@ -0,0 +1,64 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreList(t *testing.T) {
+	listCache.Lock()
+	saved := listCache.m
+	listCache.m = map[string]*cachedList{}
+	listCache.Unlock()
+	defer func() {
+		listCache.Lock()
+		listCache.m = saved
+		listCache.Unlock()
+	}()
+
+	now := time.Now()
+	storeList("old generation", &cachedList{generation: 1, time: now})
+	storeList("expired", &cachedList{generation: 2, time: now.Add(-2 * listCacheTime)})
+	storeList("current", &cachedList{generation: 2, time: now})
+	if _, ok := listCache.m["old generation"]; ok {
+		t.Error("the list of an older generation was kept")
+	}
+	storeList("another", &cachedList{generation: 2, time: now})
+	if _, ok := listCache.m["expired"]; ok {
+		t.Error("the expired list was kept")
+	}
+	if len(listCache.m) != 2 {
+		t.Errorf("%d lists cached, want 2", len(listCache.m))
+	}
+
+	// every query string is a list, so only the newest are kept
+	for i := 0; i < 2*maxCachedLists; i++ {
+		storeList(fmt.Sprintf("query %d", i), &cachedList{generation: 2, time: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+	if len(listCache.m) != maxCachedLists {
+		t.Errorf("%d lists cached, want %d", len(listCache.m), maxCachedLists)
+	}
+	if _, ok := listCache.m[fmt.Sprintf("query %d", 2*maxCachedLists-1)]; !ok {
+		t.Error("the newest list was dropped")
+	}
+	if _, ok := listCache.m["query 0"]; ok {
+		t.Error("the oldest list was kept")
+	}
+}
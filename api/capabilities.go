@@ -0,0 +1,127 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// capability is how well a route or field is translated: "supported", "partial",
+// "stubbed" (accepted, but does nothing) or "unsupported"
+type capability struct {
+	Status string
+	Note   string `json:",omitempty"`
+}
+
+// routeCapabilities are the routes that are not fully supported, the others are
+var routeCapabilities = map[string]capability{
+	"GET /system/df":                   {"partial", "the sizes are not calculated"},
+	"POST /auth":                       {"partial", "the credentials are only checked, and not stored"},
+	"POST /networks/{name}/connect":    {"partial", "only for containers that are not started yet (they are created again)"},
+	"POST /networks/{name}/disconnect": {"partial", "only for containers that are not started yet (they are created again)"},
+	"GET /containers/{name}/logs":      {"partial", "only for the json-file and journald logging drivers"},
+	"GET /images/{name}/history":       {"partial", "only the top layer has an image ID"},
+	"POST /containers/create":          {"partial", "see HostConfig, for the fields"},
+}
+
+// hostConfigFields are all the fields of HostConfig, in the Docker API
+var hostConfigFields = []string{
+	"Annotations", "AutoRemove", "Binds", "BlkioDeviceReadBps", "BlkioDeviceReadIOps", "BlkioDeviceWriteBps",
+	"BlkioDeviceWriteIOps", "BlkioWeight", "BlkioWeightDevice", "CapAdd", "CapDrop", "Cgroup", "CgroupParent",
+	"CgroupnsMode", "ConsoleSize", "ContainerIDFile", "CpuCount", "CpuPercent", "CpuPeriod", "CpuQuota",
+	"CpuRealtimePeriod", "CpuRealtimeRuntime", "CpuShares", "CpusetCpus", "CpusetMems", "DeviceCgroupRules",
+	"DeviceRequests", "Devices", "Dns", "DnsOptions", "DnsSearch", "ExtraHosts", "GroupAdd", "IOMaximumBandwidth",
+	"IOMaximumIOps", "Init", "IpcMode", "Isolation", "KernelMemoryTCP", "Links", "LogConfig", "MaskedPaths",
+	"Memory", "MemoryReservation", "MemorySwap", "MemorySwappiness", "Mounts", "NanoCpus", "NetworkMode",
+	"OomKillDisable", "OomScoreAdj", "PidMode", "PidsLimit", "PortBindings", "Privileged", "PublishAllPorts",
+	"ReadonlyPaths", "ReadonlyRootfs", "RestartPolicy", "Runtime", "SecurityOpt", "ShmSize", "StorageOpt",
+	"Sysctls", "Tmpfs", "UTSMode", "Ulimits", "UsernsMode", "VolumeDriver", "VolumesFrom",
+}
+
+// hostConfigCapabilities are the fields that are supported, but not fully
+var hostConfigCapabilities = map[string]capability{
+	"Mounts":        {"partial", "the VolumeOptions are ignored"},
+	"RestartPolicy": {"partial", "needs the containerd restart monitor, or nerdctld --supervise"},
+	"ConsoleSize":   {"stubbed", "the size is set with resize instead"},
+	"UsernsMode":    {"partial", "only host (without user namespaces), and not with rootless"},
+}
+
+// reRouteParam is a parameter of a gin route, like ":name" (or "*name")
+var reRouteParam = regexp.MustCompile(`[:*](\w+)`)
+
+// getCapabilities lists how the Docker API routes and HostConfig fields are translated,
+// generated from the routes of the router, for the clients to feature-detect with
+func getCapabilities(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		type route struct {
+			Method string
+			Path   string
+			capability
+		}
+		type field struct {
+			Field string
+			capability
+		}
+		routes := []route{}
+		extensions := []route{}
+		for _, ri := range r.Routes() {
+			path := reRouteParam.ReplaceAllString(ri.Path, "{$1}")
+			if p, ok := strings.CutPrefix(path, "/nerdctld/"); ok {
+				extensions = append(extensions, route{ri.Method, "/nerdctld/" + p, capability{Status: "supported"}})
+				continue
+			}
+			path = strings.TrimPrefix(path, "/{ver}")
+			cap, ok := routeCapabilities[ri.Method+" "+path]
+			if !ok {
+				cap = capability{Status: "supported"}
+			}
+			routes = append(routes, route{ri.Method, path, cap})
+		}
+		for _, list := range [][]route{routes, extensions} {
+			sort.Slice(list, func(i, j int) bool {
+				if list[i].Path != list[j].Path {
+					return list[i].Path < list[j].Path
+				}
+				return list[i].Method < list[j].Method
+			})
+		}
+		hc, _ := reflect.TypeOf(containerCreateConfig{}).FieldByName("HostConfig")
+		supported := jsonFields(hc.Type)
+		fields := []field{}
+		for _, name := range hostConfigFields {
+			cap, ok := hostConfigCapabilities[name]
+			if !ok {
+				cap = capability{Status: "unsupported"}
+				if _, ok := supported[name]; ok {
+					cap.Status = "supported"
+				}
+			}
+			fields = append(fields, field{name, cap})
+		}
+		c.JSON(http.StatusOK, map[string]interface{}{
+			"Routes":     routes,
+			"HostConfig": fields,
+			"Extensions": extensions,
+		})
+	}
+}
@@ -0,0 +1,2003 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+func maybeArray(any interface{}) []string {
+	if a, ok := any.([]string); ok {
+		return a
+	} else if s, ok := any.(string); ok {
+		return []string{s}
+	}
+	return []string{}
+}
+
+func addSlash(names []string) []string {
+	result := []string{}
+	for _, name := range names {
+		result = append(result, "/"+name)
+	}
+	return result
+}
+
+// containerStates maps the start of the nerdctl status, to the docker state
+var containerStates = []struct {
+	prefix string
+	state  string
+}{
+	{"Up", "running"},
+	{"Paused", "paused"},
+	{"Pausing", "paused"},
+	{"Restarting", "restarting"},
+	{"Exited", "exited"},
+	{"Stopped", "exited"},
+	{"Created", "created"},
+	{"Removal", "removing"},
+	{"Dead", "dead"},
+	{"Unknown", "dead"},
+}
+
+// getState returns the docker state (like "running" or "exited"), for the nerdctl status
+func getState(status string) string {
+	for _, s := range containerStates {
+		if strings.HasPrefix(status, s.prefix) {
+			return s.state
+		}
+	}
+	return ""
+}
+
+// isState checks that it is one of the docker states
+func isState(state string) bool {
+	for _, s := range containerStates {
+		if s.state == state {
+			return true
+		}
+	}
+	return false
+}
+
+// getStatus returns the group of the status ("Running", "Paused" or "Stopped"), for info
+func getStatus(status string) string {
+	switch getState(status) {
+	case "running", "restarting":
+		return "Running"
+	case "paused":
+		return "Paused"
+	case "exited", "created", "dead", "removing":
+		return "Stopped"
+	}
+	return status
+}
+
+// humanDuration returns the duration in words, like the status of docker ps
+func humanDuration(d time.Duration) string {
+	if seconds := int(d.Seconds()); seconds < 1 {
+		return "Less than a second"
+	} else if seconds == 1 {
+		return "1 second"
+	} else if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes == 1 {
+		return "About a minute"
+	} else if minutes < 60 {
+		return fmt.Sprintf("%d minutes", minutes)
+	} else if hours := int(d.Round(time.Hour).Hours()); hours == 1 {
+		return "About an hour"
+	} else if hours < 48 {
+		return fmt.Sprintf("%d hours", hours)
+	} else if hours < 24*7*2 {
+		return fmt.Sprintf("%d days", hours/24)
+	} else if hours < 24*30*2 {
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	} else if hours < 24*365*2 {
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
+
+// inspectStatus adds the uptime and the health to the "Up" status, like docker ps
+// (and a paused container is "Up 5 minutes (Paused)")
+func inspectStatus(status string, inspect map[string]interface{}) string {
+	paused := status == "Paused"
+	if status != "Up" && !paused {
+		return status
+	}
+	status = "Up"
+	state, _ := inspect["State"].(map[string]interface{})
+	if startedAt, ok := state["StartedAt"].(string); ok {
+		if started, err := time.Parse(time.RFC3339Nano, startedAt); err == nil && !started.IsZero() {
+			status += " " + humanDuration(time.Since(started))
+		}
+	}
+	if paused {
+		return status + " (Paused)"
+	}
+	if health, ok := state["Health"].(map[string]interface{}); ok {
+		if s, _ := health["Status"].(string); s != "" && s != "none" {
+			if s == "starting" {
+				s = "health: starting"
+			}
+			status += " (" + s + ")"
+		}
+	}
+	return status
+}
+
+// healthStatus returns the State.Health.Status of inspect, or "none" without a healthcheck
+func healthStatus(inspect map[string]interface{}) string {
+	state, _ := inspect["State"].(map[string]interface{})
+	health, _ := state["Health"].(map[string]interface{})
+	if status, _ := health["Status"].(string); status != "" {
+		return status
+	}
+	return "none"
+}
+
+// healthRefresh is how often (in seconds) the health of containers is inspected again
+const healthRefresh = 2
+
+// refreshHealth inspects the containers with a healthcheck again, since the health
+// changes without any event (and the inspect is cached until an event)
+func refreshHealth(updated map[string]string, inspects map[string]map[string]interface{}) {
+	stale := map[string]string{}
+	bucket := strconv.FormatInt(time.Now().Unix()/healthRefresh, 10)
+	for id, inspect := range inspects {
+		if healthStatus(inspect) != "none" {
+			stale[id] = updated[id] + "@" + bucket
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+	// keeping the cached ones, if the refresh fails
+	refreshed, _ := backend.InspectContainers(stale)
+	for id, inspect := range refreshed {
+		inspects[id] = inspect
+	}
+}
+
+// containerUpdates returns what changes whenever the containers from ps do, by ID, for the
+// inspect cache: the state (not the status, like "Up 2 seconds", which changes all the time)
+// and the time of the last event
+func containerUpdates(containers []map[string]interface{}) map[string]string {
+	updated := map[string]string{}
+	for id, status := range containerStatuses(containers) {
+		updated[id] = getState(status) + "@" + events.updated(id)
+	}
+	return updated
+}
+
+// containerStatuses returns the status of the containers from ps, by ID
+// (skipping anything without them, rather than panicking in a background loop)
+func containerStatuses(containers []map[string]interface{}) map[string]string {
+	statuses := map[string]string{}
+	for _, container := range containers {
+		id, _ := container["ID"].(string)
+		status, _ := container["Status"].(string)
+		if id != "" {
+			statuses[id] = status
+		}
+	}
+	return statuses
+}
+
+func lenStatus(containers []map[string]interface{}, status string) int {
+	count := 0
+	for _, container := range containers {
+		if s, _ := container["Status"].(string); status == getStatus(s) {
+			count++
+		}
+	}
+	return count
+}
+
+type port struct {
+	IP          string `json:"IP,omitempty"`
+	PrivatePort uint16 `json:"PrivatePort"`
+	PublicPort  uint16 `json:"PublicPort,omitempty"`
+	Type        string `json:"Type"`
+}
+
+// stringMap converts a JSON object, to a map of strings
+func stringMap(any interface{}) map[string]string {
+	result := map[string]string{}
+	if m, ok := any.(map[string]interface{}); ok {
+		for k, v := range m {
+			if s, ok := v.(string); ok {
+				result[k] = s
+			}
+		}
+	}
+	return result
+}
+
+// inspectPorts converts the NetworkSettings.Ports of inspect, to a list of ports
+func inspectPorts(inspect map[string]interface{}) []port {
+	ports := []port{}
+	ns, _ := inspect["NetworkSettings"].(map[string]interface{})
+	bindings, _ := ns["Ports"].(map[string]interface{})
+	for key, value := range bindings {
+		// "80/tcp": [{"HostIp": "0.0.0.0", "HostPort": "8080"}]
+		proto := "tcp"
+		if i := strings.Index(key, "/"); i >= 0 {
+			proto = key[i+1:]
+			key = key[:i]
+		}
+		private, err := strconv.ParseUint(key, 10, 16)
+		if err != nil {
+			continue
+		}
+		hosts, _ := value.([]interface{})
+		if len(hosts) == 0 {
+			ports = append(ports, port{PrivatePort: uint16(private), Type: proto})
+			continue
+		}
+		for _, host := range hosts {
+			h, _ := host.(map[string]interface{})
+			hostIP, _ := h["HostIp"].(string)
+			hostPort, _ := h["HostPort"].(string)
+			public, _ := strconv.ParseUint(hostPort, 10, 16)
+			ports = append(ports, port{IP: hostIP, PrivatePort: uint16(private), PublicPort: uint16(public), Type: proto})
+		}
+	}
+	return ports
+}
+
+// networkNameIDs returns the network IDs, by network name
+func networkNameIDs() map[string]string {
+	ids := map[string]string{}
+	// the IDs are only informational, so the names are enough without them
+	networks, _ := backend.Networks(nil)
+	for _, network := range networks {
+		ids[network["Name"].(string)], _ = network["ID"].(string)
+	}
+	return ids
+}
+
+// inspectNetworks returns the NetworkSettings.Networks of inspect, by network name.
+// nerdctl calls them by interface ("unknown-eth0"), with the names in a label.
+// Proxies and service discovery use the NetworkID, to look up the network.
+func inspectNetworks(inspect map[string]interface{}, ids map[string]string) map[string]interface{} {
+	ns, _ := inspect["NetworkSettings"].(map[string]interface{})
+	endpoints, _ := ns["Networks"].(map[string]interface{})
+	var names []string
+	if config, ok := inspect["Config"].(map[string]interface{}); ok {
+		if label, ok := stringMap(config["Labels"])["nerdctl/networks"]; ok {
+			_ = json.Unmarshal([]byte(label), &names)
+		}
+	}
+	networks := map[string]interface{}{}
+	for key, endpoint := range endpoints {
+		if strings.HasPrefix(key, "unknown-eth") {
+			if i, err := strconv.Atoi(strings.TrimPrefix(key, "unknown-eth")); err == nil && i < len(names) {
+				key = names[i]
+			}
+		}
+		if ep, ok := endpoint.(map[string]interface{}); ok && ep["NetworkID"] == nil {
+			// the inspect can be shared with the cache, so add to a copy
+			copied := map[string]interface{}{"NetworkID": ids[key]}
+			for k, v := range ep {
+				copied[k] = v
+			}
+			endpoint = copied
+		}
+		networks[key] = endpoint
+	}
+	return networks
+}
+
+// filterNames returns the containers matching any of the name filters, which
+// are regular expressions (like "^dagger-engine-") with or without the slash
+func filterNames(containers []map[string]interface{}, filters []string) ([]map[string]interface{}, error) {
+	res := []*regexp.Regexp{}
+	for _, filter := range filters {
+		re, err := regexp.Compile(filter)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	result := []map[string]interface{}{}
+	for _, container := range containers {
+		match := false
+		for _, name := range maybeArray(container["Names"]) {
+			for _, re := range res {
+				if re.MatchString(name) || re.MatchString("/"+name) {
+					match = true
+				}
+			}
+		}
+		if match {
+			result = append(result, container)
+		}
+	}
+	return result, nil
+}
+
+func getContainers(c *gin.Context) {
+	all := c.Query("all")
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type ctr struct {
+		ID         string `json:"Id"`
+		Names      []string
+		Image      string
+		ImageID    string
+		Command    string
+		Created    int64
+		Ports      []port
+		SizeRw     int64 `json:",omitempty"`
+		SizeRootFs int64 `json:",omitempty"`
+		Labels     map[string]string
+		State      string
+		Status     string
+		HostConfig struct {
+			NetworkMode string `json:",omitempty"`
+		}
+		NetworkSettings struct {
+			Networks map[string]interface{}
+		}
+		Mounts []interface{} // MountPoint
+	}
+	ctrs := []ctr{}
+	args := filterArgs(filters, "id", "label", "status", "exited", "before", "since", "volume", "network")
+	containers, err := backend.Containers(all == "1" || all == "true", args...)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if len(filters["name"]) > 0 {
+		if containers, err = filterNames(containers, filters["name"]); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	updated := containerUpdates(containers)
+	inspects, err := backend.InspectContainers(updated)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refreshHealth(updated, inspects)
+	if HealthProbes {
+		for id, inspect := range inspects {
+			inspects[id] = withProbeHealth(id, inspect)
+		}
+	}
+	var networkIDs map[string]string
+	if len(containers) > 0 {
+		networkIDs = networkNameIDs()
+	}
+	for _, container := range containers {
+		var ctr ctr
+		ctr.ID = container["ID"].(string)
+		ctr.Names = addSlash(maybeArray(container["Names"]))
+		ctr.Image = container["Image"].(string)
+		ctr.Command = strings.Trim(container["Command"].(string), "\"")
+		if ctr.Created, err = unixTime(container["CreatedAt"].(string)); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctr.State = getState(container["Status"].(string))
+		ctr.Status = container["Status"].(string)
+		ctr.Mounts = make([]interface{}, 0)
+		ctr.Ports = []port{}
+		ctr.Labels = map[string]string{}
+		if labels, ok := container["Labels"].(string); ok {
+			ctr.Labels = splitLabels(labels)
+		}
+		ctr.NetworkSettings.Networks = map[string]interface{}{}
+		inspect, ok := inspects[ctr.ID]
+		if len(filters["health"]) > 0 && !matchValue(filters["health"], healthStatus(inspect)) {
+			continue
+		}
+		if ok {
+			if config, ok := inspect["Config"].(map[string]interface{}); ok && config["Labels"] != nil {
+				ctr.Labels = stringMap(config["Labels"])
+			}
+			ctr.Status = inspectStatus(ctr.Status, inspect)
+			// the state of inspect is more accurate, like for a restarting container
+			if state, ok := inspect["State"].(map[string]interface{}); ok {
+				if status, _ := state["Status"].(string); isState(status) {
+					ctr.State = status
+				}
+			}
+			ctr.Ports = inspectPorts(inspect)
+			ctr.NetworkSettings.Networks = inspectNetworks(inspect, networkIDs)
+			if hc, ok := inspect["HostConfig"].(map[string]interface{}); ok {
+				ctr.HostConfig.NetworkMode, _ = hc["NetworkMode"].(string)
+			}
+			if mounts, ok := inspect["Mounts"].([]interface{}); ok {
+				ctr.Mounts = mounts
+			}
+		}
+		ctrs = append(ctrs, ctr)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, ctrs)
+}
+
+// fillHostConfig adds the host config that nerdctl does not report, from the rest
+// of the inspect, so that the container can be recreated with the same config
+func fillHostConfig(inspect map[string]interface{}, hc map[string]interface{}) {
+	labels := map[string]string{}
+	if config, ok := inspect["Config"].(map[string]interface{}); ok {
+		labels = stringMap(config["Labels"])
+	}
+	if _, ok := hc["Binds"]; !ok {
+		binds := []string{}
+		mounts, _ := inspect["Mounts"].([]interface{})
+		for _, m := range mounts {
+			mount, _ := m.(map[string]interface{})
+			source, _ := mount["Source"].(string)
+			if name, _ := mount["Name"].(string); name != "" {
+				source = name
+			}
+			destination, _ := mount["Destination"].(string)
+			if source == "" || destination == "" {
+				continue
+			}
+			bind := source + ":" + destination
+			if rw, ok := mount["RW"].(bool); ok && !rw {
+				bind += ":ro"
+			}
+			binds = append(binds, bind)
+		}
+		hc["Binds"] = binds
+	}
+	if _, ok := hc["PortBindings"]; !ok {
+		bindings := map[string]interface{}{}
+		if ns, ok := inspect["NetworkSettings"].(map[string]interface{}); ok {
+			if ports, ok := ns["Ports"].(map[string]interface{}); ok {
+				for port, binding := range ports {
+					if binding != nil {
+						bindings[port] = binding
+					}
+				}
+			}
+		}
+		hc["PortBindings"] = bindings
+	}
+	if _, ok := hc["RestartPolicy"]; !ok {
+		// containerd restart policy, like "on-failure:3"
+		name, count := "no", 0
+		if policy := labels["containerd.io/restart.policy"]; policy != "" {
+			kv := strings.SplitN(policy, ":", 2)
+			name = kv[0]
+			if len(kv) == 2 {
+				count, _ = strconv.Atoi(kv[1])
+			}
+		}
+		hc["RestartPolicy"] = map[string]interface{}{"Name": name, "MaximumRetryCount": count}
+	}
+	if _, ok := hc["NetworkMode"]; !ok {
+		var names []string
+		_ = json.Unmarshal([]byte(labels["nerdctl/networks"]), &names)
+		if len(names) > 0 {
+			hc["NetworkMode"] = names[0]
+		}
+	}
+}
+
+// fillNetworkSettings adds the address of the first network, like the default bridge
+func fillNetworkSettings(ns map[string]interface{}, networks map[string]interface{}) {
+	if ip, _ := ns["IPAddress"].(string); ip != "" {
+		return
+	}
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ep, _ := networks[name].(map[string]interface{})
+		if ip, _ := ep["IPAddress"].(string); ip != "" {
+			for _, key := range []string{"IPAddress", "IPPrefixLen", "Gateway", "MacAddress"} {
+				if value, ok := ep[key]; ok {
+					ns[key] = value
+				}
+			}
+			return
+		}
+	}
+}
+
+// defaultCPUPeriod is the cpu period of "--cpus", which is reported as NanoCpus (like docker)
+const defaultCPUPeriod = 100000
+
+// fillResources adds the resources of the container from the OCI runtime spec, since nerdctl
+// does not report them, so that the limits that were set at create (or update) are shown
+func fillResources(spec map[string]interface{}, hc map[string]interface{}) {
+	values := map[string]interface{}{
+		"Memory": int64(0), "MemoryReservation": int64(0), "MemorySwap": int64(0), "NanoCpus": int64(0),
+		"CpuShares": int64(0), "CpuQuota": int64(0), "CpuPeriod": int64(0), "CpusetCpus": "", "CpusetMems": "",
+		"BlkioWeight": 0, "PidsLimit": nil, "OomKillDisable": false, "OomScoreAdj": 0,
+	}
+	if spec != nil {
+		linux, _ := spec["linux"].(map[string]interface{})
+		resources, _ := linux["resources"].(map[string]interface{})
+		number := func(m map[string]interface{}, key string) int64 {
+			n, _ := m[key].(float64)
+			return int64(n)
+		}
+		memory, _ := resources["memory"].(map[string]interface{})
+		values["Memory"] = number(memory, "limit")
+		values["OomKillDisable"], _ = memory["disableOOMKiller"].(bool)
+		values["MemoryReservation"] = number(memory, "reservation")
+		values["MemorySwap"] = number(memory, "swap")
+		cpu, _ := resources["cpu"].(map[string]interface{})
+		values["CpuShares"] = number(cpu, "shares")
+		quota, period := number(cpu, "quota"), number(cpu, "period")
+		if quota > 0 && period == defaultCPUPeriod {
+			values["NanoCpus"] = quota * 1e9 / period
+		} else {
+			values["CpuQuota"], values["CpuPeriod"] = quota, period
+		}
+		values["CpusetCpus"], _ = cpu["cpus"].(string)
+		values["CpusetMems"], _ = cpu["mems"].(string)
+		blockIO, _ := resources["blockIO"].(map[string]interface{})
+		values["BlkioWeight"] = number(blockIO, "weight")
+		pids, _ := resources["pids"].(map[string]interface{})
+		if limit := number(pids, "limit"); limit != 0 {
+			values["PidsLimit"] = limit
+		}
+		process, _ := spec["process"].(map[string]interface{})
+		values["OomScoreAdj"] = number(process, "oomScoreAdj")
+	}
+	for key, value := range values {
+		if _, ok := hc[key]; !ok {
+			hc[key] = value
+		}
+	}
+}
+
+// annotationLabelPrefix is the prefix of labels, that are passed as annotations instead
+const annotationLabelPrefix = "nerdctld/annotation/"
+
+// fillAnnotations adds the OCI annotations of the container, without the internal ones
+func fillAnnotations(spec map[string]interface{}, hc map[string]interface{}) {
+	if _, ok := hc["Annotations"]; ok || spec == nil {
+		return
+	}
+	annotations := map[string]interface{}{}
+	values, _ := spec["annotations"].(map[string]interface{})
+	for key, value := range values {
+		if strings.HasPrefix(key, "nerdctl/") || strings.HasPrefix(key, "containerd.io/") || strings.HasPrefix(key, "io.containerd.") {
+			continue
+		}
+		annotations[key] = value
+	}
+	if len(annotations) > 0 {
+		hc["Annotations"] = annotations
+	}
+}
+
+// fillConfig adds the environment, exposed ports, domainname, stop signal and mac address,
+// when nerdctl does not report them
+func fillConfig(spec map[string]interface{}, inspect map[string]interface{}, config map[string]interface{}) {
+	if _, ok := config["Domainname"]; !ok {
+		config["Domainname"], _ = spec["domainname"].(string)
+	}
+	// the stop signal and timeout of create are labels, in nerdctl
+	labels := stringMap(config["Labels"])
+	if _, ok := config["StopSignal"]; !ok {
+		if signal := labels["io.containerd.image.config.stop-signal"]; signal != "" {
+			config["StopSignal"] = signal
+		}
+	}
+	if _, ok := config["StopTimeout"]; !ok {
+		if timeout, err := strconv.Atoi(labels["nerdctl/stop-timeout"]); err == nil {
+			config["StopTimeout"] = timeout
+		}
+	}
+	// like docker before 1.44, when it was moved to the networks
+	if _, ok := config["MacAddress"]; !ok {
+		ns, _ := inspect["NetworkSettings"].(map[string]interface{})
+		if mac, _ := ns["MacAddress"].(string); mac != "" {
+			config["MacAddress"] = mac
+		}
+	}
+	if config["Env"] == nil {
+		env := []interface{}{}
+		process, _ := spec["process"].(map[string]interface{})
+		if values, ok := process["env"].([]interface{}); ok {
+			env = values
+		}
+		config["Env"] = env
+	}
+	if config["ExposedPorts"] == nil {
+		exposed := map[string]interface{}{}
+		ns, _ := inspect["NetworkSettings"].(map[string]interface{})
+		ports, _ := ns["Ports"].(map[string]interface{})
+		for port := range ports {
+			exposed[port] = struct{}{}
+		}
+		config["ExposedPorts"] = exposed
+	}
+}
+
+// zeroTime is how docker shows the times that are not set, like FinishedAt of a running container
+const zeroTime = "0001-01-01T00:00:00Z"
+
+// fillState adds the fields of the state that nerdctl does not report, since compose
+// and the wait strategies (of testcontainers) parse them
+func fillState(id string, state map[string]interface{}) {
+	for key, value := range map[string]interface{}{"Running": false, "Paused": false, "Restarting": false,
+		"OOMKilled": false, "Dead": false, "Pid": 0, "ExitCode": 0, "Error": "", "FinishedAt": zeroTime} {
+		if _, ok := state[key]; !ok {
+			state[key] = value
+		}
+	}
+	if s, _ := state["StartedAt"].(string); s == "" {
+		state["StartedAt"] = zeroTime
+		pid, _ := state["Pid"].(float64)
+		if started, err := backend.ProcessStartTime(int(pid)); err == nil {
+			state["StartedAt"] = started.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if s, _ := state["FinishedAt"].(string); s == "" {
+		state["FinishedAt"] = zeroTime
+	}
+	if running, _ := state["Running"].(bool); !running && restarting(id) {
+		// docker shows restarting containers as running too
+		state["Running"] = true
+		state["Restarting"] = true
+		state["Status"] = "restarting"
+	}
+}
+
+func inspectContainer(c *gin.Context) {
+	name := c.Param("name")
+	container, err := backend.Container(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	// portainer assumes that this field is available, or: panic
+	hc, ok := container["HostConfig"].(map[string]interface{})
+	if !ok {
+		hc = map[string]interface{}{}
+		container["HostConfig"] = hc
+	}
+	resources, ok := hc["Resources"].(map[string]interface{})
+	if !ok {
+		resources = map[string]interface{}{}
+		hc["Resources"] = resources
+	}
+	if _, ok := resources["DeviceRequests"].([]interface{}); !ok {
+		resources["DeviceRequests"] = make([]interface{}, 0)
+	}
+	if ns, ok := container["NetworkSettings"].(map[string]interface{}); ok {
+		networks := inspectNetworks(container, networkNameIDs())
+		ns["Networks"] = networks
+		fillNetworkSettings(ns, networks)
+	}
+	fillHostConfig(container, hc)
+	// the spec is nil if it can't be read, and then the defaults are used
+	spec, err := backend.ContainerSpec(name)
+	if err != nil {
+		logRequest(c, "spec %s: %v", name, err)
+	}
+	fillResources(spec, hc)
+	fillAnnotations(spec, hc)
+	if config, ok := container["Config"].(map[string]interface{}); ok {
+		fillConfig(spec, container, config)
+	}
+	if state, ok := container["State"].(map[string]interface{}); ok {
+		id, _ := container["Id"].(string)
+		fillState(id, state)
+		if HealthProbes {
+			if health, ok := probeHealth(id); ok {
+				state["Health"] = health
+			}
+		}
+	}
+	if image, ok := container["Image"].(string); ok && !strings.HasPrefix(image, "sha256:") {
+		// docker has the image id here, and the image name in the config
+		if config, ok := container["Config"].(map[string]interface{}); ok && config["Image"] == nil {
+			config["Image"] = image
+		}
+		if img, err := backend.Image(image); err == nil {
+			if id, ok := img["Id"].(string); ok {
+				container["Image"] = id
+			}
+		}
+	}
+	if config, ok := container["Config"].(map[string]interface{}); ok {
+		// new in 1.44 API: StartInterval
+		if hc, ok := config["Healthcheck"].(map[string]interface{}); ok {
+			if _, ok := hc["StartInterval"]; !ok {
+				hc["StartInterval"] = 0
+			}
+		}
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, container)
+}
+
+// logsTime converts the unix timestamps (with fraction) that clients send, for nerdctl
+func logsTime(s string) string {
+	if s == "0" {
+		return s
+	}
+	if t, ok := parseEventTime(s); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+func getContainerLogs(c *gin.Context) {
+	name := c.Param("name")
+	isTrue := func(key string) bool {
+		v := c.Query(key)
+		return v == "1" || v == "true"
+	}
+	if !isTrue("stdout") && !isTrue("stderr") {
+		httpError(c.Writer, "Bad parameters: you must choose at least one stream", http.StatusBadRequest)
+		return
+	}
+	container, err := backend.Container(name)
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return
+	}
+	tty := false
+	if config, ok := container["Config"].(map[string]interface{}); ok {
+		tty, _ = config["Tty"].(bool)
+	}
+	logConfig := backend.ContainerLogConfig(container)
+	opts := backend.LogsOptions{
+		Follow:     isTrue("follow"),
+		Timestamps: isTrue("timestamps"),
+		Tail:       c.Query("tail"),
+		Since:      logsTime(c.Query("since")),
+		Until:      logsTime(c.Query("until")),
+		Driver:     logConfig.Driver,
+		Tag:        logConfig.Opts["tag"],
+	}
+	if !backend.LogsReadable(opts.Driver) {
+		httpError(c.Writer, "configured logging driver does not support reading", http.StatusNotImplemented)
+		return
+	}
+	// the journal has the ID of the container, not the name
+	if id, ok := container["Id"].(string); ok {
+		name = id
+	}
+	var stdout, stderr *stream.StdWriter
+	if tty {
+		c.Writer.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		stdout, stderr = stream.NewRawWriters(c.Writer)
+	} else {
+		c.Writer.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+		stdout, stderr = stream.NewStdWriters(c.Writer)
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+	var wout, werr io.Writer = io.Discard, io.Discard
+	if isTrue("stdout") {
+		wout = stdout
+	}
+	if isTrue("stderr") {
+		werr = stderr
+	}
+	if err := backend.Logs(c.Request.Context(), name, wout, werr, opts); err != nil {
+		logRequest(c, "logs %s: %v", name, err)
+	}
+}
+
+// strSlice is a command, which can be a string or an array (like the strslice of docker).
+// A null command is nil, while an empty array is not (it resets the entrypoint of the image).
+type strSlice []string
+
+func (s *strSlice) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err == nil {
+		*s = strSlice{str}
+		return nil
+	}
+	var strs []string
+	if err := json.Unmarshal(b, &strs); err != nil {
+		return err
+	}
+	*s = append(strSlice{}, strs...)
+	return nil
+}
+
+// containerCreateConfig is the request body of container create
+type containerCreateConfig struct {
+	Hostname     string
+	Domainname   string
+	MacAddress   string // deprecated in 1.44, for EndpointsConfig
+	User         string
+	Tty          bool
+	OpenStdin    bool
+	Env          []string
+	Cmd          strSlice
+	Entrypoint   strSlice
+	Image        string
+	Labels       map[string]string
+	WorkingDir   string
+	StopSignal   string
+	StopTimeout  *int
+	Volumes      map[string]struct{}
+	ExposedPorts map[string]struct{}
+	Healthcheck  *struct {
+		Test        []string
+		Interval    time.Duration
+		Timeout     time.Duration
+		StartPeriod time.Duration
+		Retries     int
+	}
+	HostConfig struct {
+		Binds        []string
+		VolumesFrom  []string
+		NetworkMode  string
+		PortBindings map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string
+		}
+		RestartPolicy struct {
+			Name              string
+			MaximumRetryCount int
+		}
+		AutoRemove     bool
+		CapAdd         []string
+		CapDrop        []string
+		ExtraHosts     []string
+		DNS            []string `json:"Dns"`
+		DNSSearch      []string `json:"DnsSearch"`
+		DNSOptions     []string `json:"DnsOptions"`
+		Privileged     bool
+		Init           *bool
+		CgroupParent   string
+		ReadonlyRootfs bool
+		Sysctls        map[string]string
+		Ulimits        []struct {
+			Name string
+			Soft int64
+			Hard int64
+		}
+		PidMode   string
+		IpcMode   string
+		Runtime   string
+		LogConfig struct {
+			Type   string
+			Config map[string]string
+		}
+		PublishAllPorts bool
+		Tmpfs           map[string]string
+		SecurityOpt     []string
+		Devices         []struct {
+			PathOnHost        string
+			PathInContainer   string
+			CgroupPermissions string
+		}
+		ShmSize           int64
+		Memory            int64
+		MemoryReservation int64
+		MemorySwap        int64
+		NanoCPUs          int64 `json:"NanoCpus"`
+		CPUShares         int64 `json:"CpuShares"`
+		CPUQuota          int64 `json:"CpuQuota"`
+		CPUPeriod         int64 `json:"CpuPeriod"`
+		CpusetCpus        string
+		CpusetMems        string
+		BlkioWeight       uint16
+		PidsLimit         *int64
+		OomScoreAdj       int
+		OomKillDisable    *bool
+		Mounts            []struct {
+			Type        string
+			Source      string
+			Target      string
+			ReadOnly    bool
+			BindOptions struct {
+				Propagation string
+			}
+			TmpfsOptions struct {
+				SizeBytes int64
+				Mode      uint32
+			}
+		}
+		Annotations  map[string]string
+		GroupAdd     []string
+		UsernsMode   string
+		CgroupnsMode string
+	}
+	NetworkingConfig struct {
+		EndpointsConfig map[string]interface{}
+	}
+}
+
+// ignoredFields are sent by the docker client (with their defaults), so they are not warned about
+var ignoredFields = map[string]bool{
+	"AttachStdin": true, "AttachStdout": true, "AttachStderr": true, "StdinOnce": true, "ArgsEscaped": true,
+	"HostConfig.ConsoleSize": true,
+}
+
+// jsonFields returns the names of the fields of the struct type, as they are in JSON
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Name
+		if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag != "" {
+			name = tag
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// isZero checks if the JSON value is the default, like false or an empty list
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// createWarnings returns the warnings for the fields of the create request that are not
+// supported (so ignored), since the users would otherwise not know about them
+func createWarnings(body []byte) []string {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+	warnings := []string{}
+	var check func(raw map[string]interface{}, t reflect.Type, prefix string)
+	check = func(raw map[string]interface{}, t reflect.Type, prefix string) {
+		fields := jsonFields(t)
+		keys := []string{}
+		for key := range raw {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := raw[key]
+			if ft, ok := fields[key]; ok {
+				if m, ok := value.(map[string]interface{}); ok && key == "HostConfig" {
+					check(m, ft, prefix+key+".")
+				}
+				continue
+			}
+			if isZero(value) || ignoredFields[prefix+key] {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("%s%s is not supported by nerdctld, and was ignored", prefix, key))
+		}
+	}
+	check(raw, reflect.TypeOf(containerCreateConfig{}), "")
+	return warnings
+}
+
+// publishArg returns the port binding, as a nerdctl --publish argument
+func publishArg(hostIP string, hostPort string, containerPort string) string {
+	switch {
+	case hostIP != "":
+		return hostIP + ":" + hostPort + ":" + containerPort
+	case hostPort != "":
+		return hostPort + ":" + containerPort
+	}
+	// a random host port
+	return containerPort
+}
+
+// shellJoin quotes the arguments for the shell, when needed
+func shellJoin(args []string) string {
+	quoted := []string{}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]#~!{}") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted = append(quoted, arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// containerEnv returns the environment, without the variables that have no value (like docker),
+// since nerdctl would take those from the environment of nerdctld
+func containerEnv(env []string) []string {
+	result := []string{}
+	for _, e := range env {
+		if strings.Contains(e, "=") {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// containerCommand returns the command of the container, which is the command of the image
+// when the entrypoint is reset (an empty array) without a command, like in docker
+func containerCommand(config containerCreateConfig) []string {
+	if config.Entrypoint != nil && len(config.Entrypoint) == 0 && len(config.Cmd) == 0 {
+		if image, err := backend.Image(config.Image); err == nil {
+			imageConfig, _ := image["Config"].(map[string]interface{})
+			if cmd, ok := imageConfig["Cmd"].([]interface{}); ok {
+				return stringArray(cmd)
+			}
+		}
+	}
+	return config.Cmd
+}
+
+// isolationWarnings returns the warnings for the user and cgroup namespaces of the create
+// request, that nerdctl cannot honor (there is no --userns, and rootless has its own namespaces)
+func isolationWarnings(config containerCreateConfig) []string {
+	hc := config.HostConfig
+	if hc.UsernsMode == "" && hc.CgroupnsMode != "host" {
+		return nil
+	}
+	warnings := []string{}
+	rootless := backend.Rootless()
+	switch {
+	case hc.UsernsMode == "host" && rootless:
+		warnings = append(warnings, "UsernsMode host is not supported with rootless, the container uses the user namespace of RootlessKit")
+	case hc.UsernsMode != "" && hc.UsernsMode != "host":
+		warnings = append(warnings, fmt.Sprintf("UsernsMode %s is not supported by nerdctl, and was ignored", hc.UsernsMode))
+	}
+	if hc.CgroupnsMode == "host" && rootless {
+		warnings = append(warnings, "CgroupnsMode host is not supported with rootless, the container uses the cgroup namespace of RootlessKit")
+	}
+	return warnings
+}
+
+// containerOptions converts the create config, to the nerdctl options
+func containerOptions(name string, config containerCreateConfig) backend.ContainerOptions {
+	hc := config.HostConfig
+	opts := backend.ContainerOptions{
+		Name:        name,
+		Hostname:    config.Hostname,
+		User:        config.User,
+		Env:         containerEnv(config.Env),
+		Labels:      config.Labels,
+		WorkingDir:  config.WorkingDir,
+		Entrypoint:  config.Entrypoint,
+		StopSignal:  config.StopSignal,
+		StopTimeout: config.StopTimeout,
+		Tty:         config.Tty,
+		Interactive: config.OpenStdin,
+		Volumes:     hc.Binds,
+		VolumesFrom: hc.VolumesFrom,
+		Privileged:  hc.Privileged,
+		AutoRemove:  hc.AutoRemove,
+		CapAdd:      hc.CapAdd,
+		CapDrop:     hc.CapDrop,
+		AddHosts:    hc.ExtraHosts,
+		Memory:      hc.Memory,
+		CPUs:        float64(hc.NanoCPUs) / 1e9,
+		ShmSize:     hc.ShmSize,
+		SecurityOpt: hc.SecurityOpt,
+		CPUShares:   hc.CPUShares,
+		CPUQuota:    hc.CPUQuota,
+		CPUPeriod:   hc.CPUPeriod,
+	}
+	opts.DNS = hc.DNS
+	opts.DNSSearch = hc.DNSSearch
+	opts.DNSOptions = hc.DNSOptions
+	opts.MemoryReservation = hc.MemoryReservation
+	opts.MemorySwap = hc.MemorySwap
+	opts.CpusetCpus = hc.CpusetCpus
+	opts.CpusetMems = hc.CpusetMems
+	opts.BlkioWeight = hc.BlkioWeight
+	// zero and -1 both mean unlimited, which is the default
+	if hc.PidsLimit != nil && *hc.PidsLimit > 0 {
+		opts.PidsLimit = *hc.PidsLimit
+	}
+	opts.OomScoreAdj = hc.OomScoreAdj
+	if hc.OomKillDisable != nil {
+		opts.OomKillDisable = *hc.OomKillDisable
+	}
+	if hc.Init != nil {
+		opts.Init = *hc.Init
+	}
+	opts.CgroupParent = hc.CgroupParent
+	opts.GroupAdd = hc.GroupAdd
+	opts.Cgroupns = hc.CgroupnsMode
+	opts.ReadOnly = hc.ReadonlyRootfs
+	for key, value := range hc.Sysctls {
+		opts.Sysctls = append(opts.Sysctls, key+"="+value)
+	}
+	sort.Strings(opts.Sysctls)
+	for _, ulimit := range hc.Ulimits {
+		opts.Ulimits = append(opts.Ulimits, fmt.Sprintf("%s=%d:%d", ulimit.Name, ulimit.Soft, ulimit.Hard))
+	}
+	opts.Pid = hc.PidMode
+	// "private" and "shareable" are the defaults, which nerdctl does not accept
+	if hc.IpcMode == "host" || strings.HasPrefix(hc.IpcMode, "container:") {
+		opts.IPC = hc.IpcMode
+	}
+	// "runc" is the docker name, of the default runtime
+	if hc.Runtime != "" && hc.Runtime != "runc" {
+		opts.Runtime = hc.Runtime
+	}
+	if hc.LogConfig.Type != "" && hc.LogConfig.Type != "json-file" {
+		opts.LogDriver = hc.LogConfig.Type
+	}
+	for key, value := range hc.LogConfig.Config {
+		opts.LogOpts = append(opts.LogOpts, key+"="+value)
+	}
+	sort.Strings(opts.LogOpts)
+	if check := config.Healthcheck; check != nil {
+		opts.Healthcheck = &backend.Healthcheck{
+			Interval:    check.Interval,
+			Timeout:     check.Timeout,
+			StartPeriod: check.StartPeriod,
+			Retries:     check.Retries,
+		}
+		// ["NONE"], ["CMD", args...] or ["CMD-SHELL", command]
+		if len(check.Test) > 0 {
+			switch check.Test[0] {
+			case "NONE":
+				opts.Healthcheck.Disable = true
+			case "CMD":
+				opts.Healthcheck.Cmd = shellJoin(check.Test[1:])
+			case "CMD-SHELL":
+				opts.Healthcheck.Cmd = strings.Join(check.Test[1:], " ")
+			}
+		}
+	}
+	for _, device := range hc.Devices {
+		target := device.PathInContainer
+		if target == "" {
+			target = device.PathOnHost
+		}
+		arg := device.PathOnHost + ":" + target
+		if device.CgroupPermissions != "" && device.CgroupPermissions != "rwm" {
+			arg += ":" + device.CgroupPermissions
+		}
+		opts.Devices = append(opts.Devices, arg)
+	}
+	// the OCI annotations, also from labels for older clients (like "nerdctld/annotation/key=value")
+	for key, value := range hc.Annotations {
+		opts.Annotations = append(opts.Annotations, key+"="+value)
+	}
+	for key, value := range opts.Labels {
+		if key, ok := strings.CutPrefix(key, annotationLabelPrefix); ok && hc.Annotations[key] == "" {
+			opts.Annotations = append(opts.Annotations, key+"="+value)
+		}
+	}
+	sort.Strings(opts.Annotations)
+	// the internal labels come back, when recreating a container from inspect
+	for key := range opts.Labels {
+		if strings.HasPrefix(key, "nerdctl/") || strings.HasPrefix(key, "nerdctld/") || strings.HasPrefix(key, "containerd.io/") {
+			delete(opts.Labels, key)
+		}
+	}
+	if HealthProbes && opts.Healthcheck != nil {
+		// nerdctld runs the healthcheck, instead of nerdctl
+		if data, err := json.Marshal(opts.Healthcheck); err == nil {
+			labels := map[string]string{healthcheckLabel: string(data)}
+			for k, v := range opts.Labels {
+				labels[k] = v
+			}
+			opts.Labels = labels
+		}
+		opts.Healthcheck = nil
+	}
+	for containerPort, bindings := range hc.PortBindings {
+		for _, binding := range bindings {
+			opts.Publish = append(opts.Publish, publishArg(binding.HostIP, binding.HostPort, containerPort))
+		}
+	}
+	if hc.PublishAllPorts {
+		for containerPort := range config.ExposedPorts {
+			if len(hc.PortBindings[containerPort]) == 0 {
+				opts.Publish = append(opts.Publish, containerPort)
+			}
+		}
+	}
+	sort.Strings(opts.Publish)
+	for _, mount := range hc.Mounts {
+		arg := "type=" + mount.Type
+		if mount.Source != "" {
+			arg += ",source=" + mount.Source
+		}
+		arg += ",target=" + mount.Target
+		if mount.ReadOnly {
+			arg += ",readonly"
+		}
+		if mount.BindOptions.Propagation != "" {
+			arg += ",bind-propagation=" + mount.BindOptions.Propagation
+		}
+		if mount.TmpfsOptions.SizeBytes > 0 {
+			arg += ",tmpfs-size=" + strconv.FormatInt(mount.TmpfsOptions.SizeBytes, 10)
+		}
+		if mount.TmpfsOptions.Mode != 0 {
+			arg += ",tmpfs-mode=" + strconv.FormatUint(uint64(mount.TmpfsOptions.Mode), 8)
+		}
+		opts.Mounts = append(opts.Mounts, arg)
+	}
+	// anonymous volumes ("docker run -v /path"), unless something else is mounted there
+	targets := map[string]bool{}
+	for _, bind := range hc.Binds {
+		parts := strings.Split(bind, ":")
+		if len(parts) > 1 {
+			targets[parts[1]] = true
+		} else {
+			targets[parts[0]] = true
+		}
+	}
+	for _, mount := range hc.Mounts {
+		targets[mount.Target] = true
+	}
+	for path := range hc.Tmpfs {
+		targets[path] = true
+	}
+	volumes := []string{}
+	for path := range config.Volumes {
+		if !targets[path] {
+			volumes = append(volumes, path)
+		}
+	}
+	sort.Strings(volumes)
+	opts.Volumes = append(opts.Volumes, volumes...)
+	for path, options := range hc.Tmpfs {
+		if options != "" {
+			path += ":" + options
+		}
+		opts.Tmpfs = append(opts.Tmpfs, path)
+	}
+	if hc.NetworkMode != "" && hc.NetworkMode != "default" {
+		opts.Networks = append(opts.Networks, hc.NetworkMode)
+	}
+	// compose (with API 1.44+) sends all the networks of the service at create
+	networks := []string{}
+	for network := range config.NetworkingConfig.EndpointsConfig {
+		if network != hc.NetworkMode {
+			networks = append(networks, network)
+		}
+	}
+	sort.Strings(networks)
+	opts.Networks = append(opts.Networks, networks...)
+	// static addresses ("docker run --ip"), which nerdctl only supports for one network
+	for _, endpoint := range config.NetworkingConfig.EndpointsConfig {
+		ep, _ := endpoint.(map[string]interface{})
+		ipam, _ := ep["IPAMConfig"].(map[string]interface{})
+		if ip, _ := ipam["IPv4Address"].(string); ip != "" {
+			opts.IP = ip
+		}
+		if ip, _ := ipam["IPv6Address"].(string); ip != "" {
+			opts.IP6 = ip
+		}
+		if mac, _ := ep["MacAddress"].(string); mac != "" {
+			opts.MacAddress = mac
+		}
+	}
+	if config.MacAddress != "" {
+		opts.MacAddress = config.MacAddress
+	}
+	if backend.SupportsFeature("domainname") == nil {
+		opts.Domainname = config.Domainname
+	}
+	if policy := hc.RestartPolicy.Name; policy != "" && policy != "no" {
+		opts.Restart = policy
+		if policy == "on-failure" && hc.RestartPolicy.MaximumRetryCount > 0 {
+			opts.Restart += ":" + strconv.Itoa(hc.RestartPolicy.MaximumRetryCount)
+		}
+	}
+	return opts
+}
+
+// PullMissing pulls the image on create, when it is not present (like compose does).
+// Otherwise it replies "404 No such image" like dockerd, and the client pulls it.
+var PullMissing bool
+
+// reContainerName is the valid container names, like in docker
+var reContainerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// containerByName returns the ID of the container with the name, or empty if there is none
+func containerByName(name string) string {
+	names, err := backend.ContainerNames()
+	if err != nil {
+		return ""
+	}
+	for id, n := range names {
+		if n == name {
+			return id
+		}
+	}
+	return ""
+}
+
+func createContainer(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	var config containerCreateConfig
+	if !bindJSON(c, &config) {
+		return
+	}
+	warnings := createWarnings(body)
+	if config.Image == "" {
+		httpError(c.Writer, "Config cannot be empty in order to create a container", http.StatusBadRequest)
+		return
+	}
+	// the name from inspect (when recreating) starts with a slash
+	name := strings.TrimPrefix(c.Query("name"), "/")
+	if name != "" {
+		if !reContainerName.MatchString(name) {
+			httpError(c.Writer, fmt.Sprintf("Invalid container name (%s), only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name), http.StatusBadRequest)
+			return
+		}
+		// compose looks for this message, when recreating
+		if id := containerByName(name); id != "" {
+			httpError(c.Writer, fmt.Sprintf("Conflict. The container name \"/%s\" is already in use by container \"%s\". "+
+				"You have to remove (or rename) that container to be able to reuse that name.", name, id), http.StatusConflict)
+			return
+		}
+	}
+	if config.WorkingDir != "" && !path.IsAbs(config.WorkingDir) {
+		httpError(c.Writer, fmt.Sprintf("the working directory '%s' is invalid, it needs to be an absolute path", config.WorkingDir), http.StatusBadRequest)
+		return
+	}
+	opts := containerOptions(name, config)
+	if config.Domainname != "" && opts.Domainname == "" {
+		warnings = append(warnings, fmt.Sprintf("Domainname was ignored: %v", backend.SupportsFeature("domainname")))
+	}
+	warnings = append(warnings, isolationWarnings(config)...)
+	if opts.Init {
+		// with a clearer error than nerdctl, like docker without docker-init
+		if version, _ := backend.TiniVersion(); version == "" {
+			httpError(c.Writer, "Init requires tini, which is not installed", http.StatusBadRequest)
+			return
+		}
+	}
+	// like "docker run --platform", for running amd64 images with emulation
+	opts.Platform = c.Query("platform")
+	opts.Pull = "missing"
+	if !PullMissing {
+		if _, err := backend.Image(config.Image); err != nil {
+			httpError(c.Writer, fmt.Sprintf("No such image: %s", config.Image), http.StatusNotFound)
+			return
+		}
+		opts.Pull = "never"
+	}
+	id, err := backend.CreateContainer(config.Image, containerCommand(config), opts)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	rememberCreated(id, c.Query("name"), opts.Platform, config)
+	c.JSON(http.StatusCreated, map[string]interface{}{"Id": id, "Warnings": warnings})
+}
+
+type createdContainer struct {
+	name     string
+	platform string
+	config   containerCreateConfig
+}
+
+// createdContainers remembers the config of containers that have not been started,
+// so that they can be created again when connected to another network
+var createdContainers = struct {
+	sync.Mutex
+	m map[string]createdContainer
+}{m: map[string]createdContainer{}}
+
+// savedContainer is a created container, as it is saved
+type savedContainer struct {
+	Name     string
+	Platform string
+	Config   containerCreateConfig
+}
+
+var createdSaver = newStateSaver("containers", func() interface{} {
+	createdContainers.Lock()
+	defer createdContainers.Unlock()
+	saved := map[string]savedContainer{}
+	for id, created := range createdContainers.m {
+		saved[id] = savedContainer{Name: created.name, Platform: created.platform, Config: created.config}
+	}
+	return saved
+})
+
+// loadCreated restores the containers that were created, but not started yet
+func loadCreated() error {
+	saved := map[string]savedContainer{}
+	if err := loadState("containers", &saved); err != nil {
+		return err
+	}
+	createdContainers.Lock()
+	defer createdContainers.Unlock()
+	for id, s := range saved {
+		createdContainers.m[id] = createdContainer{name: s.Name, platform: s.Platform, config: s.Config}
+	}
+	return nil
+}
+
+func rememberCreated(id string, name string, platform string, config containerCreateConfig) {
+	createdContainers.Lock()
+	defer createdContainers.Unlock()
+	createdContainers.m[id] = createdContainer{name: strings.TrimPrefix(name, "/"), platform: platform, config: config}
+	createdSaver.changed()
+}
+
+// takeCreated removes the remembered config of the container (by id, short id or name)
+func takeCreated(name string) (string, createdContainer, bool) {
+	createdContainers.Lock()
+	defer createdContainers.Unlock()
+	for id, created := range createdContainers.m {
+		if id == name || created.name == name || (len(name) >= 12 && strings.HasPrefix(id, name)) {
+			delete(createdContainers.m, id)
+			createdSaver.changed()
+			return id, created, true
+		}
+	}
+	return "", createdContainer{}, false
+}
+
+// recreateContainer removes the created container, and creates it again with the new config
+func recreateContainer(id string, created createdContainer) (string, error) {
+	if created.name == "" {
+		container, err := backend.Container(id)
+		if err != nil {
+			return "", err
+		}
+		name, _ := container["Name"].(string)
+		created.name = strings.TrimPrefix(name, "/")
+	}
+	if err := backend.RemoveContainer(id, true, false); err != nil {
+		return "", err
+	}
+	config := created.config
+	opts := containerOptions(created.name, config)
+	opts.Platform = created.platform
+	newID, err := backend.CreateContainer(config.Image, containerCommand(config), opts)
+	if err != nil {
+		return "", err
+	}
+	rememberCreated(newID, created.name, created.platform, config)
+	return newID, nil
+}
+
+// containerError returns the error of inspecting the container, with the message of dockerd
+// when it doesn't exist (other errors, like containerd being down, are kept)
+func containerError(name string, err error) error {
+	if errorStatus(err) == http.StatusNotFound {
+		return fmt.Errorf("No such container: %s", name)
+	}
+	return err
+}
+
+// containerState returns the ID and State.Status of the container, or an error if not found
+func containerState(name string) (string, string, error) {
+	container, err := backend.Container(name)
+	if err != nil {
+		return "", "", containerError(name, err)
+	}
+	id, _ := container["Id"].(string)
+	state, _ := container["State"].(map[string]interface{})
+	status, _ := state["Status"].(string)
+	return id, status, nil
+}
+
+func startContainer(c *gin.Context) {
+	name := c.Param("name")
+	id, status, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if status == "running" {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	if session := takeAttach(id); session != nil {
+		// somebody is attached, and waiting for the output
+		err = startAttached(name, session)
+	} else {
+		err = backend.StartContainer(name)
+	}
+	takeCreated(id)
+	markStopped(id, false)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func stopContainer(c *gin.Context) {
+	name := c.Param("name")
+	timeout := -1
+	if t := c.Query("t"); t != "" {
+		var err error
+		if timeout, err = strconv.Atoi(t); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	id, status, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if status != "running" && restarting(id) {
+		// waiting to be restarted by the supervisor, so stopping is cancelling it
+		markStopped(id, true)
+		c.Status(http.StatusNoContent)
+		return
+	}
+	// paused (and restarting) containers are still to be stopped
+	if status == "created" || status == "exited" {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	wasStopped := markStopped(id, true)
+	if err := backend.StopContainer(name, timeout); err != nil {
+		markStopped(id, wasStopped)
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func updateContainer(c *gin.Context) {
+	name := c.Param("name")
+	var req struct {
+		CPUShares         int64 `json:"CpuShares"`
+		CPUPeriod         int64 `json:"CpuPeriod"`
+		CPUQuota          int64 `json:"CpuQuota"`
+		NanoCPUs          int64 `json:"NanoCpus"`
+		CpusetCpus        string
+		CpusetMems        string
+		Memory            int64
+		MemoryReservation int64
+		MemorySwap        int64
+		PidsLimit         *int64
+		BlkioWeight       uint16
+		OomKillDisable    *bool
+		RestartPolicy     struct {
+			Name              string
+			MaximumRetryCount int
+		}
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if _, _, err := containerState(name); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	opts := backend.UpdateOptions{
+		CPUShares:         req.CPUShares,
+		CPUPeriod:         req.CPUPeriod,
+		CPUQuota:          req.CPUQuota,
+		CPUs:              float64(req.NanoCPUs) / 1e9,
+		CpusetCpus:        req.CpusetCpus,
+		CpusetMems:        req.CpusetMems,
+		Memory:            req.Memory,
+		MemoryReservation: req.MemoryReservation,
+		MemorySwap:        req.MemorySwap,
+		BlkioWeight:       req.BlkioWeight,
+	}
+	if req.PidsLimit != nil {
+		// zero means unlimited, in the update request
+		opts.PidsLimit = *req.PidsLimit
+		if opts.PidsLimit == 0 {
+			opts.PidsLimit = -1
+		}
+	}
+	if policy := req.RestartPolicy.Name; policy != "" {
+		opts.Restart = policy
+		if policy == "on-failure" && req.RestartPolicy.MaximumRetryCount > 0 {
+			opts.Restart += ":" + strconv.Itoa(req.RestartPolicy.MaximumRetryCount)
+		}
+	}
+	warnings := []string{}
+	if req.OomKillDisable != nil {
+		// like docker, which only sets it on create
+		warnings = append(warnings, "OomKillDisable cannot be updated, and was ignored")
+	}
+	if err := backend.UpdateContainer(name, opts); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"Warnings": warnings})
+}
+
+func restartContainer(c *gin.Context) {
+	name := c.Param("name")
+	timeout := -1
+	if t := c.Query("t"); t != "" {
+		var err error
+		if timeout, err = strconv.Atoi(t); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	id, _, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	// the supervisor shouldn't start it too, when it stops
+	markStopped(id, true)
+	err = backend.RestartContainer(name, timeout)
+	markStopped(id, false)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func killContainer(c *gin.Context) {
+	name := c.Param("name")
+	signal := c.Query("signal")
+	id, status, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if status != "running" && status != "paused" {
+		httpError(c.Writer, fmt.Sprintf("Container %s is not running", name), http.StatusConflict)
+		return
+	}
+	// like docker, a container killed by the user is not restarted
+	killed := signal == "" || strings.TrimPrefix(strings.ToUpper(signal), "SIG") == "KILL" || signal == "9"
+	if killed {
+		markStopped(id, true)
+	}
+	if err := backend.KillContainer(name, signal); err != nil {
+		if killed {
+			markStopped(id, false)
+		}
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func pauseContainer(c *gin.Context) {
+	name := c.Param("name")
+	_, status, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if status != "running" {
+		httpError(c.Writer, fmt.Sprintf("Container %s is not running", name), http.StatusConflict)
+		return
+	}
+	if err := backend.PauseContainer(name); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func unpauseContainer(c *gin.Context) {
+	name := c.Param("name")
+	_, status, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if status != "paused" {
+		httpError(c.Writer, fmt.Sprintf("Container %s is not paused", name), http.StatusConflict)
+		return
+	}
+	if err := backend.UnpauseContainer(name); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func renameContainer(c *gin.Context) {
+	name := c.Param("name")
+	newName := c.Query("name")
+	if newName == "" {
+		httpError(c.Writer, "name is required", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := containerState(name); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if err := backend.RenameContainer(name, newName); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func removeContainer(c *gin.Context) {
+	name := c.Param("name")
+	force := c.Query("force") == "1" || c.Query("force") == "true"
+	volumes := c.Query("v") == "1" || c.Query("v") == "true"
+	container, err := backend.Container(name)
+	if err != nil {
+		err = containerError(name, err)
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	id, _ := container["Id"].(string)
+	containerName, _ := container["Name"].(string)
+	state, _ := container["State"].(map[string]interface{})
+	if status, _ := state["Status"].(string); !force && (status == "running" || status == "paused" || status == "restarting") {
+		// like docker, which also wants it stopped first
+		httpError(c.Writer, fmt.Sprintf("cannot remove container \"/%s\": container is %s: stop the container before removing or force remove",
+			strings.TrimPrefix(containerName, "/"), status), http.StatusConflict)
+		return
+	}
+	var anonymous []string
+	if volumes {
+		anonymous = anonymousVolumes(container)
+	}
+	sizes := backend.VolumeSizes(anonymous)
+	// so that it is not restarted while being removed
+	wasStopped := markStopped(id, true)
+	if err := backend.RemoveContainer(name, force, volumes); err != nil {
+		markStopped(id, wasStopped)
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	takeCreated(id)
+	if len(anonymous) > 0 {
+		// the volumes that are used by other containers are kept
+		existing, err := volumeNames()
+		removed := []string{}
+		for _, volume := range anonymous {
+			if err == nil && !slices.Contains(existing, volume) {
+				removed = append(removed, volume)
+			}
+		}
+		volumesDestroyed(removed, sizes)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// pruneContainers removes the stopped containers, like "docker container prune"
+func pruneContainers(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var deleted []string
+	if len(filters["label"]) > 0 {
+		// nerdctl prune has no filters, so remove the labeled containers that are stopped
+		containers, err := backend.Containers(true, filterArgs(filters, "label")...)
+		if err != nil {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+			return
+		}
+		deleted = []string{}
+		for id, status := range containerStatuses(containers) {
+			if getStatus(status) != "Stopped" {
+				continue
+			}
+			if err := backend.RemoveContainer(id, false, false); err == nil {
+				deleted = append(deleted, id)
+			}
+		}
+		sort.Strings(deleted)
+	} else if deleted, err = backend.PruneContainers(); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, id := range deleted {
+		takeCreated(id)
+	}
+	var cp struct {
+		ContainersDeleted []string
+		SpaceReclaimed    int64
+	}
+	cp.ContainersDeleted = deleted
+	c.JSON(http.StatusOK, cp)
+}
+
+func waitContainer(c *gin.Context) {
+	name := c.Param("name")
+	condition := c.DefaultQuery("condition", "not-running")
+	if condition != "not-running" && condition != "next-exit" && condition != "removed" {
+		httpError(c.Writer, fmt.Sprintf("invalid condition: %q", condition), http.StatusBadRequest)
+		return
+	}
+	container, err := backend.Container(name)
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return
+	}
+	state, _ := container["State"].(map[string]interface{})
+	running, _ := state["Running"].(bool)
+	// send the headers now, so that the client knows that we are waiting
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(http.StatusOK)
+	c.Writer.Flush()
+	var code int
+	if running || condition == "next-exit" {
+		// nerdctl can not wait for a container that has not been started yet
+		status, _ := state["Status"].(string)
+		for status == "created" {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-time.After(200 * time.Millisecond):
+			}
+			if _, status, err = containerState(name); err != nil {
+				status = ""
+			}
+		}
+		code, err = backend.WaitContainer(c.Request.Context(), name)
+	} else {
+		exitCode, _ := state["ExitCode"].(float64)
+		code = int(exitCode)
+	}
+	if err == nil && condition == "removed" {
+		for {
+			if _, err := backend.Container(name); err != nil {
+				break
+			}
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+	type waitError struct {
+		Message string
+	}
+	var resp struct {
+		StatusCode int
+		Error      *waitError `json:",omitempty"`
+	}
+	resp.StatusCode = code
+	if err != nil {
+		resp.Error = &waitError{Message: err.Error()}
+	}
+	_ = json.NewEncoder(c.Writer).Encode(resp)
+}
+
+// parseTop parses the ps table, where the last column (the command) can contain spaces
+func parseTop(output string) ([]string, [][]string) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	titles := strings.Fields(lines[0])
+	processes := [][]string{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > len(titles) && len(titles) > 0 {
+			n := len(titles) - 1
+			fields = append(fields[:n], strings.Join(fields[n:], " "))
+		}
+		processes = append(processes, fields)
+	}
+	return titles, processes
+}
+
+func topContainer(c *gin.Context) {
+	name := c.Param("name")
+	psArgs := c.DefaultQuery("ps_args", "-ef")
+	_, status, err := containerState(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	if status != "running" {
+		httpError(c.Writer, fmt.Sprintf("Container %s is not running", name), http.StatusConflict)
+		return
+	}
+	output, err := backend.Top(name, psArgs)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	var top struct {
+		Titles    []string
+		Processes [][]string
+	}
+	top.Titles, top.Processes = parseTop(output)
+	c.JSON(http.StatusOK, top)
+}
+
+func commitContainer(c *gin.Context) {
+	name := c.Query("container")
+	repo := c.Query("repo")
+	if repo == "" {
+		httpError(c.Writer, "nerdctl commit requires a repository name", http.StatusBadRequest)
+		return
+	}
+	ref := repo
+	if tag := c.Query("tag"); tag != "" {
+		ref += ":" + tag
+	}
+	if _, _, err := containerState(name); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	pause := c.Query("pause") != "0" && c.Query("pause") != "false"
+	opts := backend.CommitOptions{
+		Author:  c.Query("author"),
+		Message: c.Query("comment"),
+		Changes: c.QueryArray("changes"),
+		Pause:   pause,
+	}
+	id, err := backend.Commit(name, ref, opts)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.JSON(http.StatusCreated, map[string]string{"Id": id})
+}
+
+func exportContainer(c *gin.Context) {
+	name := c.Param("name")
+	if _, _, err := containerState(name); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/x-tar")
+	cw := &countWriter{w: c.Writer}
+	if err := backend.Export(name, cw); err != nil {
+		if cw.n == 0 {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+		} else {
+			logRequest(c, "export %s: %v", name, err)
+		}
+		return
+	}
+	c.Status(http.StatusOK)
+}
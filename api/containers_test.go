@@ -0,0 +1,181 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+func TestStrSlice(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want strSlice
+	}{
+		{`null`, nil},
+		{`[]`, strSlice{}},
+		{`""`, strSlice{""}},
+		{`"sh"`, strSlice{"sh"}},
+		{`["sh", "-c", "true"]`, strSlice{"sh", "-c", "true"}},
+	} {
+		var s strSlice
+		if err := json.Unmarshal([]byte(tc.json), &s); err != nil {
+			t.Errorf("%s: %v", tc.json, err)
+			continue
+		}
+		// null and empty are different: an empty entrypoint resets the one of the image
+		if !reflect.DeepEqual(s, tc.want) || (s == nil) != (tc.want == nil) {
+			t.Errorf("%s: %#v, want %#v", tc.json, s, tc.want)
+		}
+	}
+	var s strSlice
+	if err := json.Unmarshal([]byte(`1`), &s); err == nil {
+		t.Error("expected an error for a number")
+	}
+}
+
+// fakeNerdctl uses a nerdctl that runs the shell script, instead of the real one
+func fakeNerdctl(t *testing.T, body string) {
+	script := filepath.Join(t.TempDir(), "nerdctl")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved, remote := backend.Nerdctl, backend.RemoteCommand
+	backend.Nerdctl, backend.RemoteCommand = script, nil
+	t.Cleanup(func() { backend.Nerdctl, backend.RemoteCommand = saved, remote })
+}
+
+// fakeImageCmd uses a nerdctl that inspects every image, with the command
+func fakeImageCmd(t *testing.T, cmd string) {
+	fakeNerdctl(t, "echo '{\"Config\":{\"Cmd\":"+cmd+"}}'")
+}
+
+func TestContainerCommand(t *testing.T) {
+	fakeImageCmd(t, `["/bin/sh"]`)
+	for _, tc := range []struct {
+		name       string
+		json       string
+		entrypoint []string
+		cmd        []string
+	}{
+		{"defaults", `{"Image":"alpine"}`, nil, nil},
+		{"null", `{"Image":"alpine","Entrypoint":null,"Cmd":null}`, nil, nil},
+		{"cmd", `{"Image":"alpine","Cmd":["echo","hi"]}`, nil, []string{"echo", "hi"}},
+		{"cmd string", `{"Image":"alpine","Cmd":"date"}`, nil, []string{"date"}},
+		{"entrypoint", `{"Image":"alpine","Entrypoint":["/init","-v"],"Cmd":["run"]}`, []string{"/init", "-v"}, []string{"run"}},
+		{"reset entrypoint", `{"Image":"alpine","Entrypoint":[],"Cmd":["ls"]}`, []string{}, []string{"ls"}},
+		// like docker, the command of the image is kept when only the entrypoint is reset
+		{"reset entrypoint only", `{"Image":"alpine","Entrypoint":[]}`, []string{}, []string{"/bin/sh"}},
+		{"reset entrypoint and cmd", `{"Image":"alpine","Entrypoint":[],"Cmd":[]}`, []string{}, []string{"/bin/sh"}},
+	} {
+		var config containerCreateConfig
+		if err := json.Unmarshal([]byte(tc.json), &config); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		opts := containerOptions("c", config)
+		if !reflect.DeepEqual(opts.Entrypoint, tc.entrypoint) || (opts.Entrypoint == nil) != (tc.entrypoint == nil) {
+			t.Errorf("%s: entrypoint %#v, want %#v", tc.name, opts.Entrypoint, tc.entrypoint)
+		}
+		if cmd := containerCommand(config); !reflect.DeepEqual(cmd, tc.cmd) && !(len(cmd) == 0 && len(tc.cmd) == 0) {
+			t.Errorf("%s: cmd %#v, want %#v", tc.name, cmd, tc.cmd)
+		}
+	}
+}
+
+func TestContainerEnv(t *testing.T) {
+	for _, tc := range []struct {
+		env  []string
+		want []string
+	}{
+		{nil, []string{}},
+		{[]string{"A=1", "B=two words"}, []string{"A=1", "B=two words"}},
+		// without a value, nerdctl would take it from the environment of nerdctld
+		{[]string{"HOME", "A=1"}, []string{"A=1"}},
+		{[]string{"EMPTY=", "URL=a=b"}, []string{"EMPTY=", "URL=a=b"}},
+	} {
+		if got := containerEnv(tc.env); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: %q, want %q", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestContainerOptionsConfig(t *testing.T) {
+	five, zero := 5, 0
+	for _, tc := range []struct {
+		json        string
+		workingDir  string
+		stopSignal  string
+		stopTimeout *int
+	}{
+		{`{}`, "", "", nil},
+		{`{"WorkingDir":"/app"}`, "/app", "", nil},
+		{`{"StopSignal":"SIGINT","StopTimeout":5}`, "", "SIGINT", &five},
+		// zero is a timeout too, unlike null
+		{`{"StopTimeout":0}`, "", "", &zero},
+		{`{"StopTimeout":null}`, "", "", nil},
+	} {
+		var config containerCreateConfig
+		if err := json.Unmarshal([]byte(tc.json), &config); err != nil {
+			t.Fatalf("%s: %v", tc.json, err)
+		}
+		opts := containerOptions("c", config)
+		if opts.WorkingDir != tc.workingDir || opts.StopSignal != tc.stopSignal {
+			t.Errorf("%s: WorkingDir %q, StopSignal %q", tc.json, opts.WorkingDir, opts.StopSignal)
+		}
+		if !reflect.DeepEqual(opts.StopTimeout, tc.stopTimeout) {
+			t.Errorf("%s: StopTimeout %v, want %v", tc.json, opts.StopTimeout, tc.stopTimeout)
+		}
+	}
+}
+
+func TestContainerNotFound(t *testing.T) {
+	r := gin.New()
+	r.DELETE("/containers/:name", removeContainer)
+	r.POST("/containers/:name/rename", renameContainer)
+	r.POST("/containers/:name/start", startContainer)
+	for _, tc := range []struct {
+		stderr string
+		code   int
+	}{
+		{"no such container: web", http.StatusNotFound},
+		// the other errors of nerdctl are not about the container
+		{"failed to dial /run/containerd/containerd.sock: connection refused", http.StatusInternalServerError},
+		{"", http.StatusInternalServerError},
+	} {
+		fakeNerdctl(t, "echo '"+tc.stderr+"' >&2; exit 1")
+		for _, req := range []*http.Request{
+			httptest.NewRequest("DELETE", "/containers/web", nil),
+			httptest.NewRequest("POST", "/containers/web/rename?name=db", nil),
+			httptest.NewRequest("POST", "/containers/web/start", nil),
+		} {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tc.code {
+				t.Errorf("%s %s with %q: status %d, want %d", req.Method, req.URL.Path, tc.stderr, w.Code, tc.code)
+			}
+		}
+	}
+}
@@ -0,0 +1,462 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+// Actor is the object of an event, with some extra attributes
+type Actor struct {
+	ID         string
+	Attributes map[string]string
+}
+
+// Event is a docker event, translated from a containerd event
+type Event struct {
+	Status   string `json:"status,omitempty"`
+	ID       string `json:"id,omitempty"`
+	From     string `json:"from,omitempty"`
+	Type     string
+	Action   string
+	Actor    Actor
+	Scope    string `json:"scope"`
+	Time     int64  `json:"time"`
+	TimeNano int64  `json:"timeNano"`
+}
+
+// containerd topics, and the docker type and action they translate to
+var eventTopics = map[string][2]string{
+	"/containers/create":  {"container", "create"},
+	"/containers/update":  {"container", "update"},
+	"/containers/delete":  {"container", "destroy"},
+	"/tasks/start":        {"container", "start"},
+	"/tasks/exit":         {"container", "die"},
+	"/tasks/paused":       {"container", "pause"},
+	"/tasks/resumed":      {"container", "unpause"},
+	"/tasks/oom":          {"container", "oom"},
+	"/tasks/exec-added":   {"container", "exec_create"},
+	"/tasks/exec-started": {"container", "exec_start"},
+	"/images/create":      {"image", "pull"},
+	"/images/update":      {"image", "tag"},
+	"/images/delete":      {"image", "delete"},
+}
+
+// eventAttributes remembers container attributes, for when the container is gone
+var eventAttributes = struct {
+	sync.Mutex
+	m map[string]map[string]string
+}{m: map[string]map[string]string{}}
+
+func containerAttributes(id string, action string) map[string]string {
+	eventAttributes.Lock()
+	attrs, ok := eventAttributes.m[id]
+	eventAttributes.Unlock()
+	if !ok || action == "create" || action == "update" {
+		attrs = map[string]string{}
+		if container, err := backend.Container(id); err == nil {
+			if name, ok := container["Name"].(string); ok {
+				attrs["name"] = strings.TrimPrefix(name, "/")
+			}
+			if image, ok := container["Image"].(string); ok {
+				attrs["image"] = image
+			}
+			if config, ok := container["Config"].(map[string]interface{}); ok {
+				for k, v := range stringMap(config["Labels"]) {
+					attrs[k] = v
+				}
+			}
+		}
+		eventAttributes.Lock()
+		eventAttributes.m[id] = attrs
+		eventAttributes.Unlock()
+	}
+	result := map[string]string{}
+	for k, v := range attrs {
+		result[k] = v
+	}
+	if action == "destroy" {
+		eventAttributes.Lock()
+		delete(eventAttributes.m, id)
+		eventAttributes.Unlock()
+	}
+	return result
+}
+
+// translateEvent translates the containerd event, returning nil if it has no docker equivalent
+func translateEvent(raw map[string]interface{}) *Event {
+	topic, _ := raw["Topic"].(string)
+	ta, ok := eventTopics[topic]
+	if !ok {
+		return nil
+	}
+	// the event payload is sometimes a string, and sometimes an object
+	payload := map[string]interface{}{}
+	switch e := raw["Event"].(type) {
+	case string:
+		_ = json.Unmarshal([]byte(e), &payload)
+	case map[string]interface{}:
+		payload = e
+	}
+	ev := &Event{Type: ta[0], Action: ta[1], Scope: "local"}
+	t := time.Now()
+	if ts, ok := raw["Timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			t = parsed
+		}
+	}
+	ev.Time = t.Unix()
+	ev.TimeNano = t.UnixNano()
+	switch ev.Type {
+	case "container":
+		id, _ := payload["container_id"].(string)
+		if id == "" {
+			id, _ = payload["id"].(string)
+		}
+		if id == "" {
+			return nil
+		}
+		execID := ""
+		if ev.Action == "die" {
+			// exit of an exec process, rather than the container
+			if execID, _ = payload["id"].(string); execID != "" && execID != id {
+				ev.Action = "exec_die"
+			}
+		}
+		ev.Actor = Actor{ID: id, Attributes: containerAttributes(id, ev.Action)}
+		if ev.Action == "die" || ev.Action == "exec_die" {
+			code := 0
+			if status, ok := payload["exit_status"].(float64); ok {
+				code = int(status)
+			}
+			ev.Actor.Attributes["exitCode"] = strconv.Itoa(code)
+		}
+		if ev.Action == "exec_die" {
+			ev.Actor.Attributes["execID"] = execID
+		}
+		ev.From = ev.Actor.Attributes["image"]
+	case "image":
+		name, _ := payload["name"].(string)
+		if name == "" {
+			return nil
+		}
+		ev.Actor = Actor{ID: name, Attributes: map[string]string{"name": name}}
+	}
+	ev.Status = ev.Action
+	ev.ID = ev.Actor.ID
+	return ev
+}
+
+// eventHub runs one "nerdctl events", and sends the events to all subscribers
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan *Event]struct{}
+	cancel context.CancelFunc
+	// changes counts the events, and the starts and stops of watching them
+	changes uint64
+	// healthErrors are the errors of polling the health
+	healthErrors loopError
+	// recent are the last events, for the clients asking for the events since a time
+	recent []*Event
+	// updates are the times of the last events of the containers, by short ID
+	updates map[string]int64
+	// started is when the events started being watched
+	started int64
+}
+
+// maxRecentEvents is how many of the events are kept, like the log of dockerd
+const maxRecentEvents = 256
+
+var events = &eventHub{subs: map[chan *Event]struct{}{}, updates: map[string]int64{}}
+
+var eventsSaver = newStateSaver("events", func() interface{} {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	return events.recent
+})
+
+// loadEvents restores the recent events, from before the restart
+func loadEvents() error {
+	var recent []*Event
+	if err := loadState("events", &recent); err != nil {
+		return err
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.recent = append(recent, events.recent...)
+	return nil
+}
+
+func (h *eventHub) subscribe() chan *Event {
+	ch, _ := h.subscribeSince(time.Time{})
+	return ch
+}
+
+// subscribeSince also returns the recent events since the time (if not zero),
+// without any events in between getting lost or sent twice
+func (h *eventHub) subscribeSince(since time.Time) (chan *Event, []*Event) {
+	ch := make(chan *Event, 64)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var recent []*Event
+	if !since.IsZero() {
+		for _, ev := range h.recent {
+			if ev.TimeNano >= since.UnixNano() {
+				recent = append(recent, ev)
+			}
+		}
+	}
+	h.subs[ch] = struct{}{}
+	if h.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h.cancel = cancel
+		h.changes++
+		h.started = time.Now().UnixNano()
+		go h.watch(ctx)
+		go h.watchHealth(ctx)
+	}
+	return ch, recent
+}
+
+func (h *eventHub) unsubscribe(ch chan *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+	if len(h.subs) == 0 && h.cancel != nil {
+		h.cancel()
+		h.cancel = nil
+		h.changes++
+	}
+}
+
+// generation returns the count of changes, and whether the events are being watched
+// (otherwise there is no telling if anything has changed)
+func (h *eventHub) generation() (uint64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.changes, h.cancel != nil
+}
+
+// inspectRefresh is how long (in seconds) a container inspect is cached, when there
+// are no events to tell when it changes
+const inspectRefresh = 2
+
+// updated returns a string that changes whenever the container does, for the inspect cache:
+// the time of its last event, while the events are watched, and otherwise a short time bucket
+func (h *eventHub) updated(id string) string {
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cancel != nil {
+		return fmt.Sprintf("%d@%d", h.updates[id], h.started)
+	}
+	return fmt.Sprintf("%d@%d", h.updates[id], time.Now().Unix()/inspectRefresh)
+}
+
+// publish sends the event to all subscribers, dropping it for slow ones
+func (h *eventHub) publish(ev *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.changes++
+	if ev.Type == "container" && len(ev.Actor.ID) >= 12 {
+		if ev.Action == "destroy" {
+			delete(h.updates, ev.Actor.ID[:12])
+		} else {
+			h.updates[ev.Actor.ID[:12]] = ev.TimeNano
+		}
+	}
+	h.recent = append(h.recent, ev)
+	if len(h.recent) > maxRecentEvents {
+		h.recent = h.recent[len(h.recent)-maxRecentEvents:]
+	}
+	eventsSaver.changed()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("events: dropped %s %s for slow subscriber", ev.Type, ev.Action)
+		}
+	}
+}
+
+func (h *eventHub) watch(ctx context.Context) {
+	for {
+		err := backend.Events(ctx, func(raw map[string]interface{}) {
+			if ev := translateEvent(raw); ev != nil {
+				h.publish(ev)
+			}
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+			// nerdctl events exited, so restart it
+			log.Printf("events: %v", err)
+		}
+	}
+}
+
+// watchHealth polls the health of the running containers, since there are no containerd
+// events for it, and publishes the changes as "health_status: healthy" (or unhealthy)
+func (h *eventHub) watchHealth(ctx context.Context) {
+	last := map[string]string{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthRefresh * time.Second):
+		}
+		var updated map[string]string
+		var inspects map[string]map[string]interface{}
+		containers, err := backend.Containers(false)
+		if err == nil {
+			updated = containerUpdates(containers)
+			inspects, err = backend.InspectContainers(updated)
+		}
+		h.healthErrors.log("events: health", err)
+		if err != nil {
+			continue
+		}
+		refreshHealth(updated, inspects)
+		current := map[string]string{}
+		for _, inspect := range inspects {
+			id, _ := inspect["Id"].(string)
+			status := healthStatus(inspect)
+			if id == "" || status == "none" {
+				continue
+			}
+			current[id] = status
+			if previous, seen := last[id]; !seen || previous == status || status == "starting" {
+				continue
+			}
+			h.publishHealth(id, status)
+		}
+		last = current
+	}
+}
+
+// publishHealth publishes the change of health status, like "health_status: healthy"
+func (h *eventHub) publishHealth(id string, status string) {
+	t := time.Now()
+	ev := &Event{Type: "container", Action: "health_status: " + status, Scope: "local", Time: t.Unix(), TimeNano: t.UnixNano()}
+	ev.Actor = Actor{ID: id, Attributes: containerAttributes(id, "health_status")}
+	ev.From = ev.Actor.Attributes["image"]
+	ev.Status = ev.Action
+	ev.ID = id
+	h.publish(ev)
+}
+
+// matchEvent checks the event against the filters (type, event, container, image, label)
+func matchEvent(filters map[string][]string, ev *Event) bool {
+	match := func(key string, values ...string) bool {
+		if len(filters[key]) == 0 {
+			return true
+		}
+		for _, f := range filters[key] {
+			for _, v := range values {
+				if v != "" && (f == v || (len(f) >= 12 && strings.HasPrefix(v, f))) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	// "health_status" matches "health_status: healthy", and so on
+	action, _, _ := strings.Cut(ev.Action, ":")
+	if !match("type", ev.Type) || !match("event", ev.Action, action) {
+		return false
+	}
+	if len(filters["container"]) > 0 && (ev.Type != "container" || !match("container", ev.Actor.ID, ev.Actor.Attributes["name"])) {
+		return false
+	}
+	if len(filters["image"]) > 0 && !match("image", ev.From, ev.Actor.Attributes["name"]) {
+		return false
+	}
+	return matchLabels(filters, ev.Actor.Attributes)
+}
+
+// parseEventTime parses the since/until parameters, as unix timestamps (with fraction)
+func parseEventTime(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(f*1e9)), true
+}
+
+func getEvents(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, hasUntil := parseEventTime(c.Query("until"))
+	since, _ := parseEventTime(c.Query("since"))
+	ch, recent := events.subscribeSince(since)
+	defer events.unsubscribe(ch)
+	sw := stream.NewWriter(c.Writer)
+	// send the headers now, so that the client knows that we are listening
+	c.Writer.WriteHeader(http.StatusOK)
+	sw.Flush()
+	for _, ev := range recent {
+		if hasUntil && ev.TimeNano > until.UnixNano() {
+			break
+		}
+		if !matchEvent(filters, ev) {
+			continue
+		}
+		if err := sw.WriteJSON(ev); err != nil {
+			return
+		}
+	}
+	var timeout <-chan time.Time
+	if hasUntil {
+		timeout = time.After(time.Until(until))
+	}
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timeout:
+			return
+		case ev := <-ch:
+			if !matchEvent(filters, ev) {
+				continue
+			}
+			if err := sw.WriteJSON(ev); err != nil {
+				return
+			}
+		}
+	}
+}
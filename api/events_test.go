@@ -0,0 +1,127 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContainerUpdates(t *testing.T) {
+	// without watching the events, the keys change every few seconds
+	if time.Now().Unix()%inspectRefresh == inspectRefresh-1 {
+		time.Sleep(time.Second)
+	}
+	containers := []map[string]interface{}{
+		{"ID": "0123456789ab", "Status": "Up 2 seconds"},
+		{"ID": "ba9876543210", "Status": "Exited (0) 3 seconds ago"},
+	}
+	before := containerUpdates(containers)
+
+	// the status text of ps changes, without the container changing
+	containers[0]["Status"] = "Up 3 seconds"
+	if after := containerUpdates(containers); after["0123456789ab"] != before["0123456789ab"] {
+		t.Errorf("status text changed the key: %q != %q", after["0123456789ab"], before["0123456789ab"])
+	}
+
+	// an event changes the key of the container, but not of the others
+	events.publish(&Event{Type: "container", Action: "rename", Actor: Actor{ID: "0123456789abcdef"}, TimeNano: time.Now().UnixNano()})
+	after := containerUpdates(containers)
+	if after["0123456789ab"] == before["0123456789ab"] {
+		t.Error("event did not change the key")
+	}
+	if after["ba9876543210"] != before["ba9876543210"] {
+		t.Error("event changed the key of another container")
+	}
+
+	// and so does the state
+	containers[0]["Status"] = "Paused"
+	if paused := containerUpdates(containers); paused["0123456789ab"] == after["0123456789ab"] {
+		t.Error("state did not change the key")
+	}
+}
+
+func TestHealthStatus(t *testing.T) {
+	for _, tc := range []struct {
+		inspect map[string]interface{}
+		want    string
+	}{
+		{nil, "none"},
+		{map[string]interface{}{"State": map[string]interface{}{"Status": "running"}}, "none"},
+		{map[string]interface{}{"State": map[string]interface{}{"Health": map[string]interface{}{"Status": "starting"}}}, "starting"},
+		{map[string]interface{}{"State": map[string]interface{}{"Health": map[string]interface{}{"Status": "healthy"}}}, "healthy"},
+		{map[string]interface{}{"State": map[string]interface{}{"Health": map[string]interface{}{"Status": "unhealthy"}}}, "unhealthy"},
+	} {
+		status := healthStatus(tc.inspect)
+		if status != tc.want {
+			t.Errorf("%v: %q, want %q", tc.inspect, status, tc.want)
+		}
+		// the health filter of ps, and "none" matches the containers without a healthcheck
+		for _, filter := range []string{"none", "starting", "healthy", "unhealthy"} {
+			if matchValue([]string{filter}, status) != (filter == tc.want) {
+				t.Errorf("%v: health=%s matched %q", tc.inspect, filter, status)
+			}
+		}
+	}
+}
+
+func TestPublishHealth(t *testing.T) {
+	const id = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	// remembered attributes, so that there is no inspect
+	eventAttributes.Lock()
+	eventAttributes.m[id] = map[string]string{"name": "web", "image": "nginx:alpine", "app": "demo"}
+	eventAttributes.Unlock()
+	defer func() {
+		eventAttributes.Lock()
+		delete(eventAttributes.m, id)
+		eventAttributes.Unlock()
+	}()
+
+	h := &eventHub{subs: map[chan *Event]struct{}{}, updates: map[string]int64{}}
+	ch := make(chan *Event, 1)
+	h.subs[ch] = struct{}{}
+	h.publishHealth(id, "unhealthy")
+	ev := <-ch
+	if ev.Type != "container" || ev.Action != "health_status: unhealthy" || ev.Status != ev.Action {
+		t.Errorf("event %s %q (status %q)", ev.Type, ev.Action, ev.Status)
+	}
+	if ev.ID != id || ev.Actor.ID != id || ev.From != "nginx:alpine" || ev.Actor.Attributes["name"] != "web" {
+		t.Errorf("actor %q %v, from %q", ev.Actor.ID, ev.Actor.Attributes, ev.From)
+	}
+	if h.updates[id[:12]] != ev.TimeNano {
+		t.Error("event did not update the container")
+	}
+
+	for _, tc := range []struct {
+		filters map[string][]string
+		want    bool
+	}{
+		{map[string][]string{"event": {"health_status"}}, true},
+		{map[string][]string{"event": {"health_status: unhealthy"}}, true},
+		{map[string][]string{"event": {"health_status: healthy"}}, false},
+		{map[string][]string{"event": {"die"}}, false},
+		{map[string][]string{"type": {"container"}, "container": {"web"}}, true},
+		{map[string][]string{"container": {id[:12]}}, true},
+		{map[string][]string{"image": {"nginx:alpine"}}, true},
+		{map[string][]string{"label": {"app=demo"}}, true},
+		{map[string][]string{"label": {"app=other"}}, false},
+	} {
+		if matchEvent(tc.filters, ev) != tc.want {
+			t.Errorf("%v: matched %v", tc.filters, !tc.want)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+// execConfig is the request body of exec create
+type execConfig struct {
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	DetachKeys   string
+	Tty          bool
+	Cmd          []string
+	User         string
+	WorkingDir   string
+	Env          []string
+	Privileged   bool
+}
+
+// execInstance is an exec, that has been created (and maybe started)
+type execInstance struct {
+	mu          sync.Mutex
+	ID          string
+	ContainerID string
+	Config      execConfig
+	Running     bool
+	ExitCode    *int
+	Pid         int
+	pty         *os.File
+}
+
+var execs = struct {
+	sync.Mutex
+	m map[string]*execInstance
+}{m: map[string]*execInstance{}}
+
+func randomID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func getExec(id string) *execInstance {
+	execs.Lock()
+	defer execs.Unlock()
+	return execs.m[id]
+}
+
+func createExec(c *gin.Context) {
+	name := c.Param("name")
+	var config execConfig
+	if !bindJSON(c, &config) {
+		return
+	}
+	if len(config.Cmd) == 0 {
+		httpError(c.Writer, "No exec command specified", http.StatusBadRequest)
+		return
+	}
+	if _, err := parseDetachKeys(config.DetachKeys); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	container, err := backend.Container(name)
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return
+	}
+	id, _ := container["Id"].(string)
+	if state, ok := container["State"].(map[string]interface{}); ok {
+		if running, _ := state["Running"].(bool); !running {
+			httpError(c.Writer, fmt.Sprintf("Container %s is not running", name), http.StatusConflict)
+			return
+		}
+	}
+	e := &execInstance{ID: randomID(), ContainerID: id, Config: config}
+	execs.Lock()
+	execs.m[e.ID] = e
+	execs.Unlock()
+	c.JSON(http.StatusCreated, map[string]string{"Id": e.ID})
+}
+
+func startExec(c *gin.Context) {
+	e := getExec(c.Param("id"))
+	if e == nil {
+		httpError(c.Writer, fmt.Sprintf("No such exec instance: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	var req struct {
+		Detach bool
+		Tty    bool
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if !e.start() {
+		httpError(c.Writer, fmt.Sprintf("Exec %s has already been started", e.ID), http.StatusConflict)
+		return
+	}
+
+	opts := e.options(req.Tty)
+	if req.Detach {
+		opts.Interactive = false
+		opts.Tty = false
+		opts.Detach = true
+		cmd := backend.Exec(e.ContainerID, e.Config.Cmd, opts)
+		err := cmd.Run()
+		e.finish(cmd, err)
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
+		return
+	}
+
+	conn, rw, err := stream.Hijack(c.Writer, c.Request)
+	if err != nil {
+		e.finish(nil, err)
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	cmd := backend.Exec(e.ContainerID, e.Config.Cmd, opts)
+	if opts.Tty {
+		pty, err := backend.StartTty(cmd)
+		if err != nil {
+			e.finish(nil, err)
+			fmt.Fprintf(conn, "%s\r\n", err)
+			return
+		}
+		e.mu.Lock()
+		e.pty = pty
+		e.Pid = cmd.Process.Pid
+		e.mu.Unlock()
+		var detached atomic.Bool
+		if opts.Interactive {
+			keys, _ := parseDetachKeys(e.Config.DetachKeys)
+			go func() {
+				_, err := io.Copy(pty, &detachReader{r: rw, keys: keys})
+				if err == errDetached {
+					// leave the process running, without the client
+					detached.Store(true)
+					conn.Close()
+				}
+			}()
+		}
+		// the pty returns an error (EIO) when the process has exited
+		_, _ = io.Copy(conn, pty)
+		if detached.Load() {
+			_, _ = io.Copy(io.Discard, pty)
+		}
+		err = cmd.Wait()
+		pty.Close()
+		e.finish(cmd, err)
+		return
+	}
+	stdout, stderr := stream.NewStdWriters(conn)
+	if e.Config.AttachStdout {
+		cmd.Stdout = stdout
+	}
+	if e.Config.AttachStderr {
+		cmd.Stderr = stderr
+	}
+	var stdin io.WriteCloser
+	if opts.Interactive {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			e.finish(nil, err)
+			return
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		e.finish(nil, err)
+		fmt.Fprintf(stderr, "%s\n", err)
+		return
+	}
+	e.mu.Lock()
+	e.Pid = cmd.Process.Pid
+	e.mu.Unlock()
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(stdin, rw)
+			stdin.Close()
+		}()
+	}
+	err = cmd.Wait()
+	e.finish(cmd, err)
+	stream.CloseWrite(conn)
+}
+
+// start marks the exec as running, unless it has been started already
+func (e *execInstance) start() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.Running || e.ExitCode != nil {
+		return false
+	}
+	e.Running = true
+	return true
+}
+
+// options returns the options of the exec command, from the config (and the tty of start)
+func (e *execInstance) options(tty bool) backend.ExecOptions {
+	return backend.ExecOptions{Interactive: e.Config.AttachStdin, Tty: e.Config.Tty || tty,
+		User: e.Config.User, WorkingDir: e.Config.WorkingDir, Env: e.Config.Env, Privileged: e.Config.Privileged}
+}
+
+// the default detach keys of docker, ctrl-p ctrl-q
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+var errDetached = errors.New("detached")
+
+// parseDetachKeys returns the bytes of the detach keys, like "ctrl-p,ctrl-q" (the default)
+func parseDetachKeys(s string) ([]byte, error) {
+	if s == "" {
+		s = defaultDetachKeys
+	}
+	var keys []byte
+	for _, key := range strings.Split(s, ",") {
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case len(key) == 6 && strings.HasPrefix(key, "ctrl-"):
+			switch k := key[5]; {
+			case k >= 'a' && k <= 'z':
+				keys = append(keys, k-'a'+1)
+			case k == '@':
+				keys = append(keys, 0)
+			case k >= '[' && k <= '_':
+				// ctrl-[ is escape, up to ctrl-_
+				keys = append(keys, k-'['+27)
+			default:
+				return nil, fmt.Errorf("Invalid detach keys (%s) provided", s)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid detach keys (%s) provided", s)
+		}
+	}
+	return keys, nil
+}
+
+// detachReader reads the input, until the detach keys have been read
+type detachReader struct {
+	r       io.Reader
+	keys    []byte
+	matched int
+	pending []byte
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+	buf := make([]byte, len(p))
+	n, err := d.r.Read(buf)
+	out := p[:0]
+	for _, b := range buf[:n] {
+		if b == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				return len(out), errDetached
+			}
+			continue
+		}
+		// not the detach keys after all, so pass on the ones that matched
+		out = append(out, d.keys[:d.matched]...)
+		d.matched = 0
+		if b == d.keys[0] {
+			d.matched = 1
+			continue
+		}
+		out = append(out, b)
+	}
+	if len(out) > len(p) {
+		d.pending = append(d.pending, out[len(p):]...)
+		out = out[:len(p)]
+	}
+	return copy(p, out), err
+}
+
+// finish records the exit code, after the exec command has completed
+func (e *execInstance) finish(cmd *exec.Cmd, err error) {
+	code := 0
+	if cmd != nil && cmd.ProcessState != nil {
+		code = cmd.ProcessState.ExitCode()
+	} else if err != nil {
+		code = 126
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Running = false
+	e.ExitCode = &code
+	e.pty = nil
+}
+
+func inspectExec(c *gin.Context) {
+	e := getExec(c.Param("id"))
+	if e == nil {
+		httpError(c.Writer, fmt.Sprintf("No such exec instance: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	type processConfig struct {
+		Tty        bool     `json:"tty"`
+		Entrypoint string   `json:"entrypoint"`
+		Arguments  []string `json:"arguments"`
+		Privileged bool     `json:"privileged"`
+		User       string   `json:"user,omitempty"`
+	}
+	var inspect struct {
+		ID            string
+		Running       bool
+		ExitCode      *int
+		ProcessConfig processConfig
+		OpenStdin     bool
+		OpenStderr    bool
+		OpenStdout    bool
+		CanRemove     bool
+		ContainerID   string
+		DetachKeys    string
+		Pid           int
+	}
+	inspect.ID = e.ID
+	inspect.Running = e.Running
+	inspect.ExitCode = e.ExitCode
+	inspect.ProcessConfig = processConfig{Tty: e.Config.Tty, Entrypoint: e.Config.Cmd[0], Arguments: e.Config.Cmd[1:],
+		Privileged: e.Config.Privileged, User: e.Config.User}
+	inspect.OpenStdin = e.Config.AttachStdin
+	inspect.OpenStdout = e.Config.AttachStdout
+	inspect.OpenStderr = e.Config.AttachStderr
+	inspect.ContainerID = e.ContainerID
+	inspect.DetachKeys = e.Config.DetachKeys
+	inspect.Pid = e.Pid
+	c.JSON(http.StatusOK, inspect)
+}
+
+func resizeExec(c *gin.Context) {
+	e := getExec(c.Param("id"))
+	if e == nil {
+		httpError(c.Writer, fmt.Sprintf("No such exec instance: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	h, err := strconv.ParseUint(c.Query("h"), 10, 16)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w, err := strconv.ParseUint(c.Query("w"), 10, 16)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.mu.Lock()
+	pty := e.pty
+	e.mu.Unlock()
+	if pty == nil {
+		httpError(c.Writer, "exec is not running with a tty", http.StatusConflict)
+		return
+	}
+	if err := backend.ResizePty(pty, uint16(h), uint16(w)); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
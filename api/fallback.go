@@ -0,0 +1,222 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+// fallbackWarning is shown in info, while the reduced API is served with ctr
+const fallbackWarning = "nerdctl is not available, so only a reduced read-only API is served (with ctr)"
+
+// fallbackHandlers are the endpoints that are served with ctr, when there is no nerdctl
+var fallbackHandlers = map[string]gin.HandlerFunc{
+	"GET /:ver/version":               fallbackVersion,
+	"GET /:ver/info":                  fallbackInfo,
+	"GET /:ver/images/json":           fallbackImages,
+	"GET /:ver/containers/json":       fallbackContainers,
+	"GET /:ver/containers/:name/logs": fallbackLogs,
+}
+
+// fallback serves the request with ctr instead, returning false if it can't
+func fallback(c *gin.Context) bool {
+	handler, ok := fallbackHandlers[c.Request.Method+" "+c.FullPath()]
+	if !ok || !backend.Fallback() {
+		return false
+	}
+	handler(c)
+	return true
+}
+
+func fallbackVersion(c *gin.Context) {
+	version, details := backend.CtrVersion()
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"Platform":      nerdctlPlatform(),
+		"Components":    []backend.ComponentVersion{{Name: "containerd", Version: version, Details: details}},
+		"Version":       version,
+		"ApiVersion":    CurrentAPIVersion,
+		"MinAPIVersion": MinimumAPIVersion,
+		"GitCommit":     details["GitCommit"],
+		"GoVersion":     runtime.Version(),
+		"Os":            runtime.GOOS,
+		"Arch":          runtime.GOARCH,
+	})
+}
+
+func fallbackInfo(c *gin.Context) {
+	version, _ := backend.CtrVersion()
+	containers, _ := backend.CtrContainers()
+	images, _ := backend.CtrImages()
+	counts := map[string]int{}
+	for _, container := range containers {
+		counts[container["Status"].(string)]++
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"Containers":        len(containers),
+		"ContainersRunning": counts["RUNNING"],
+		"ContainersPaused":  counts["PAUSED"],
+		"ContainersStopped": len(containers) - counts["RUNNING"] - counts["PAUSED"],
+		"Images":            len(images),
+		"ServerVersion":     version,
+		"OSType":            "linux",
+		"Warnings":          []string{fallbackWarning},
+	})
+}
+
+func fallbackImages(c *gin.Context) {
+	images, err := backend.CtrImages()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	imgs := []map[string]interface{}{}
+	for _, image := range images {
+		ref := image["Ref"].(string)
+		tags, digests := []string{}, []string{}
+		if strings.Contains(ref, "@") {
+			digests = append(digests, ref)
+		} else {
+			tags = append(tags, ref)
+		}
+		imgs = append(imgs, map[string]interface{}{
+			"Id":          image["Digest"],
+			"ParentId":    "",
+			"RepoTags":    tags,
+			"RepoDigests": digests,
+			"Size":        image["Size"],
+			"Labels":      map[string]string{},
+		})
+	}
+	c.JSON(http.StatusOK, imgs)
+}
+
+// ctrState returns the docker state of the task status, like "RUNNING"
+func ctrState(status string) string {
+	switch status {
+	case "STOPPED":
+		return "exited"
+	case "":
+		return "created"
+	}
+	return strings.ToLower(status)
+}
+
+// ctrName returns the name of the container, from the nerdctl label
+func ctrName(container map[string]interface{}) string {
+	labels := stringMap(container["Labels"])
+	if name := labels["nerdctl/name"]; name != "" {
+		return name
+	}
+	id, _ := container["ID"].(string)
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+func fallbackContainers(c *gin.Context) {
+	containers, err := backend.CtrContainers()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	all := c.Query("all") == "1" || c.Query("all") == "true"
+	ctrs := []map[string]interface{}{}
+	for _, container := range containers {
+		state := ctrState(container["Status"].(string))
+		if !all && state != "running" {
+			continue
+		}
+		createdAt, _ := container["CreatedAt"].(string)
+		created, _ := time.Parse(time.RFC3339Nano, createdAt)
+		ctrs = append(ctrs, map[string]interface{}{
+			"Id":      container["ID"],
+			"Names":   []string{"/" + ctrName(container)},
+			"Image":   container["Image"],
+			"ImageID": "",
+			"Command": "",
+			"Created": created.Unix(),
+			"State":   state,
+			"Status":  titleCase(state),
+			"Labels":  stringMap(container["Labels"]),
+			"Ports":   []interface{}{},
+			"Mounts":  []interface{}{},
+		})
+	}
+	c.JSON(http.StatusOK, ctrs)
+}
+
+// titleCase returns the state with an upper case first letter, like "Running"
+func titleCase(state string) string {
+	if state == "" {
+		return state
+	}
+	return strings.ToUpper(state[:1]) + state[1:]
+}
+
+func fallbackLogs(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	containers, err := backend.CtrContainers()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var container map[string]interface{}
+	for _, ctr := range containers {
+		if id, _ := ctr["ID"].(string); id == name || ctrName(ctr) == name || (len(name) >= 4 && strings.HasPrefix(id, name)) {
+			container = ctr
+			break
+		}
+	}
+	if container == nil {
+		httpError(c.Writer, fmt.Sprintf("No such container: %s", name), http.StatusNotFound)
+		return
+	}
+	isTrue := func(key string) bool {
+		v := c.Query(key)
+		return v == "1" || v == "true"
+	}
+	var stdout, stderr *stream.StdWriter
+	if tty, _ := container["Tty"].(bool); tty {
+		c.Writer.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		stdout, stderr = stream.NewRawWriters(c.Writer)
+	} else {
+		c.Writer.Header().Set("Content-Type", "application/vnd.docker.multiplexed-stream")
+		stdout, stderr = stream.NewStdWriters(c.Writer)
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+	var wout, werr io.Writer = io.Discard, io.Discard
+	if isTrue("stdout") {
+		wout = stdout
+	}
+	if isTrue("stderr") {
+		werr = stderr
+	}
+	opts := backend.LogsOptions{Timestamps: isTrue("timestamps"), Tail: c.Query("tail")}
+	if err := backend.CtrLogs(c.Request.Context(), container, wout, werr, opts); err != nil {
+		logRequest(c, "logs %s: %v", name, err)
+	}
+}
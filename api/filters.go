@@ -0,0 +1,103 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// parseFilters parses the filters parameter, in either the current format
+// {"label":{"a=b":true}} or the older (before 1.23) format {"label":["a=b"]}
+func parseFilters(param string) (map[string][]string, error) {
+	filters := map[string][]string{}
+	if param == "" {
+		return filters, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(param), &raw); err != nil {
+		return nil, err
+	}
+	for key, value := range raw {
+		var set map[string]bool
+		if err := json.Unmarshal(value, &set); err == nil {
+			for v, ok := range set {
+				if ok {
+					filters[key] = append(filters[key], v)
+				}
+			}
+			sort.Strings(filters[key])
+			continue
+		}
+		var list []string
+		if err := json.Unmarshal(value, &list); err != nil {
+			return nil, err
+		}
+		filters[key] = list
+	}
+	return filters, nil
+}
+
+// filterArgs returns the filters as nerdctl "key=value" arguments, for the supported keys
+func filterArgs(filters map[string][]string, supported ...string) []string {
+	args := []string{}
+	for _, key := range supported {
+		for _, value := range filters[key] {
+			args = append(args, key+"="+value)
+		}
+	}
+	return args
+}
+
+// matchValue checks that the value is one of the filter values
+func matchValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabels checks that the labels match all of the "label" filters ("key" or "key=value")
+func matchLabels(filters map[string][]string, labels map[string]string) bool {
+	for _, filter := range filters["label"] {
+		kv := strings.SplitN(filter, "=", 2)
+		value, ok := labels[kv[0]]
+		if !ok || (len(kv) == 2 && value != kv[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// errorStatus guesses the HTTP status code, from the error message of nerdctl
+func errorStatus(err error) int {
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "not found"), strings.Contains(msg, "no such"):
+		return http.StatusNotFound
+	case strings.Contains(msg, "invalid filter"), strings.Contains(msg, "unsupported filter"), strings.Contains(msg, "invalid argument"):
+		return http.StatusBadRequest
+	case strings.Contains(msg, "in use"), strings.Contains(msg, "already exists"), strings.Contains(msg, "is running"),
+		strings.Contains(msg, "already used"), strings.Contains(msg, "multiple ids"):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,352 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+)
+
+// HealthProbes runs the healthchecks in nerdctld, for nerdctl versions without them.
+// The requested healthcheck is stored in a label, and the image healthcheck is used otherwise.
+var HealthProbes bool
+
+// healthcheckLabel is the label with the requested healthcheck, as JSON
+const healthcheckLabel = "nerdctld/healthcheck"
+
+// the defaults of docker, for the healthcheck options that are not set
+const (
+	defaultProbeInterval = 30 * time.Second
+	defaultProbeTimeout  = 30 * time.Second
+	defaultProbeRetries  = 3
+	maxProbeOutput       = 4096
+	maxProbeLog          = 5
+)
+
+type healthResult struct {
+	Start    time.Time
+	End      time.Time
+	ExitCode int
+	Output   string
+}
+
+// healthProbe is the healthcheck of a running container, and the results so far
+type healthProbe struct {
+	cmd         []string
+	interval    time.Duration
+	timeout     time.Duration
+	startPeriod time.Duration
+	retries     int
+	started     string
+	next        time.Time
+	running     bool
+
+	Status        string
+	FailingStreak int
+	Log           []healthResult
+}
+
+// probeErrors are the errors of listing the containers to probe
+var probeErrors loopError
+
+var probes = struct {
+	sync.Mutex
+	m map[string]*healthProbe
+	// restored are the results from before the restart, until the probe is created again
+	restored map[string]*healthProbe
+}{m: map[string]*healthProbe{}, restored: map[string]*healthProbe{}}
+
+// savedProbe is the state of a probe, as it is saved
+type savedProbe struct {
+	Started       string
+	Status        string
+	FailingStreak int
+	Log           []healthResult
+}
+
+var healthSaver = newStateSaver("health", func() interface{} {
+	probes.Lock()
+	defer probes.Unlock()
+	saved := map[string]savedProbe{}
+	for id, p := range probes.m {
+		if p.cmd != nil {
+			saved[id] = savedProbe{Started: p.started, Status: p.Status, FailingStreak: p.FailingStreak, Log: p.Log}
+		}
+	}
+	return saved
+})
+
+// loadHealth restores the health results, so the status doesn't start over after a restart
+func loadHealth() error {
+	saved := map[string]savedProbe{}
+	if err := loadState("health", &saved); err != nil {
+		return err
+	}
+	probes.Lock()
+	defer probes.Unlock()
+	for id, s := range saved {
+		probes.restored[id] = &healthProbe{started: s.Started, Status: s.Status, FailingStreak: s.FailingStreak, Log: s.Log}
+	}
+	return nil
+}
+
+// StartHealthProbes runs the healthchecks of the running containers, until the context is done
+func StartHealthProbes(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			scheduleProbes()
+		}
+	}()
+}
+
+func scheduleProbes() {
+	var inspects map[string]map[string]interface{}
+	containers, err := backend.Containers(false)
+	if err == nil {
+		inspects, err = backend.InspectContainers(containerUpdates(containers))
+	}
+	probeErrors.log("health", err)
+	if err != nil {
+		return
+	}
+	probes.Lock()
+	defer probes.Unlock()
+	for id := range probes.m {
+		if _, ok := inspects[id]; !ok {
+			delete(probes.m, id)
+		}
+	}
+	now := time.Now()
+	for id, inspect := range inspects {
+		state, _ := inspect["State"].(map[string]interface{})
+		started, _ := state["StartedAt"].(string)
+		p, ok := probes.m[id]
+		if !ok || p.started != started {
+			// new container, or restarted
+			p = newProbe(inspect, started)
+			if r := probes.restored[id]; r != nil && r.started == started && p.cmd != nil {
+				// the container kept running, while the daemon was restarted
+				p.Status, p.FailingStreak, p.Log = r.Status, r.FailingStreak, r.Log
+			}
+			delete(probes.restored, id)
+			probes.m[id] = p
+		}
+		if p.cmd == nil || p.running || now.Before(p.next) {
+			continue
+		}
+		p.running = true
+		go runProbe(id, p)
+	}
+}
+
+// newProbe returns the probe for the container, without a command if it has no healthcheck
+func newProbe(inspect map[string]interface{}, started string) *healthProbe {
+	none := &healthProbe{started: started}
+	if healthStatus(inspect) != "none" {
+		// nerdctl runs the healthcheck itself
+		return none
+	}
+	config, _ := inspect["Config"].(map[string]interface{})
+	labels := stringMap(config["Labels"])
+	var check backend.Healthcheck
+	if label, ok := labels[healthcheckLabel]; ok {
+		if err := json.Unmarshal([]byte(label), &check); err != nil || check.Disable || check.Cmd == "" {
+			return none
+		}
+		return probeDefaults(&healthProbe{cmd: []string{"/bin/sh", "-c", check.Cmd},
+			interval: check.Interval, timeout: check.Timeout, startPeriod: check.StartPeriod, retries: check.Retries}, started)
+	}
+	image, _ := config["Image"].(string)
+	if image == "" {
+		image, _ = inspect["Image"].(string)
+	}
+	img, err := backend.Image(image)
+	if err != nil {
+		return none
+	}
+	imgConfig, _ := img["Config"].(map[string]interface{})
+	hc, _ := imgConfig["Healthcheck"].(map[string]interface{})
+	test := stringArray(arrayOrEmpty(hc["Test"]))
+	if len(test) < 2 {
+		return none
+	}
+	p := &healthProbe{cmd: test[1:]}
+	switch test[0] {
+	case "CMD":
+	case "CMD-SHELL":
+		p.cmd = []string{"/bin/sh", "-c", test[1]}
+	default:
+		return none
+	}
+	duration := func(v interface{}) time.Duration {
+		f, _ := v.(float64)
+		return time.Duration(f)
+	}
+	p.interval = duration(hc["Interval"])
+	p.timeout = duration(hc["Timeout"])
+	p.startPeriod = duration(hc["StartPeriod"])
+	retries, _ := hc["Retries"].(float64)
+	p.retries = int(retries)
+	return probeDefaults(p, started)
+}
+
+func arrayOrEmpty(v interface{}) []interface{} {
+	if a, ok := v.([]interface{}); ok {
+		return a
+	}
+	return []interface{}{}
+}
+
+func probeDefaults(p *healthProbe, started string) *healthProbe {
+	if p.interval <= 0 {
+		p.interval = defaultProbeInterval
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultProbeTimeout
+	}
+	if p.retries <= 0 {
+		p.retries = defaultProbeRetries
+	}
+	p.started = started
+	p.next = time.Now().Add(p.interval)
+	p.Status = "starting"
+	p.Log = []healthResult{}
+	return p
+}
+
+// runProbe runs the healthcheck command in the container, and updates the status
+func runProbe(id string, p *healthProbe) {
+	cmd := backend.Exec(id, p.cmd, backend.ExecOptions{})
+	timeout := p.timeout
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	result := healthResult{Start: time.Now()}
+	var timedOut atomic.Bool
+	err := cmd.Start()
+	if err == nil {
+		timer := time.AfterFunc(timeout, func() {
+			timedOut.Store(true)
+			_ = cmd.Process.Kill()
+		})
+		err = cmd.Wait()
+		timer.Stop()
+	}
+	result.End = time.Now()
+	result.Output = output.String()
+	if len(result.Output) > maxProbeOutput {
+		result.Output = result.Output[:maxProbeOutput]
+	}
+	switch {
+	case timedOut.Load():
+		result.ExitCode = -1
+		result.Output = fmt.Sprintf("Health check exceeded timeout (%v)", timeout)
+	case err != nil && cmd.ProcessState == nil:
+		result.ExitCode = -1
+		result.Output = err.Error()
+	case err != nil:
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	probes.Lock()
+	defer probes.Unlock()
+	p.running = false
+	p.next = time.Now().Add(p.interval)
+	p.Log = append(p.Log, result)
+	if len(p.Log) > maxProbeLog {
+		p.Log = p.Log[len(p.Log)-maxProbeLog:]
+	}
+	previous := p.Status
+	if result.ExitCode == 0 {
+		p.FailingStreak = 0
+		p.Status = "healthy"
+	} else if started, err := time.Parse(time.RFC3339Nano, p.started); err == nil &&
+		p.Status == "starting" && time.Since(started) < p.startPeriod {
+		// failures in the start period don't count
+	} else {
+		p.FailingStreak++
+		if p.FailingStreak >= p.retries {
+			p.Status = "unhealthy"
+		}
+	}
+	healthSaver.changed()
+	if p.Status != previous {
+		log.Printf("health %s: %s", id, p.Status)
+		events.publishHealth(id, p.Status)
+	}
+}
+
+// probeHealth returns the State.Health of the container, if it is probed by nerdctld
+func probeHealth(id string) (map[string]interface{}, bool) {
+	probes.Lock()
+	defer probes.Unlock()
+	p := probes.m[id]
+	if p == nil && len(id) >= 12 {
+		// the probes have the short IDs from ps, and inspect has the full IDs
+		for short, probe := range probes.m {
+			if strings.HasPrefix(id, short) || strings.HasPrefix(short, id) {
+				p = probe
+			}
+		}
+	}
+	if p == nil || p.cmd == nil {
+		return nil, false
+	}
+	results := []interface{}{}
+	for _, r := range p.Log {
+		results = append(results, map[string]interface{}{
+			"Start": r.Start.Format(time.RFC3339Nano), "End": r.End.Format(time.RFC3339Nano),
+			"ExitCode": r.ExitCode, "Output": r.Output})
+	}
+	return map[string]interface{}{"Status": p.Status, "FailingStreak": p.FailingStreak, "Log": results}, true
+}
+
+// withProbeHealth returns the inspect with the State.Health from the probe (if any),
+// as a copy since the inspect is shared with the cache
+func withProbeHealth(id string, inspect map[string]interface{}) map[string]interface{} {
+	health, ok := probeHealth(id)
+	if !ok {
+		return inspect
+	}
+	result := map[string]interface{}{}
+	for k, v := range inspect {
+		result[k] = v
+	}
+	state := map[string]interface{}{}
+	if s, ok := inspect["State"].(map[string]interface{}); ok {
+		for k, v := range s {
+			state[k] = v
+		}
+	}
+	state["Health"] = health
+	result["State"] = state
+	return result
+}
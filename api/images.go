@@ -0,0 +1,565 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+func getImages(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	manifests := c.Query("manifests") == "1" || c.Query("manifests") == "true"
+	type descriptor struct {
+		MediaType string `json:"mediaType,omitempty"`
+		Digest    string `json:"digest"`
+		Size      int64  `json:"size"`
+	}
+	type manifest struct {
+		ID         string
+		Descriptor descriptor
+		Available  bool
+		Kind       string
+		ImageData  struct {
+			Platform struct {
+				Architecture string `json:"architecture"`
+				OS           string `json:"os"`
+				Variant      string `json:"variant,omitempty"`
+			}
+		}
+	}
+	type img struct {
+		ID          string `json:"Id"`
+		ParentID    string `json:"ParentId"`
+		RepoTags    []string
+		RepoDigests []string
+		Created     int64
+		Size        int64
+		Labels      map[string]string
+		Manifests   []manifest `json:",omitempty"`
+	}
+	imgs := []img{}
+	images, err := backend.Images(filterArgs(filters, "reference", "label", "dangling", "before", "since")...)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	updated := map[string]string{}
+	for _, image := range images {
+		updated[image["ID"].(string)] = image["CreatedAt"].(string)
+	}
+	inspects, err := backend.InspectImages(updated)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, image := range images {
+		var img img
+		img.ID = image["ID"].(string)
+		img.RepoTags = []string{image["Repository"].(string) + ":" + image["Tag"].(string)}
+		img.RepoDigests = []string{}
+		if digest, _ := image["Digest"].(string); digest != "" && image["Repository"] != "<none>" {
+			img.RepoDigests = append(img.RepoDigests, image["Repository"].(string)+"@"+digest)
+		}
+		if img.Created, err = unixTime(image["CreatedAt"].(string)); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		img.Size = backend.ByteSize(image["Size"].(string))
+		if inspect, ok := inspects[img.ID]; ok {
+			if config, ok := inspect["Config"].(map[string]interface{}); ok {
+				img.Labels = stringMap(config["Labels"])
+			}
+			img.ParentID, _ = inspect["Parent"].(string)
+		}
+		if manifests && CompareVersions(apiVersion(c), "1.46") >= 0 {
+			var m manifest
+			m.ID = image["Digest"].(string)
+			m.Descriptor = descriptor{Digest: m.ID}
+			if size, ok := image["BlobSize"].(string); ok {
+				m.Descriptor.Size = backend.ByteSize(size)
+			}
+			m.Available = true
+			m.Kind = "image"
+			if platform, ok := image["Platform"].(string); ok {
+				p := strings.SplitN(platform, "/", 3)
+				if len(p) > 1 {
+					m.ImageData.Platform.OS = p[0]
+					m.ImageData.Platform.Architecture = p[1]
+				}
+				if len(p) > 2 {
+					m.ImageData.Platform.Variant = p[2]
+				}
+			}
+			img.Manifests = []manifest{m}
+		}
+		imgs = append(imgs, img)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, imgs)
+}
+
+func inspectImage(c *gin.Context) {
+	name := c.Param("name")
+	image, err := backend.Image(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, image)
+}
+
+func getImageHistory(c *gin.Context) {
+	name := c.Param("name")
+	nchistory, err := backend.History(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	type hist struct {
+		Comment   string   `json:"Comment"`
+		Created   int64    `json:"Created"`
+		CreatedBy string   `json:"CreatedBy"`
+		ID        string   `json:"Id"`
+		Size      int64    `json:"Size"`
+		Tags      []string `json:"Tags"`
+	}
+	// like dockerd, only the top layer is a local image (with the tags),
+	// the others are "<missing>" since they are not images of their own
+	var id string
+	var tags []string
+	if image, err := backend.Image(name); err == nil {
+		id, _ = image["Id"].(string)
+		if repoTags, ok := image["RepoTags"].([]interface{}); ok {
+			tags = stringArray(repoTags)
+		}
+	}
+	history := []hist{}
+	for i, nch := range nchistory {
+		var h hist
+		h.ID = "<missing>"
+		if i == 0 && id != "" {
+			h.ID = id
+			h.Tags = tags
+		}
+		if createdAt, ok := nch["CreatedAt"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
+				h.Created = t.Unix()
+			}
+		}
+		if h.Created == 0 {
+			var err error
+			if h.Created, err = unixNatural(nch["CreatedSince"].(string)); err != nil {
+				httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		h.CreatedBy = nch["CreatedBy"].(string)
+		// the size is in bytes with --human=false, but older nerdctl ignores it
+		switch size := nch["Size"].(type) {
+		case float64:
+			h.Size = int64(size)
+		case string:
+			if size == "" || size == "-" {
+				// unknown, like for the layers that are not local
+				break
+			}
+			if h.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+				h.Size = backend.ByteSize(size)
+			}
+		}
+		// the size of a layer is never negative in dockerd, so don't render one
+		if h.Size < 0 {
+			h.Size = 0
+		}
+		h.Comment = nch["Comment"].(string)
+		history = append(history, h)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, history)
+}
+
+// regular expressions for the repository and tag, of an image reference (like docker)
+var (
+	reRepository = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	reTag       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	reImageID   = regexp.MustCompile(`^(sha256:)?[a-f0-9]{12,64}$`)
+	reHexString = regexp.MustCompile(`^[a-f0-9]{64}$`)
+)
+
+// tagReference returns the reference for the repo and tag, or an error if invalid
+func tagReference(repo string, tag string) (string, error) {
+	// the tag can also be part of the repo, when not given (but not the registry port)
+	if i := strings.LastIndex(repo, ":"); tag == "" && i > strings.LastIndex(repo, "/") {
+		repo, tag = repo[:i], repo[i+1:]
+	}
+	if tag == "" {
+		tag = "latest"
+	}
+	if reHexString.MatchString(repo) {
+		return "", fmt.Errorf("invalid repository name (%s), cannot specify 64-byte hexadecimal strings", repo)
+	}
+	if len(repo) > 255 || !reRepository.MatchString(repo) || !reTag.MatchString(tag) {
+		return "", fmt.Errorf("Error parsing reference: %q is not a valid repository/tag: invalid reference format", repo+":"+tag)
+	}
+	return repo + ":" + tag, nil
+}
+
+func tagImage(c *gin.Context) {
+	name := c.Param("name")
+	target, err := tagReference(c.Query("repo"), c.Query("tag"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	image, err := backend.Image(name)
+	if err != nil {
+		httpError(c.Writer, fmt.Sprintf("No such image: %s", name), http.StatusNotFound)
+		return
+	}
+	// tag by image ID or digest, using the name of the image instead
+	if reImageID.MatchString(name) || strings.Contains(name, "@") {
+		for _, key := range []string{"RepoTags", "RepoDigests"} {
+			if refs := stringArray(arrayOrEmpty(image[key])); len(refs) > 0 {
+				name = refs[0]
+				break
+			}
+		}
+	}
+	if err := backend.Tag(name, target); err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusCreated)
+}
+
+func pushImage(c *gin.Context) {
+	name := c.Param("name")
+	tag := c.Query("tag")
+	name = name + ":" + tag
+	logRequest(c, "name: %s", name)
+	// push the short name to the configured registry, so tag it there first
+	if resolved := resolveShortName(name); resolved != name {
+		if _, err := backend.Image(resolved); err != nil {
+			if err := backend.Tag(name, resolved); err != nil {
+				httpError(c.Writer, err.Error(), errorStatus(err))
+				return
+			}
+		}
+		name = resolved
+	}
+	sw := stream.NewWriter(c.Writer)
+	err := backend.Push(name, registryAuth(c), sw)
+	if err != nil {
+		logRequest(c, "push %s: %v", name, err)
+		sw.Error(err, http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func pullImage(c *gin.Context) {
+	name := c.Query("fromImage")
+	// the tag can also be a digest, or be part of the image name
+	if tag := c.Query("tag"); strings.Contains(tag, ":") {
+		name = name + "@" + tag
+	} else if tag != "" {
+		name = name + ":" + tag
+	}
+	logRequest(c, "name: %s", name)
+	resolved := resolveShortName(name)
+	sw := stream.NewWriter(c.Writer)
+	err := backend.Pull(resolved, c.Query("platform"), registryAuth(c), sw)
+	if err != nil {
+		logRequest(c, "pull %s: %v", resolved, err)
+		sw.Error(err, http.StatusInternalServerError)
+		return
+	}
+	// the client expects to find the image by the name it asked for
+	if resolved != name {
+		if err := backend.Tag(resolved, name); err != nil {
+			sw.Error(err, http.StatusInternalServerError)
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+func removeImage(c *gin.Context) {
+	name := c.Param("name")
+	// handle extra slash from using parameter wildcard
+	if strings.HasPrefix(name, "/") {
+		name = strings.Replace(name, "/", "", 1)
+	}
+	logRequest(c, "name: %s", name)
+	err := backend.Rmi(name, c.Writer)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func loadImage(c *gin.Context) {
+	quiet := c.Query("quiet")
+	var r io.Reader
+	switch {
+	case c.Query("upload") != "":
+		// the tarball was uploaded in chunks before, to /nerdctld/uploads
+		path, err := uploadPath(c.Query("upload"))
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		unlock, err := lockUpload(c.Query("upload"))
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusConflict)
+			return
+		}
+		defer unlock()
+		f, err := os.Open(path)
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		r = f
+	case c.Query("fromSrc") != "":
+		// like the import of images/create, but for a tarball of images
+		resp, err := fetchURL(c.Request.Context(), c.Query("fromSrc"))
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer resp.Body.Close()
+		r = resp.Body
+	default:
+		contentType := c.Request.Header.Get("Content-Type")
+		if contentType != "application/tar" && contentType != "application/x-tar" {
+			httpError(c.Writer, fmt.Sprintf("%s not tar", contentType), http.StatusBadRequest)
+			return
+		}
+		r = c.Request.Body
+	}
+	br := bufio.NewReader(r)
+	sw := stream.NewWriter(c.Writer)
+	err := backend.Load(quiet == "1", br, sw)
+	if err != nil {
+		logRequest(c, "load: %v", err)
+		sw.Error(err, http.StatusInternalServerError)
+		return
+	}
+	if id := c.Query("upload"); id != "" {
+		if path, err := uploadPath(id); err == nil {
+			os.Remove(path)
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+// fetchTimeout is how long fetching a URL waits for the connection, the response headers
+// or the next data of the body (the whole download can take longer)
+var fetchTimeout = time.Minute
+
+// fetchClient is the client for fetching the URLs, which doesn't wait forever for a stalled server
+func fetchClient() *http.Client {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: fetchTimeout}).DialContext,
+		TLSHandshakeTimeout:   fetchTimeout,
+		ResponseHeaderTimeout: fetchTimeout,
+		DisableKeepAlives:     true,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// idleReader cancels the request, when the body has had no data for the timeout
+type idleReader struct {
+	body   io.ReadCloser
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.timer.Reset(fetchTimeout)
+	}
+	return n, err
+}
+
+func (r *idleReader) Close() error {
+	r.timer.Stop()
+	r.cancel()
+	return r.body.Close()
+}
+
+// fetchURL gets the http or https URL, or returns an error for other schemes (or status)
+func fetchURL(ctx context.Context, src string) (*http.Response, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL: %s", src)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := fetchClient().Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%s: %s", src, resp.Status)
+	}
+	resp.Body = &idleReader{body: resp.Body, timer: time.AfterFunc(fetchTimeout, cancel), cancel: cancel}
+	return resp, nil
+}
+
+// countWriter counts the bytes written, to know if the response has started
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func saveImages(c *gin.Context) {
+	names, exists := c.GetQueryArray("names")
+	if name := c.Param("name"); name != "" {
+		names, exists = []string{name}, true
+	}
+	if !exists {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	// extension, for the tools that want an OCI image layout (like skopeo or oras)
+	format := c.Query("format")
+	switch format {
+	case "", "docker", "oci":
+	default:
+		httpError(c.Writer, fmt.Sprintf("invalid format: %s (should be docker or oci)", format), http.StatusBadRequest)
+		return
+	}
+	logRequest(c, "names: %s", names)
+	c.Writer.Header().Set("Content-Type", "application/x-tar")
+	cw := &countWriter{w: c.Writer}
+	// the context is done when the client disconnects, which kills the save
+	err := backend.Save(c.Request.Context(), names, format, cw)
+	if err != nil {
+		if cw.n == 0 {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+		} else {
+			// too late to report it, so just cut off the archive
+			logRequest(c, "save %s: %v", names, err)
+		}
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func pruneImages(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	all := len(filters["dangling"]) > 0 && (filters["dangling"][0] == "0" || filters["dangling"][0] == "false")
+	var ip struct {
+		ImagesDeleted  []map[string]string
+		SpaceReclaimed int64
+	}
+	ip.ImagesDeleted = []map[string]string{}
+	if len(filters["label"]) > 0 {
+		// nerdctl prune has no filters, so remove the labeled images that are not in use
+		args := filterArgs(filters, "label")
+		if !all {
+			args = append(args, "dangling=true")
+		}
+		images, err := backend.Images(args...)
+		if err != nil {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+			return
+		}
+		for _, image := range images {
+			id, _ := image["ID"].(string)
+			if err := backend.Rmi(id, io.Discard); err == nil {
+				ip.ImagesDeleted = append(ip.ImagesDeleted, map[string]string{"Deleted": id})
+				ip.SpaceReclaimed += backend.ByteSize(image["Size"].(string))
+			}
+		}
+	} else {
+		deleted, err := backend.PruneImages(all)
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, name := range deleted {
+			ip.ImagesDeleted = append(ip.ImagesDeleted, map[string]string{"Deleted": name})
+		}
+	}
+	c.JSON(http.StatusOK, ip)
+}
+
+func inspectDistribution(c *gin.Context) {
+	name := c.Param("name")
+	desc, platforms, err := backend.DistributionInspect(name, registryAuth(c))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if strings.Contains(err.Error(), "not found") {
+			code = http.StatusNotFound
+		} else if strings.Contains(err.Error(), "authentication") {
+			code = http.StatusUnauthorized
+		}
+		httpError(c.Writer, err.Error(), code)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]interface{}{"Descriptor": desc, "Platforms": platforms})
+}
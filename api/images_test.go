@@ -0,0 +1,95 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchURL(t *testing.T) {
+	saved := fetchTimeout
+	fetchTimeout = 200 * time.Millisecond
+	defer func() { fetchTimeout = saved }()
+
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stalled-headers", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/stalled-body", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("some"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/slow-body", func(w http.ResponseWriter, r *http.Request) {
+		// slower than the timeout in total, but never idle for that long
+		for i := 0; i < 8; i++ {
+			_, _ = w.Write([]byte("x"))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	if _, err := fetchURL(context.Background(), srv.URL+"/stalled-headers"); err == nil {
+		t.Error("stalled headers: expected an error")
+	}
+
+	resp, err := fetchURL(context.Background(), srv.URL+"/stalled-body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("stalled body: expected an error")
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled body: took %v", elapsed)
+	}
+
+	resp, err = fetchURL(context.Background(), srv.URL+"/slow-body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(data) != "xxxxxxxx" {
+		t.Errorf("slow body: %q, %v", data, err)
+	}
+
+	if _, err := fetchURL(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Error("missing: expected an error")
+	}
+	if _, err := fetchURL(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("file URL: expected an error")
+	}
+}
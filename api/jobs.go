@@ -0,0 +1,300 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// jobRetention is how long the finished jobs are kept, for the clients to get the result
+	jobRetention = time.Hour
+	// maxJobLog is the most output that is kept for a job (the end of it)
+	maxJobLog = 1024 * 1024
+)
+
+// Job is a long-running operation (like pull or build), that runs detached from the client
+type Job struct {
+	ID       string `json:"Id"`
+	Type     string
+	Status   string // "running", "succeeded" or "failed"
+	Error    string `json:",omitempty"`
+	Created  string
+	Finished string `json:",omitempty"`
+
+	log      []byte
+	finished time.Time
+}
+
+var jobs = struct {
+	sync.Mutex
+	m map[string]*Job
+}{m: map[string]*Job{}}
+
+// newJob registers a new running job, of the type
+func newJob(typ string) *Job {
+	j := &Job{ID: randomID(), Type: typ, Status: "running", Created: time.Now().UTC().Format(time.RFC3339Nano)}
+	jobs.Lock()
+	defer jobs.Unlock()
+	pruneJobs()
+	jobs.m[j.ID] = j
+	return j
+}
+
+// pruneJobs removes the jobs that finished too long ago (with the lock held)
+func pruneJobs() {
+	for id, j := range jobs.m {
+		if !j.finished.IsZero() && time.Since(j.finished) > jobRetention {
+			delete(jobs.m, id)
+		}
+	}
+}
+
+// getJob returns a copy of the job, or nil if there is none
+func getJob(id string) *Job {
+	jobs.Lock()
+	defer jobs.Unlock()
+	j, ok := jobs.m[id]
+	if !ok {
+		return nil
+	}
+	jc := *j
+	jc.log = append([]byte(nil), j.log...)
+	return &jc
+}
+
+// jobWriter is the response of the detached request, which is kept in the job
+// (with no connection behind it, since the client has already got its reply)
+type jobWriter struct {
+	job    *Job
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *jobWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *jobWriter) WriteHeader(code int) {
+	if w.size == 0 {
+		w.status = code
+	}
+}
+
+func (w *jobWriter) WriteHeaderNow() {}
+
+func (w *jobWriter) Write(p []byte) (int, error) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	w.job.log = append(w.job.log, p...)
+	if len(w.job.log) > maxJobLog {
+		w.job.log = append([]byte(nil), w.job.log[len(w.job.log)-maxJobLog:]...)
+	}
+	w.size += len(p)
+	return len(p), nil
+}
+
+func (w *jobWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *jobWriter) Status() int {
+	return w.status
+}
+
+func (w *jobWriter) Size() int {
+	return w.size
+}
+
+func (w *jobWriter) Written() bool {
+	return w.size > 0
+}
+
+func (w *jobWriter) Flush() {}
+
+func (w *jobWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("the job has no connection")
+}
+
+func (w *jobWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *jobWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// fail marks the job as failed, when the handler panicked
+func (w *jobWriter) fail(err interface{}) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	j := w.job
+	j.finished = time.Now()
+	j.Finished = j.finished.UTC().Format(time.RFC3339Nano)
+	j.Status = "failed"
+	j.Error = fmt.Sprintf("panic: %v", err)
+}
+
+// finish sets the result of the job, from the reply or from the last message of the stream
+func (w *jobWriter) finish() {
+	jobs.Lock()
+	defer jobs.Unlock()
+	j := w.job
+	j.finished = time.Now()
+	j.Finished = j.finished.UTC().Format(time.RFC3339Nano)
+	j.Status = "succeeded"
+	lines := bytes.Split(bytes.TrimSpace(j.log), []byte("\n"))
+	var last struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	_ = json.Unmarshal(lines[len(lines)-1], &last)
+	if w.status >= http.StatusBadRequest {
+		j.Status = "failed"
+		j.Error = last.Message
+		if j.Error == "" {
+			j.Error = http.StatusText(w.status)
+		}
+	} else if last.Error != "" {
+		j.Status = "failed"
+		j.Error = last.Error
+	}
+}
+
+// detachable runs the request as a job when asked to (with "detach=1"), replying with the ID
+// of the job right away, so that long operations don't depend on the client connection.
+func detachable(typ string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if detach, _ := strconv.ParseBool(c.Query("detach")); !detach {
+			c.Next()
+			return
+		}
+		// the body has to be read before replying, so spool it to disk
+		body, err := os.CreateTemp("", "nerdctld-job")
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+		cleanup := func() {
+			body.Close()
+			os.Remove(body.Name())
+		}
+		if c.Request.Body != nil {
+			if _, err := io.Copy(body, c.Request.Body); err != nil {
+				cleanup()
+				httpError(c.Writer, err.Error(), http.StatusBadRequest)
+				c.Abort()
+				return
+			}
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				cleanup()
+				httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+				c.Abort()
+				return
+			}
+		}
+		j := newJob(typ)
+		// the handler runs on its own, with a copy of the context (gin reuses this one),
+		// so that the connection is free for the next request (like polling the job)
+		handler := c.Handler()
+		jc := c.Copy()
+		w := &jobWriter{job: j, header: http.Header{}, status: http.StatusOK}
+		jc.Request = c.Request.Clone(context.WithoutCancel(c.Request.Context()))
+		jc.Request.Body = body
+		jc.Writer = w
+		go func() {
+			defer cleanup()
+			defer func() {
+				if err := recover(); err != nil {
+					logRequest(jc, "job %s: panic: %v\n%s", j.ID, err, debug.Stack())
+					w.fail(err)
+				}
+			}()
+			handler(jc)
+			w.finish()
+		}()
+		c.Abort()
+		c.JSON(http.StatusAccepted, map[string]string{"Id": j.ID})
+	}
+}
+
+func getJobs(c *gin.Context) {
+	jobs.Lock()
+	pruneJobs()
+	list := []Job{}
+	for _, j := range jobs.m {
+		list = append(list, Job{ID: j.ID, Type: j.Type, Status: j.Status, Error: j.Error, Created: j.Created, Finished: j.Finished})
+	}
+	jobs.Unlock()
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].Created < list[k].Created
+	})
+	c.JSON(http.StatusOK, list)
+}
+
+func inspectJob(c *gin.Context) {
+	j := getJob(c.Param("id"))
+	if j == nil {
+		httpError(c.Writer, fmt.Sprintf("No such job: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
+
+// getJobLogs replies with the output of the job so far, like the reply of the request
+func getJobLogs(c *gin.Context) {
+	j := getJob(c.Param("id"))
+	if j == nil {
+		httpError(c.Writer, fmt.Sprintf("No such job: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "application/json", j.log)
+}
+
+func removeJob(c *gin.Context) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	j, ok := jobs.m[c.Param("id")]
+	if !ok {
+		httpError(c.Writer, fmt.Sprintf("No such job: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	if j.finished.IsZero() {
+		httpError(c.Writer, fmt.Sprintf("job %s is running", j.ID), http.StatusConflict)
+		return
+	}
+	delete(jobs.m, j.ID)
+	c.Status(http.StatusNoContent)
+}
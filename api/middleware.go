@@ -0,0 +1,268 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+// gzipWriter compresses the response body written by the handler, unless the
+// response has no body (HEAD, 204 No Content and 304 Not Modified)
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	head    bool
+	started bool
+}
+
+// start decides whether to compress, from the status (before anything is written)
+func (g *gzipWriter) start(code int) {
+	if g.started {
+		return
+	}
+	g.started = true
+	if g.head || code == http.StatusNoContent || code == http.StatusNotModified || code < http.StatusOK {
+		return
+	}
+	g.Header().Set("Content-Encoding", "gzip")
+	g.Header().Del("Content-Length")
+	g.gz = gzip.NewWriter(g.ResponseWriter)
+}
+
+func (g *gzipWriter) Write(data []byte) (int, error) {
+	g.start(g.Status())
+	if g.gz == nil {
+		return g.ResponseWriter.Write(data)
+	}
+	return g.gz.Write(data)
+}
+
+func (g *gzipWriter) WriteString(s string) (int, error) {
+	return g.Write([]byte(s))
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	g.start(code)
+	g.ResponseWriter.WriteHeader(code)
+}
+
+// Flush sends what has been compressed so far, for streaming responses
+func (g *gzipWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	g.ResponseWriter.Flush()
+}
+
+func (g *gzipWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// acceptsGzip checks the Accept-Encoding header for gzip (or "*"), with a q-value above zero
+func acceptsGzip(header string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.TrimSpace(key) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = f
+				} else {
+					q = 0
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// gzipResponse honors "Accept-Encoding: gzip" for large responses
+func gzipResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.Request.Header.Get("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+		g := &gzipWriter{ResponseWriter: c.Writer, head: c.Request.Method == http.MethodHead}
+		c.Writer = g
+		defer g.close()
+		c.Next()
+	}
+}
+
+// requireNerdctl replies with an error, while the nerdctl command is missing
+// (like when started before containerd, with socket activation)
+func requireNerdctl() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := backend.Available(); err != nil {
+			// serve what can be served with ctr instead, if it is available
+			if fallback(c) {
+				c.Abort()
+				return
+			}
+			message := err.Error()
+			if backend.Fallback() {
+				message += ", " + strings.TrimPrefix(fallbackWarning, "nerdctl is not available, ")
+			}
+			httpError(c.Writer, message, http.StatusServiceUnavailable)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// resolveContainer finds the container by full ID, name or ID prefix (in that order, like docker),
+// and replaces the name parameter with the full ID. An ambiguous prefix replies "409 Conflict".
+func resolveContainer() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.FullPath(), "/:ver/containers/:name") {
+			c.Next()
+			return
+		}
+		name := strings.TrimPrefix(c.Param("name"), "/")
+		id, err := containerID(name)
+		if err != nil {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+			c.Abort()
+			return
+		}
+		for i := range c.Params {
+			if c.Params[i].Key == "name" {
+				c.Params[i].Value = id
+			}
+		}
+		c.Next()
+	}
+}
+
+// containerID returns the full ID of the container, for the full ID, name or ID prefix
+func containerID(name string) (string, error) {
+	if reHexString.MatchString(name) {
+		// already a full ID
+		return name, nil
+	}
+	// inspect finds a single container directly, which is faster than listing all of them
+	if ids, err := backend.ContainerIDs(name); err == nil && len(ids) == 1 && name != "" {
+		return ids[0], nil
+	}
+	// not found, or ambiguous, so look for it like docker does
+	names, err := backend.ContainerNames()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := names[name]; ok {
+		return name, nil
+	}
+	for id, n := range names {
+		if n == name {
+			return id, nil
+		}
+	}
+	var matches []string
+	for id := range names {
+		if name != "" && strings.HasPrefix(id, name) {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("No such container: %s", name)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("multiple IDs found with provided prefix: %s", name)
+}
+
+// requireFeature replies "501 Not Implemented" for the endpoint, when the installed
+// nerdctl is too old for the feature (instead of failing to run it later)
+func requireFeature(feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := backend.SupportsFeature(feature); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusNotImplemented)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestIDHeader is the ID of the request, for finding it in the logs of the daemon
+const requestIDHeader = "X-Request-Id"
+
+// requestIDKey is the key of the request ID, in the gin context
+const requestIDKey = "requestID"
+
+// reRequestID is the request IDs that are accepted from the client (or a proxy)
+var reRequestID = regexp.MustCompile(`^[\w.:-]{1,128}$`)
+
+// requestID uses the ID of the request from the client, or generates one,
+// and sends it back in the response (and in the error messages)
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !reRequestID.MatchString(id) {
+			id = randomID()[:32]
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// logRequest logs the message, prefixed with the ID of the request
+func logRequest(c *gin.Context, format string, v ...interface{}) {
+	log.Printf("[%s] "+format, append([]interface{}{c.GetString(requestIDKey)}, v...)...)
+}
+
+// accessLog is the format of the gin access log, with the ID of the request added
+func accessLog(param gin.LogFormatterParams) string {
+	id, _ := param.Keys[requestIDKey].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | %s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		id,
+		param.ErrorMessage,
+	)
+}
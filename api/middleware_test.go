@@ -0,0 +1,108 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                      false,
+		"gzip":                  true,
+		"deflate, gzip":         true,
+		"GZIP":                  true,
+		"gzip;q=0":              false,
+		"gzip; q=0.0":           false,
+		"gzip;q=0.5":            true,
+		"*":                     true,
+		"*;q=0":                 false,
+		"gzip;q=0, *":           false,
+		"*;q=0, gzip":           true,
+		"identity":              false,
+		"br;q=1.0, gzip;q=bad":  false,
+		"x-gzip":                true,
+		"deflate;q=1, gzip;q=1": true,
+	} {
+		if got := acceptsGzip(header); got != want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestGzipResponse(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gzipResponse())
+	r.GET("/json", func(c *gin.Context) { c.String(http.StatusOK, "hello") })
+	r.HEAD("/json", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.GET("/same", func(c *gin.Context) { c.Status(http.StatusNotModified) })
+	r.GET("/stream", func(c *gin.Context) {
+		c.String(http.StatusOK, "first")
+		c.Writer.Flush()
+	})
+
+	do := func(method, path, encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/json", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("GET: Content-Encoding %q", w.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != "hello" {
+		t.Errorf("GET: body %q", body)
+	}
+
+	if w := do("GET", "/json", "gzip;q=0"); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "hello" {
+		t.Errorf("q=0: Content-Encoding %q, body %q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+	for _, tc := range []struct{ method, path string }{{"HEAD", "/json"}, {"GET", "/empty"}, {"GET", "/same"}} {
+		if w := do(tc.method, tc.path, "gzip"); w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+			t.Errorf("%s %s: Content-Encoding %q, body %d bytes", tc.method, tc.path, w.Header().Get("Content-Encoding"), w.Body.Len())
+		}
+	}
+
+	// the flushed part can be read, before the end of the stream
+	w = do("GET", "/stream", "gzip")
+	if !w.Flushed {
+		t.Error("stream: not flushed")
+	}
+	gz, err = gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != "first" {
+		t.Errorf("stream: body %q", body)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+// the namespaces are a nerdctld extension, and not part of the Docker API
+
+func getNamespaces(c *gin.Context) {
+	namespaces, err := backend.Namespaces()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	current := backend.Namespace()
+	for _, ns := range namespaces {
+		ns["Active"] = ns["Name"] == current
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, namespaces)
+}
+
+func getNamespace(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, map[string]string{"Name": backend.Namespace()})
+}
+
+func useNamespace(c *gin.Context) {
+	name := c.Param("name")
+	if err := backend.SetNamespace(name); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,360 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+func nameNetworkDriver(name string) string {
+	switch name {
+	case "host":
+		return "host"
+	case "none":
+		return "null"
+	default:
+		return ""
+	}
+}
+
+func splitLabels(value string) map[string]string {
+	labels := map[string]string{}
+	for _, label := range strings.Split(value, ",") {
+		if kv := strings.Split(label, "="); len(kv) > 1 {
+			labels[kv[0]] = kv[1]
+		}
+	}
+	return labels
+}
+
+// the labels with the flags of the network, that nerdctl doesn't keep
+const (
+	networkInternalLabel   = "nerdctld/network.internal"
+	networkAttachableLabel = "nerdctld/network.attachable"
+)
+
+// networkFlags returns the EnableIPv6, Internal and Attachable flags of the network, from the
+// subnets of the inspect and from the labels (which are removed, from the labels of the network)
+func networkFlags(network map[string]interface{}, labels map[string]string) (ipv6 bool, internal bool, attachable bool) {
+	ipam, _ := network["IPAM"].(map[string]interface{})
+	configs, _ := ipam["Config"].([]interface{})
+	for _, config := range configs {
+		config, _ := config.(map[string]interface{})
+		if subnet, _ := config["Subnet"].(string); strings.Contains(subnet, ":") {
+			ipv6 = true
+		}
+	}
+	internal = labels[networkInternalLabel] == "true"
+	attachable = labels[networkAttachableLabel] == "true"
+	delete(labels, networkInternalLabel)
+	delete(labels, networkAttachableLabel)
+	return ipv6, internal, attachable
+}
+
+func getNetworks(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type net struct {
+		ID         string `json:"Id"`
+		Driver     string
+		Scope      string
+		Internal   bool
+		Attachable bool
+		Ingress    bool
+		EnableIPv6 bool
+		Labels     map[string]string
+		Options    map[string]string
+		Containers map[string]interface{}
+		Name       string
+	}
+	nets := []net{}
+	networks, err := backend.Networks(filterArgs(filters, "label", "name"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	// ls has no subnets, so inspect the networks (except for host and none) for them
+	names := []string{}
+	for _, network := range networks {
+		if name, _ := network["Name"].(string); nameNetworkDriver(name) == "" {
+			names = append(names, name)
+		}
+	}
+	inspects := map[string]map[string]interface{}{}
+	if inspect, err := backend.InspectNetworks(names); err == nil {
+		for _, network := range inspect {
+			name, _ := network["Name"].(string)
+			inspects[name] = network
+		}
+	} else {
+		logRequest(c, "network inspect: %v", err)
+	}
+	for _, network := range networks {
+		var net net
+		net.ID = network["ID"].(string)
+		net.Name = network["Name"].(string)
+		net.Driver = nameNetworkDriver(net.Name)
+		net.Scope = "local"
+		net.Labels = splitLabels(network["Labels"].(string))
+		net.EnableIPv6, net.Internal, net.Attachable = networkFlags(inspects[net.Name], net.Labels)
+		net.Options = map[string]string{}
+		net.Containers = map[string]interface{}{}
+		nets = append(nets, net)
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, nets)
+}
+
+func inspectNetwork(c *gin.Context) {
+	name := c.Param("name")
+	network, err := backend.Network(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	labels := stringMap(network["Labels"])
+	network["EnableIPv6"], network["Internal"], network["Attachable"] = networkFlags(network, labels)
+	network["Labels"] = labels
+	// nerdctl only has the name, id, ipam and labels
+	defaults := map[string]interface{}{
+		"Scope":      "local",
+		"Driver":     "bridge",
+		"EnableIPv6": false,
+		"Internal":   false,
+		"Attachable": false,
+		"Ingress":    false,
+		"Options":    map[string]string{},
+		"Containers": map[string]interface{}{},
+	}
+	if driver := nameNetworkDriver(name); driver != "" {
+		defaults["Driver"] = driver
+	}
+	for key, value := range defaults {
+		if _, ok := network[key]; !ok {
+			network[key] = value
+		}
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, network)
+}
+
+func createNetwork(c *gin.Context) {
+	var req struct {
+		Name   string
+		Driver string
+		IPAM   struct {
+			Driver string
+			Config []struct {
+				Subnet  string
+				IPRange string
+				Gateway string
+			}
+		}
+		Options    map[string]string
+		Labels     map[string]string
+		EnableIPv6 bool
+		Internal   bool
+		Attachable bool
+		Ingress    bool
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	opts := backend.NetworkOptions{Driver: req.Driver, Labels: map[string]string{}, Options: map[string]string{}}
+	for k, v := range req.Labels {
+		opts.Labels[k] = v
+	}
+	warnings := []string{}
+	if req.EnableIPv6 {
+		if err := backend.SupportsFeature("network-ipv6"); err != nil {
+			warnings = append(warnings, fmt.Sprintf("EnableIPv6 was ignored: %v", err))
+		} else {
+			opts.IPv6 = true
+		}
+	}
+	if req.Internal {
+		if err := backend.SupportsFeature("network-internal"); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Internal was ignored: %v", err))
+		} else {
+			opts.Internal = true
+			opts.Labels[networkInternalLabel] = "true"
+		}
+	}
+	if req.Attachable {
+		// all the networks can be attached to, without swarm
+		opts.Labels[networkAttachableLabel] = "true"
+	}
+	if req.Ingress {
+		warnings = append(warnings, "Ingress is only for swarm, which is not supported by nerdctl, and was ignored")
+	}
+	for k, v := range req.Options {
+		// the docker cli turns "-o --ip-masq" into an option, which the bridge ignores
+		if strings.HasPrefix(k, "-") {
+			continue
+		}
+		opts.Options[k] = v
+	}
+	if req.IPAM.Driver != "default" {
+		opts.IPAMDriver = req.IPAM.Driver
+	}
+	for _, config := range req.IPAM.Config {
+		if config.Subnet != "" {
+			opts.Subnets = append(opts.Subnets, config.Subnet)
+		}
+		if config.Gateway != "" {
+			opts.Gateway = config.Gateway
+		}
+		if config.IPRange != "" {
+			opts.IPRange = config.IPRange
+		}
+	}
+	id, err := backend.CreateNetwork(req.Name, opts)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusCreated, map[string]string{"Id": id, "Warning": strings.Join(warnings, "\n")})
+}
+
+func removeNetwork(c *gin.Context) {
+	name := c.Param("name")
+	err := backend.RemoveNetwork(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func pruneNetworks(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var deleted []string
+	if len(filters["label"]) > 0 {
+		// nerdctl prune has no filters, so remove the labeled networks that are not in use
+		networks, err := backend.Networks(filterArgs(filters, "label"))
+		if err != nil {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+			return
+		}
+		deleted = []string{}
+		for _, network := range networks {
+			name, _ := network["Name"].(string)
+			if err := backend.RemoveNetwork(name); err == nil {
+				deleted = append(deleted, name)
+			}
+		}
+	} else if deleted, err = backend.PruneNetworks(); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var np struct {
+		NetworksDeleted []string
+	}
+	np.NetworksDeleted = deleted
+	c.JSON(http.StatusOK, np)
+}
+
+// containerNetworks returns the names of the networks of the container
+func containerNetworks(name string) (map[string]bool, error) {
+	container, err := backend.Container(name)
+	if err != nil {
+		return nil, fmt.Errorf("No such container: %s", name)
+	}
+	networks := map[string]bool{}
+	for network := range inspectNetworks(container, nil) {
+		networks[network] = true
+	}
+	return networks, nil
+}
+
+func connectNetwork(c *gin.Context) {
+	name := c.Param("name")
+	var req struct {
+		Container      string
+		EndpointConfig interface{}
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if _, err := backend.Network(name); err != nil {
+		httpError(c.Writer, fmt.Sprintf("network %s not found", name), http.StatusNotFound)
+		return
+	}
+	networks, err := containerNetworks(req.Container)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	if networks[name] {
+		// already connected (at create), which is all that nerdctl can do
+		c.Status(http.StatusOK)
+		return
+	}
+	if id, created, ok := takeCreated(req.Container); ok {
+		// not started yet, so it can be created again with the network added
+		config := &created.config
+		if mode := config.HostConfig.NetworkMode; mode == "" || mode == "default" {
+			config.HostConfig.NetworkMode = "bridge"
+		}
+		if config.NetworkingConfig.EndpointsConfig == nil {
+			config.NetworkingConfig.EndpointsConfig = map[string]interface{}{}
+		}
+		config.NetworkingConfig.EndpointsConfig[name] = req.EndpointConfig
+		if _, err := recreateContainer(id, created); err != nil {
+			httpError(c.Writer, err.Error(), errorStatus(err))
+			return
+		}
+		c.Status(http.StatusOK)
+		return
+	}
+	httpError(c.Writer, "nerdctl does not support connecting running containers to networks", http.StatusNotImplemented)
+}
+
+func disconnectNetwork(c *gin.Context) {
+	name := c.Param("name")
+	var req struct {
+		Container string
+		Force     bool
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	networks, err := containerNetworks(req.Container)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !networks[name] {
+		httpError(c.Writer, fmt.Sprintf("container %s is not connected to network %s", req.Container, name), http.StatusForbidden)
+		return
+	}
+	httpError(c.Writer, "nerdctl does not support disconnecting containers from networks", http.StatusNotImplemented)
+}
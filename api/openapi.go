@@ -0,0 +1,268 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openapiSpec is the swagger definition of the Docker API, for the implemented endpoints
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// ValidateRequests checks the requests against the definition, before handling them
+var ValidateRequests bool
+
+type openapiSchema struct {
+	Type                 string                    `json:"type"`
+	Enum                 []string                  `json:"enum"`
+	Items                *openapiSchema            `json:"items"`
+	Properties           map[string]*openapiSchema `json:"properties"`
+	AdditionalProperties *openapiSchema            `json:"additionalProperties"`
+	Required             []string                  `json:"required"`
+}
+
+type openapiParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Type     string         `json:"type"`
+	Required bool           `json:"required"`
+	Enum     []string       `json:"enum"`
+	Schema   *openapiSchema `json:"schema"`
+}
+
+type openapiOperation struct {
+	Parameters []openapiParameter `json:"parameters"`
+}
+
+// openapiRoute is a path of the definition, with the operations for each method
+type openapiRoute struct {
+	path       string
+	re         *regexp.Regexp
+	operations map[string]openapiOperation
+}
+
+var openapi struct {
+	sync.Once
+	routes []openapiRoute
+}
+
+// regular expression for the version prefix, which is optional in the requests
+var reVersionPrefix = regexp.MustCompile(`^/v?[0-9]+[.][0-9]+(/.*)$`)
+
+// openapiRoutes parses the definition, with the static paths first (like /images/get)
+func openapiRoutes() []openapiRoute {
+	openapi.Do(func() {
+		var spec struct {
+			Paths map[string]map[string]openapiOperation `json:"paths"`
+		}
+		if err := json.Unmarshal(openapiSpec, &spec); err != nil {
+			panic(err)
+		}
+		for path, operations := range spec.Paths {
+			parts := strings.Split(path, "/")
+			for i, part := range parts {
+				switch {
+				case part == "{name}":
+					parts[i] = `.+` // image names have slashes
+				case strings.HasPrefix(part, "{"):
+					parts[i] = `[^/]+`
+				default:
+					parts[i] = regexp.QuoteMeta(part)
+				}
+			}
+			re := regexp.MustCompile("^" + strings.Join(parts, "/") + "$")
+			openapi.routes = append(openapi.routes, openapiRoute{path: path, re: re, operations: operations})
+		}
+		sort.Slice(openapi.routes, func(i, j int) bool {
+			a, b := openapi.routes[i].path, openapi.routes[j].path
+			if strings.Count(a, "{") != strings.Count(b, "{") {
+				return strings.Count(a, "{") < strings.Count(b, "{")
+			}
+			return a < b
+		})
+	})
+	return openapi.routes
+}
+
+func getOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openapiSpec)
+}
+
+// validateRequest replies with "400 Bad Request", if the request doesn't match the definition
+func validateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := validate(c.Request); err != nil {
+			httpError(c.Writer, err.Error(), http.StatusBadRequest)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func validate(r *http.Request) error {
+	path := r.URL.Path
+	if m := reVersionPrefix.FindStringSubmatch(path); m != nil {
+		path = m[1]
+	}
+	for _, route := range openapiRoutes() {
+		if !route.re.MatchString(path) {
+			continue
+		}
+		operation, ok := route.operations[strings.ToLower(r.Method)]
+		if !ok {
+			continue
+		}
+		return validateParameters(r, operation.Parameters)
+	}
+	// not in the definition, so leave it to the router
+	return nil
+}
+
+func validateParameters(r *http.Request, parameters []openapiParameter) error {
+	query := r.URL.Query()
+	for _, p := range parameters {
+		switch p.In {
+		case "query":
+			values, ok := query[p.Name]
+			if !ok {
+				if p.Required {
+					return fmt.Errorf("query parameter %s is required", p.Name)
+				}
+				continue
+			}
+			for _, value := range values {
+				if err := validateValue(p.Type, p.Enum, value); err != nil {
+					return fmt.Errorf("query parameter %s: %w", p.Name, err)
+				}
+			}
+		case "body":
+			if p.Schema == nil || r.Body == nil || !strings.Contains(r.Header.Get("Content-Type"), "json") {
+				continue
+			}
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				return err
+			}
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			if len(bytes.TrimSpace(data)) == 0 {
+				continue
+			}
+			var body interface{}
+			if err := json.Unmarshal(data, &body); err != nil {
+				return fmt.Errorf("body: %w", err)
+			}
+			if err := validateSchema(p.Schema, body, "body"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateValue checks the query parameter, booleans are like "1" or "true"
+func validateValue(typ string, enum []string, value string) error {
+	switch typ {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("expected integer, got %q", value)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil && value != "" {
+			return fmt.Errorf("expected boolean, got %q", value)
+		}
+	}
+	if len(enum) > 0 && !slices.Contains(enum, value) {
+		return fmt.Errorf("expected one of %v, got %q", enum, value)
+	}
+	return nil
+}
+
+// validateSchema checks the types of the value, but allows null and unknown fields (like docker)
+func validateSchema(schema *openapiSchema, value interface{}, where string) error {
+	if value == nil {
+		return nil
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object", where)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: %s is required", where, name)
+			}
+		}
+		for name, v := range object {
+			if s, ok := schema.Properties[name]; ok {
+				if err := validateSchema(s, v, where+"."+name); err != nil {
+					return err
+				}
+			} else if schema.AdditionalProperties != nil {
+				if err := validateSchema(schema.AdditionalProperties, v, where+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array", where)
+		}
+		if schema.Items != nil {
+			for i, v := range array {
+				if err := validateSchema(schema.Items, v, fmt.Sprintf("%s[%d]", where, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string", where)
+		}
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, s) {
+			return fmt.Errorf("%s: expected one of %v, got %q", where, schema.Enum, s)
+		}
+	case "integer":
+		if f, ok := value.(float64); !ok || f != math.Trunc(f) {
+			return fmt.Errorf("%s: expected integer", where)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", where)
+		}
+	}
+	return nil
+}
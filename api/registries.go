@@ -0,0 +1,75 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultRegistry is the registry for the unqualified image names, instead of docker.io
+// (like the podman "unqualified-search-registries", but only one)
+var DefaultRegistry string
+
+// RegistryAliases are the short-name aliases, like "alpine" for "registry.example.com/library/alpine"
+// (like the podman "aliases" of registries.conf)
+var RegistryAliases = map[string]string{}
+
+// ParseRegistryAlias parses an alias, like "alpine=registry.example.com/library/alpine"
+func ParseRegistryAlias(s string) (string, string, error) {
+	name, repo, ok := strings.Cut(s, "=")
+	if !ok || name == "" || repo == "" {
+		return "", "", fmt.Errorf("invalid registry alias: %q (should be name=repository)", s)
+	}
+	if !qualifiedName(repo) {
+		return "", "", fmt.Errorf("invalid registry alias: %q (repository should include the registry)", s)
+	}
+	return name, repo, nil
+}
+
+// qualifiedName checks if the image name includes the registry, like docker does it
+func qualifiedName(name string) bool {
+	domain, _, ok := strings.Cut(name, "/")
+	return ok && (strings.ContainsAny(domain, ".:") || domain == "localhost")
+}
+
+// splitReference splits the image name into repository and the tag or digest (with separator)
+func splitReference(name string) (string, string) {
+	if i := strings.Index(name, "@"); i >= 0 {
+		return name[:i], name[i:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		return name[:i], name[i:]
+	}
+	return name, ""
+}
+
+// resolveShortName returns the name of the image with the alias or the default registry,
+// or the same name when it is qualified (or no registry has been configured)
+func resolveShortName(name string) string {
+	if qualifiedName(name) {
+		return name
+	}
+	repo, tag := splitReference(name)
+	if alias, ok := RegistryAliases[repo]; ok {
+		return alias + tag
+	}
+	if DefaultRegistry != "" {
+		return DefaultRegistry + "/" + name
+	}
+	return name
+}
@@ -0,0 +1,157 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// StateDir is where nerdctld keeps the state that containerd doesn't have (like the recent
+// events and the health results), so that it survives a restart of the daemon.
+// When empty, the state is only kept in memory.
+var StateDir string
+
+// stateSaveDelay is how long to wait for more changes, before writing the state
+const stateSaveDelay = time.Second
+
+// loadState reads the state with the name, into v (a missing state is not an error)
+func loadState(name string, v interface{}) error {
+	if StateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(StateDir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveState writes the state with the name, replacing the previous one atomically
+func saveState(name string, v interface{}) error {
+	if StateDir == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(StateDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(StateDir, name+".json")
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// stateSaver writes the state after it has changed, at most once per stateSaveDelay
+// (the state is taken by get, which must do its own locking)
+type stateSaver struct {
+	name    string
+	get     func() interface{}
+	mu      sync.Mutex
+	pending bool
+	timer   *time.Timer
+	// writing serializes the saves, since they write to the same temporary file
+	writing sync.Mutex
+}
+
+// stateSavers are all of the savers, for flushing them on shutdown
+var stateSavers struct {
+	sync.Mutex
+	list []*stateSaver
+}
+
+func newStateSaver(name string, get func() interface{}) *stateSaver {
+	s := &stateSaver{name: name, get: get}
+	stateSavers.Lock()
+	stateSavers.list = append(stateSavers.list, s)
+	stateSavers.Unlock()
+	return s
+}
+
+func (s *stateSaver) changed() {
+	if StateDir == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending {
+		return
+	}
+	s.pending = true
+	s.timer = time.AfterFunc(stateSaveDelay, s.save)
+}
+
+// save writes the state, where the changes made after it was taken are saved again later
+func (s *stateSaver) save() {
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	s.mu.Lock()
+	s.pending = false
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+	if err := saveState(s.name, s.get()); err != nil {
+		log.Printf("state: %v", err)
+	}
+}
+
+// flush writes the pending changes now, instead of waiting for the timer
+func (s *stateSaver) flush() {
+	s.mu.Lock()
+	pending := s.pending
+	s.mu.Unlock()
+	if pending {
+		s.save()
+	}
+}
+
+// FlushState writes the state that has changed, without waiting (like before exiting)
+func FlushState() {
+	stateSavers.Lock()
+	list := stateSavers.list
+	stateSavers.Unlock()
+	for _, s := range list {
+		s.flush()
+	}
+}
+
+// LoadState restores the state from the previous run of the daemon, from the StateDir
+func LoadState() {
+	for name, load := range map[string]func() error{
+		"events":     loadEvents,
+		"health":     loadHealth,
+		"stopped":    loadStopped,
+		"containers": loadCreated,
+	} {
+		if err := load(); err != nil {
+			log.Printf("state: %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFlushState(t *testing.T) {
+	saved := StateDir
+	StateDir = t.TempDir()
+	defer func() { StateDir = saved }()
+
+	var mu sync.Mutex
+	value := 0
+	s := newStateSaver("test", func() interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return value
+	})
+	path := filepath.Join(StateDir, "test.json")
+
+	mu.Lock()
+	value = 1
+	mu.Unlock()
+	s.changed()
+	// nothing is written until the delay, or a flush
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state written before the delay: %v", err)
+	}
+	FlushState()
+	if data, err := os.ReadFile(path); err != nil || string(data) != "1" {
+		t.Fatalf("state after flush: %q, %v", data, err)
+	}
+
+	// without changes, there is nothing to flush
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	FlushState()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state written without changes: %v", err)
+	}
+}
+
+func TestStateSaverConcurrent(t *testing.T) {
+	saved := StateDir
+	StateDir = t.TempDir()
+	defer func() { StateDir = saved }()
+
+	var mu sync.Mutex
+	value := 0
+	s := newStateSaver("concurrent", func() interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return value
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			value++
+			mu.Unlock()
+			s.changed()
+			// the saves share the temporary file, so they must not overlap
+			s.flush()
+		}()
+	}
+	wg.Wait()
+	FlushState()
+	data, err := os.ReadFile(filepath.Join(StateDir, "concurrent.json"))
+	if err != nil || string(data) != "20" {
+		t.Errorf("state: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/afbjorklund/nerdctld/stream"
+	"github.com/gin-gonic/gin"
+)
+
+type cpuUsage struct {
+	TotalUsage        uint64   `json:"total_usage"`
+	PercpuUsage       []uint64 `json:"percpu_usage,omitempty"`
+	UsageInKernelmode uint64   `json:"usage_in_kernelmode"`
+	UsageInUsermode   uint64   `json:"usage_in_usermode"`
+}
+
+type cpuStats struct {
+	CPUUsage       cpuUsage `json:"cpu_usage"`
+	SystemUsage    uint64   `json:"system_cpu_usage,omitempty"`
+	OnlineCPUs     uint32   `json:"online_cpus,omitempty"`
+	ThrottlingData struct {
+		Periods          uint64 `json:"periods"`
+		ThrottledPeriods uint64 `json:"throttled_periods"`
+		ThrottledTime    uint64 `json:"throttled_time"`
+	} `json:"throttling_data"`
+}
+
+type memoryStats struct {
+	Usage    uint64            `json:"usage,omitempty"`
+	MaxUsage uint64            `json:"max_usage,omitempty"`
+	Stats    map[string]uint64 `json:"stats"`
+	Limit    uint64            `json:"limit,omitempty"`
+}
+
+type blkioStatEntry struct {
+	Major uint64 `json:"major"`
+	Minor uint64 `json:"minor"`
+	Op    string `json:"op"`
+	Value uint64 `json:"value"`
+}
+
+type blkioStats struct {
+	IoServiceBytesRecursive []blkioStatEntry `json:"io_service_bytes_recursive"`
+	IoServicedRecursive     []blkioStatEntry `json:"io_serviced_recursive"`
+}
+
+type networkStats struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+type pidsStats struct {
+	Current uint64 `json:"current,omitempty"`
+	Limit   uint64 `json:"limit,omitempty"`
+}
+
+// containerStats is the docker stats of a container, at one point in time
+type containerStats struct {
+	Read        time.Time               `json:"read"`
+	PreRead     time.Time               `json:"preread"`
+	PidsStats   pidsStats               `json:"pids_stats"`
+	BlkioStats  blkioStats              `json:"blkio_stats"`
+	NumProcs    uint32                  `json:"num_procs"`
+	CPUStats    cpuStats                `json:"cpu_stats"`
+	PreCPUStats cpuStats                `json:"precpu_stats"`
+	MemoryStats memoryStats             `json:"memory_stats"`
+	Name        string                  `json:"name"`
+	ID          string                  `json:"id"`
+	Networks    map[string]networkStats `json:"networks,omitempty"`
+}
+
+// splitUsage splits a "used / total" string, into two sizes
+func splitUsage(s string) (uint64, uint64) {
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return 0, 0
+	}
+	a, _ := backend.ParseSize(parts[0])
+	b, _ := backend.ParseSize(parts[1])
+	return uint64(a), uint64(b)
+}
+
+// statsSampler converts the nerdctl stats samples to docker stats, where cpu
+// usage is accumulated over time and compared to the total system usage.
+type statsSampler struct {
+	ncpu  uint64
+	prev  *containerStats
+	total uint64
+}
+
+func (s *statsSampler) sample(id string, name string, st map[string]interface{}, now time.Time) *containerStats {
+	var stats containerStats
+	stats.Read = now
+	stats.ID = id
+	stats.Name = "/" + name
+
+	perc, _ := st["CPUPerc"].(string)
+	cpu, _ := strconv.ParseFloat(strings.TrimSuffix(perc, "%"), 64)
+	system := uint64(now.UnixNano()) * s.ncpu
+	if s.prev == nil {
+		// make up a previous sample, so that the first sample shows usage
+		pre := &containerStats{Read: now.Add(-time.Second)}
+		pre.CPUStats.SystemUsage = system - uint64(time.Second)*s.ncpu
+		pre.CPUStats.OnlineCPUs = uint32(s.ncpu)
+		s.prev = pre
+	}
+	elapsed := uint64(now.Sub(s.prev.Read))
+	s.total += uint64(cpu / 100 * float64(elapsed))
+	stats.CPUStats.CPUUsage.TotalUsage = s.total
+	// some clients (like ctop) count the cpus from the percpu usage
+	for i := uint64(0); i < s.ncpu; i++ {
+		stats.CPUStats.CPUUsage.PercpuUsage = append(stats.CPUStats.CPUUsage.PercpuUsage, s.total/s.ncpu)
+	}
+	stats.CPUStats.SystemUsage = system
+	stats.CPUStats.OnlineCPUs = uint32(s.ncpu)
+	stats.PreRead = s.prev.Read
+	stats.PreCPUStats = s.prev.CPUStats
+
+	mem, _ := st["MemUsage"].(string)
+	stats.MemoryStats.Usage, stats.MemoryStats.Limit = splitUsage(mem)
+	stats.MemoryStats.Stats = map[string]uint64{}
+
+	if pids, ok := st["PIDs"].(string); ok {
+		stats.PidsStats.Current, _ = strconv.ParseUint(pids, 10, 64)
+	}
+
+	netio, _ := st["NetIO"].(string)
+	rx, tx := splitUsage(netio)
+	stats.Networks = map[string]networkStats{"eth0": {RxBytes: rx, TxBytes: tx}}
+
+	blockio, _ := st["BlockIO"].(string)
+	read, write := splitUsage(blockio)
+	// capitalized like cgroup v1, since some clients (like ctop) only look for those
+	stats.BlkioStats.IoServiceBytesRecursive = []blkioStatEntry{
+		{Op: "Read", Value: read}, {Op: "Write", Value: write}, {Op: "Total", Value: read + write}}
+	stats.BlkioStats.IoServicedRecursive = []blkioStatEntry{}
+
+	s.prev = &stats
+	return &stats
+}
+
+// setDevices replaces the totals from nerdctl with the stats of each network interface and
+// block device, since clients (like cAdvisor and Telegraf) aggregate them by interface and device
+func (stats *containerStats) setDevices(networks map[string]backend.NetworkStats, bytes, serviced []backend.BlkioEntry) {
+	if len(networks) > 0 {
+		stats.Networks = map[string]networkStats{}
+		for name, n := range networks {
+			stats.Networks[name] = networkStats(n)
+		}
+	}
+	if len(bytes) > 0 {
+		stats.BlkioStats.IoServiceBytesRecursive = blkioEntries(bytes)
+		stats.BlkioStats.IoServicedRecursive = blkioEntries(serviced)
+	}
+}
+
+// blkioEntries converts the block io of the devices, to docker stats
+func blkioEntries(entries []backend.BlkioEntry) []blkioStatEntry {
+	result := []blkioStatEntry{}
+	for _, e := range entries {
+		result = append(result, blkioStatEntry{Major: e.Major, Minor: e.Minor, Op: e.Op, Value: e.Value})
+	}
+	return result
+}
+
+// sampleCgroup converts the stats read from the cgroup to docker stats,
+// where the previous sample is empty for the first one (like in docker)
+func (s *statsSampler) sampleCgroup(id string, name string, cg *backend.CgroupStats, now time.Time) *containerStats {
+	var stats containerStats
+	stats.Read = now
+	stats.ID = id
+	stats.Name = "/" + name
+
+	cpu := &stats.CPUStats
+	cpu.CPUUsage.TotalUsage = cg.CPUUsage
+	cpu.CPUUsage.PercpuUsage = cg.PercpuUsage
+	if cpu.CPUUsage.PercpuUsage == nil {
+		// some clients (like ctop) count the cpus from the percpu usage
+		for i := uint32(0); i < cg.OnlineCPUs; i++ {
+			cpu.CPUUsage.PercpuUsage = append(cpu.CPUUsage.PercpuUsage, cg.CPUUsage/uint64(cg.OnlineCPUs))
+		}
+	}
+	cpu.CPUUsage.UsageInUsermode = cg.CPUUser
+	cpu.CPUUsage.UsageInKernelmode = cg.CPUSystem
+	cpu.SystemUsage = cg.SystemUsage
+	cpu.OnlineCPUs = cg.OnlineCPUs
+	cpu.ThrottlingData.Periods = cg.Periods
+	cpu.ThrottlingData.ThrottledPeriods = cg.ThrottledPeriods
+	cpu.ThrottlingData.ThrottledTime = cg.ThrottledTime
+	if s.prev != nil {
+		stats.PreRead = s.prev.Read
+		stats.PreCPUStats = s.prev.CPUStats
+	}
+
+	stats.MemoryStats = memoryStats{Usage: cg.MemoryUsage, MaxUsage: cg.MemoryMaxUsage,
+		Stats: cg.MemoryStats, Limit: cg.MemoryLimit}
+	stats.PidsStats = pidsStats{Current: cg.Pids, Limit: cg.PidsLimit}
+
+	stats.BlkioStats.IoServiceBytesRecursive = blkioEntries(cg.IoServiceBytes)
+	stats.BlkioStats.IoServicedRecursive = blkioEntries(cg.IoServiced)
+
+	stats.Networks = map[string]networkStats{}
+	for name, n := range cg.Networks {
+		stats.Networks[name] = networkStats(n)
+	}
+
+	s.prev = &stats
+	return &stats
+}
+
+func getContainerStats(c *gin.Context) {
+	name := c.Param("name")
+	container, err := backend.Container(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	id, _ := container["Id"].(string)
+	cname, _ := container["Name"].(string)
+	state, _ := container["State"].(map[string]interface{})
+	pid, _ := state["Pid"].(float64)
+	streaming := c.DefaultQuery("stream", "1")
+	oneShot := c.Query("one-shot")
+	sampler := &statsSampler{ncpu: uint64(runtime.NumCPU())}
+	sw := stream.NewWriter(c.Writer)
+	for {
+		var stats *containerStats
+		// read the cgroup directly when possible, instead of running nerdctl every second
+		if cg, err := backend.ReadCgroupStats(int(pid)); err == nil {
+			stats = sampler.sampleCgroup(id, strings.TrimPrefix(cname, "/"), cg, time.Now())
+		} else {
+			st, err := backend.Stats(id)
+			if err != nil {
+				sw.Error(err, errorStatus(err))
+				return
+			}
+			stats = sampler.sample(id, strings.TrimPrefix(cname, "/"), st, time.Now())
+			if networks, bytes, serviced, err := backend.DeviceStats(int(pid)); err == nil {
+				stats.setDevices(networks, bytes, serviced)
+			}
+		}
+		if oneShot == "1" || oneShot == "true" {
+			stats.PreCPUStats = cpuStats{}
+			stats.PreRead = time.Time{}
+		}
+		if err := sw.WriteJSON(stats); err != nil {
+			return
+		}
+		if streaming == "0" || streaming == "false" {
+			return
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,292 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+)
+
+// the restart delay doubles for every restart, like in docker
+const (
+	minRestartDelay = 100 * time.Millisecond
+	maxRestartDelay = time.Minute
+	// a container that ran for this long is restarted without delay
+	restartResetTime = 10 * time.Second
+)
+
+// restartState is the restarts of a container, by the supervisor
+type restartState struct {
+	count   int
+	delay   time.Duration
+	timer   *time.Timer
+	pending bool
+}
+
+// supervisor restarts the containers that exit, according to their restart policy,
+// for when containerd doesn't (without the restart monitor plugin)
+var supervisor = struct {
+	sync.Mutex
+	enabled  bool
+	stopped  map[string]bool
+	restarts map[string]*restartState
+}{stopped: map[string]bool{}, restarts: map[string]*restartState{}}
+
+var stoppedSaver = newStateSaver("stopped", func() interface{} {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	stopped := []string{}
+	for id := range supervisor.stopped {
+		stopped = append(stopped, id)
+	}
+	sort.Strings(stopped)
+	return stopped
+})
+
+// loadStopped restores the containers that were stopped by the user, so that they
+// are not started again after a restart
+func loadStopped() error {
+	var stopped []string
+	if err := loadState("stopped", &stopped); err != nil {
+		return err
+	}
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	for _, id := range stopped {
+		supervisor.stopped[id] = true
+	}
+	return nil
+}
+
+// restartPolicy returns the policy of the container, and the maximum retry count
+func restartPolicy(container map[string]interface{}) (string, int) {
+	config, _ := container["Config"].(map[string]interface{})
+	labels := stringMap(config["Labels"])
+	policy := labels["containerd.io/restart.policy"]
+	if policy == "" {
+		return "no", 0
+	}
+	name, count, _ := strings.Cut(policy, ":")
+	max, _ := strconv.Atoi(count)
+	return name, max
+}
+
+// markStopped remembers that the container was stopped by the user, so it is not restarted,
+// and returns whether it was marked before (to undo it, if the stop fails)
+func markStopped(id string, stopped bool) bool {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	if !supervisor.enabled {
+		return false
+	}
+	was := supervisor.stopped[id]
+	if stopped {
+		supervisor.stopped[id] = true
+		if r := supervisor.restarts[id]; r != nil && r.timer != nil {
+			r.timer.Stop()
+			r.pending = false
+		}
+	} else {
+		delete(supervisor.stopped, id)
+	}
+	stoppedSaver.changed()
+	return was
+}
+
+// restarting checks if the container is waiting to be restarted, by the supervisor
+func restarting(id string) bool {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	r := supervisor.restarts[id]
+	return r != nil && r.pending
+}
+
+// StartSupervisor watches the container exits, and restarts them according to the policy.
+// On startup, the containers with "always" (and "unless-stopped") are started again.
+// It runs until the context is done.
+func StartSupervisor(ctx context.Context) {
+	supervisor.Lock()
+	supervisor.enabled = true
+	supervisor.Unlock()
+	ch := events.subscribe()
+	go func() {
+		defer func() {
+			events.unsubscribe(ch)
+			supervisor.Lock()
+			supervisor.enabled = false
+			supervisor.Unlock()
+		}()
+		// nerdctl (or containerd) might not be available yet, with socket activation
+		var errs loopError
+		for {
+			err := backend.Available()
+			if err == nil {
+				err = startRestartable()
+			}
+			errs.log("supervisor", err)
+			if err == nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-ch:
+				if ev.Type == "container" && ev.Action == "die" {
+					exitCode, _ := strconv.Atoi(ev.Actor.Attributes["exitCode"])
+					supervise(ev.Actor.ID, exitCode)
+				}
+			}
+		}
+	}()
+}
+
+// supervise schedules a restart of the container that exited, if the policy says so
+func supervise(id string, exitCode int) {
+	container, err := backend.Container(id)
+	if err != nil {
+		return
+	}
+	policy, max := restartPolicy(container)
+	state, _ := container["State"].(map[string]interface{})
+	startedAt, _ := state["StartedAt"].(string)
+	started, _ := time.Parse(time.RFC3339Nano, startedAt)
+
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	if supervisor.stopped[id] {
+		return
+	}
+	r := supervisor.restarts[id]
+	if r == nil || time.Since(started) >= restartResetTime {
+		r = &restartState{}
+		supervisor.restarts[id] = r
+	}
+	switch policy {
+	case "always", "unless-stopped":
+	case "on-failure":
+		if exitCode == 0 || (max > 0 && r.count >= max) {
+			return
+		}
+	default:
+		return
+	}
+	if r.delay == 0 {
+		r.delay = minRestartDelay
+	} else if r.delay *= 2; r.delay > maxRestartDelay {
+		r.delay = maxRestartDelay
+	}
+	r.count++
+	count := r.count
+	r.pending = true
+	r.timer = time.AfterFunc(r.delay, func() {
+		supervisor.Lock()
+		stopped := supervisor.stopped[id]
+		r.pending = false
+		supervisor.Unlock()
+		if _, status, err := containerState(id); err != nil || stopped || status == "running" {
+			return
+		}
+		log.Printf("supervisor: restarting %s (%s, %d)", id, policy, count)
+		if err := backend.StartContainer(id); err != nil {
+			log.Printf("supervisor: %v", err)
+		}
+	})
+}
+
+// startRestartable starts the containers that were stopped by a daemon restart,
+// or returns an error if they could not be listed
+func startRestartable() error {
+	containers, err := backend.Containers(true)
+	if err != nil {
+		return err
+	}
+	forgetStopped(containers)
+	for _, container := range containers {
+		id, _ := container["ID"].(string)
+		if stoppedByUser(id) {
+			continue
+		}
+		inspect, err := backend.Container(id)
+		if err != nil {
+			continue
+		}
+		state, _ := inspect["State"].(map[string]interface{})
+		if running, _ := state["Running"].(bool); running {
+			continue
+		}
+		config, _ := inspect["Config"].(map[string]interface{})
+		labels := stringMap(config["Labels"])
+		switch policy, _ := restartPolicy(inspect); policy {
+		case "always":
+		case "unless-stopped":
+			if labels["containerd.io/restart.explicitly-stopped"] == "true" {
+				continue
+			}
+		default:
+			continue
+		}
+		log.Printf("supervisor: starting %s", id)
+		if err := backend.StartContainer(id); err != nil {
+			log.Printf("supervisor: %v", err)
+		}
+	}
+	return nil
+}
+
+// stoppedByUser checks if the container (by short ID) was stopped by the user
+func stoppedByUser(id string) bool {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	for stopped := range supervisor.stopped {
+		if strings.HasPrefix(stopped, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetStopped removes the containers that no longer exist, from the stopped ones
+func forgetStopped(containers []map[string]interface{}) {
+	supervisor.Lock()
+	defer supervisor.Unlock()
+	for stopped := range supervisor.stopped {
+		exists := false
+		for _, container := range containers {
+			if id, _ := container["ID"].(string); id != "" && strings.HasPrefix(stopped, id) {
+				exists = true
+			}
+		}
+		if !exists {
+			delete(supervisor.stopped, stopped)
+		}
+	}
+	stoppedSaver.changed()
+}
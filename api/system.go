@@ -0,0 +1,505 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+type Commit struct {
+	ID       string
+	Expected string `json:",omitempty"` // deprecated in 1.45
+}
+
+func getCommit(version string, details map[string]string) Commit {
+	commit := details["GitCommit"]
+	return Commit{ID: commit, Expected: commit}
+}
+
+type Platform struct {
+	Name string
+}
+
+func nerdctlPlatform() Platform {
+	return Platform{Name: "\U0001f913"}
+}
+
+func headPing(c *gin.Context) {
+	c.Writer.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.Header().Add("Pragma", "no-cache")
+	c.Writer.Header().Set("API-Version", CurrentAPIVersion)
+	c.Writer.Header().Set("Docker-Experimental", "true")
+	c.Writer.Header().Set("OSType", "linux")
+	c.Writer.Header().Set("Content-Length", "0")
+	c.Status(http.StatusOK)
+}
+
+func getPing(c *gin.Context) {
+	c.Writer.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Writer.Header().Add("Pragma", "no-cache")
+	c.Writer.Header().Set("API-Version", CurrentAPIVersion)
+	c.Writer.Header().Set("Docker-Experimental", "true")
+	c.Writer.Header().Set("OSType", "linux")
+	c.Writer.Header().Set("Content-Type", "text/plain")
+	c.String(http.StatusOK, "OK")
+}
+
+// getHealthz checks that nerdctl can be run, and that it can reach containerd
+func getHealthz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthzTimeout)
+	defer cancel()
+	if err := backend.Reachable(ctx); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "text/plain")
+	c.String(http.StatusOK, "OK")
+}
+
+// healthzTimeout is how long to wait for containerd, before it is unhealthy
+const healthzTimeout = 5 * time.Second
+
+func getVersion(c *gin.Context) {
+	apiver := c.Param("ver")
+	var ver struct {
+		Platform   struct{ Name string }      `json:",omitempty"`
+		Components []backend.ComponentVersion `json:",omitempty"`
+
+		Version       string
+		APIVersion    string `json:"ApiVersion"`
+		MinAPIVersion string `json:"MinAPIVersion,omitempty"`
+		GitCommit     string
+		GoVersion     string
+		Os            string
+		Arch          string
+		KernelVersion string `json:",omitempty"`
+		Experimental  bool   `json:",omitempty"`
+		BuildTime     string `json:",omitempty"`
+	}
+	version, err := backend.Version()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client := version["Client"].(map[string]interface{})
+	ver.Version, _ = backend.NerdctlVersion()
+	ver.APIVersion = CurrentAPIVersion
+	ver.MinAPIVersion = MinimumAPIVersion
+	ver.GitCommit = client["GitCommit"].(string)
+	ver.GoVersion = client["GoVersion"].(string)
+	ver.Os = client["Os"].(string)
+	ver.Arch = client["Arch"].(string)
+	ver.Experimental = true
+	if CompareVersions(apiver, "v1.35") > 0 {
+		ver.Platform = nerdctlPlatform()
+		if len(backend.RemoteCommand) == 0 {
+			ver.Components = backend.Components()
+		} else {
+			ver.Components = backend.RemoteComponents()
+		}
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, ver)
+}
+
+// postAuth checks the credentials, like "docker login". They are not stored,
+// since the client sends them with every pull and push anyway.
+func postAuth(c *gin.Context) {
+	var auth backend.AuthConfig
+	if !bindJSON(c, &auth) {
+		return
+	}
+	if err := backend.Login(&auth); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	c.JSON(http.StatusOK, map[string]string{"Status": "Login Succeeded", "IdentityToken": ""})
+}
+
+// cniDrivers are the network drivers of nerdctl, which are named like their CNI plugins
+var cniDrivers = []string{"bridge", "macvlan", "ipvlan"}
+
+// bridgePlugins are the other CNI plugins, that nerdctl uses for bridge networks
+var bridgePlugins = []string{"portmap", "firewall", "tuning"}
+
+// EngineName is the name of the engine in info, instead of the hostname
+// (so that the endpoints can be told apart, like with docker context ls)
+var EngineName string
+
+// EngineLabels are the labels of the engine in info, like "role=ci"
+var EngineLabels = []string{}
+
+// ParseEngineLabel checks that the label is key=value, like dockerd --label
+func ParseEngineLabel(s string) (string, error) {
+	if key, _, ok := strings.Cut(s, "="); !ok || key == "" {
+		return "", fmt.Errorf("invalid label: %q (should be key=value)", s)
+	}
+	return s, nil
+}
+
+func getInfo(c *gin.Context) {
+	type runtime struct {
+		Path string   `json:"path"`
+		Args []string `json:"runtimeArgs,omitempty"`
+	}
+	type swarm struct {
+		LocalNodeState string
+	}
+	var inf struct {
+		ID                 string
+		Containers         int
+		ContainersRunning  int
+		ContainersPaused   int
+		ContainersStopped  int
+		Images             int
+		Driver             string
+		DriverStatus       [][2]string
+		SystemStatus       [][2]string
+		Plugins            map[string]interface{}
+		MemoryLimit        bool
+		SwapLimit          bool
+		KernelMemory       bool
+		CPUCfsPeriod       bool `json:"CpuCfsPeriod"`
+		CPUCfsQuota        bool `json:"CpuCfsQuota"`
+		CPUShares          bool
+		CPUSet             bool
+		IPv4Forwarding     bool
+		BridgeNfIptables   bool
+		BridgeNfIP6tables  bool `json:"BridgeNfIp6tables"`
+		Debug              bool
+		NFd                int
+		OomKillDisable     bool
+		NGoroutines        int
+		SystemTime         string
+		ExecutionDriver    string
+		LoggingDriver      string
+		CgroupDriver       string
+		CgroupVersion      string `json:",omitempty"`
+		NEventsListener    int
+		KernelVersion      string
+		OperatingSystem    string
+		OSType             string
+		Architecture       string
+		IndexServerAddress string
+		//RegistryConfig     *registry.ServiceConfig
+		NCPU              int
+		MemTotal          int64
+		DockerRootDir     string
+		HTTPProxy         string `json:"HttpProxy"`
+		HTTPSProxy        string `json:"HttpsProxy"`
+		NoProxy           string
+		Name              string
+		Labels            []string
+		ExperimentalBuild bool
+		ServerVersion     string
+		ClusterStore      string
+		ClusterAdvertise  string
+		SecurityOptions   []string
+		Runtimes          map[string]runtime
+		DefaultRuntime    string
+		Swarm             swarm
+		// LiveRestoreEnabled determines whether containers should be kept
+		// running when the daemon is shutdown or upon daemon start if
+		// running containers are detected
+		LiveRestoreEnabled bool
+		InitBinary         string
+		ContainerdCommit   Commit
+		RuncCommit         Commit
+		InitCommit         Commit
+		Warnings           []string
+	}
+	info, err := backend.Info()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	inf.ID = info["ID"].(string)
+	containers, err := backend.Containers(true)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	images, err := backend.Images()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	inf.Containers = len(containers)
+	inf.ContainersRunning = lenStatus(containers, "Running")
+	inf.ContainersPaused = lenStatus(containers, "Paused")
+	inf.ContainersStopped = lenStatus(containers, "Stopped")
+	inf.Images = len(images)
+	inf.Name = info["Name"].(string)
+	if EngineName != "" {
+		inf.Name = EngineName
+	}
+	inf.Labels = EngineLabels
+	inf.ServerVersion, _ = backend.NerdctlVersion()
+	inf.NCPU = int(info["NCPU"].(float64))
+	inf.MemTotal = int64(info["MemTotal"].(float64))
+	inf.Driver = backend.Snapshotter(info["Driver"].(string))
+	inf.DriverStatus = backend.DriverStatus(inf.Driver)
+	inf.MemoryLimit = info["MemoryLimit"].(bool)
+	inf.SwapLimit = info["SwapLimit"].(bool)
+	inf.OomKillDisable = info["OomKillDisable"].(bool)
+	inf.CPUCfsPeriod = info["CpuCfsPeriod"].(bool)
+	inf.CPUCfsQuota = info["CpuCfsQuota"].(bool)
+	inf.CPUShares = info["CPUShares"].(bool)
+	inf.CPUSet = info["CPUSet"].(bool)
+	inf.IPv4Forwarding = info["IPv4Forwarding"].(bool)
+	inf.BridgeNfIptables = info["BridgeNfIptables"].(bool)
+	inf.BridgeNfIP6tables = info["BridgeNfIp6tables"].(bool)
+	inf.LoggingDriver = info["LoggingDriver"].(string)
+	inf.CgroupDriver = info["CgroupDriver"].(string)
+	inf.CgroupVersion = info["CgroupVersion"].(string)
+	inf.KernelVersion = info["KernelVersion"].(string)
+	inf.OperatingSystem = info["OperatingSystem"].(string)
+	inf.OSType = info["OSType"].(string)
+	inf.Architecture = info["Architecture"].(string)
+	inf.ExperimentalBuild = true
+	inf.DefaultRuntime = "runc"
+	inf.Runtimes = map[string]runtime{"runc": {Path: "runc"}}
+	inf.Swarm = swarm{LocalNodeState: "inactive"}
+	inf.IndexServerAddress = "https://index.docker.io/v1/"
+	inf.HTTPProxy = redactProxy(daemonProxy("HTTP_PROXY"))
+	inf.HTTPSProxy = redactProxy(daemonProxy("HTTPS_PROXY"))
+	inf.NoProxy = daemonProxy("NO_PROXY")
+	inf.Warnings = []string{}
+	inf.ContainerdCommit = getCommit(backend.ContainerdVersion())
+	inf.RuncCommit = getCommit(backend.RuncVersion())
+	// tini is optional, and only needed for --init
+	if version, details := backend.TiniVersion(); version != "" {
+		inf.InitBinary = "tini"
+		inf.InitCommit = getCommit(version, details)
+	}
+	if CompareVersions(apiVersion(c), "1.45") >= 0 {
+		inf.ContainerdCommit.Expected = ""
+		inf.RuncCommit.Expected = ""
+		inf.InitCommit.Expected = ""
+	}
+	inf.SecurityOptions = stringArray(info["SecurityOptions"].([]interface{}))
+	if backend.Rootless() {
+		// clients change behavior based on these, like dockerd in rootless mode
+		if !slices.Contains(inf.SecurityOptions, "name=rootless") {
+			inf.SecurityOptions = append(inf.SecurityOptions, "name=rootless")
+		}
+		if inf.CgroupVersion == "1" {
+			inf.CgroupDriver = "none"
+			inf.Warnings = append(inf.Warnings, "WARNING: Running in rootless-mode without cgroups. "+
+				"To enable cgroups in rootless-mode, you need to boot the system in cgroup v2 mode.")
+		}
+		if port, err := backend.UnprivilegedPortStart(); err == nil && port > 0 {
+			inf.Warnings = append(inf.Warnings, fmt.Sprintf("WARNING: Running in rootless-mode, "+
+				"ports below %d cannot be published (net.ipv4.ip_unprivileged_port_start).", port))
+		}
+	}
+	inf.Plugins = info["Plugins"].(map[string]interface{})
+	inf.Plugins["Volume"] = []string{"local"}
+	networks := []string{"null", "host"}
+	if plugins, err := backend.CNIPlugins(); err == nil && len(plugins) > 0 {
+		// only the drivers that are installed, so that network create doesn't fail later
+		for _, driver := range cniDrivers {
+			if slices.Contains(plugins, driver) {
+				networks = append(networks, driver)
+			}
+		}
+		if slices.Contains(networks, "bridge") {
+			for _, plugin := range bridgePlugins {
+				if !slices.Contains(plugins, plugin) {
+					inf.Warnings = append(inf.Warnings, fmt.Sprintf("WARNING: The %s CNI plugin is not installed, "+
+						"which is needed for bridge networks.", plugin))
+				}
+			}
+		}
+	} else {
+		networks = append(networks, cniDrivers...)
+	}
+	inf.Plugins["Network"] = networks
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, inf)
+}
+
+func getDiskUsage(c *gin.Context) {
+	type image struct {
+		ID   string `json:"Id"`
+		Size int64
+	}
+	type container struct {
+		ID         string `json:"Id"`
+		SizeRw     int64  `json:",omitempty"`
+		SizeRootFs int64  `json:",omitempty"`
+	}
+	type ud struct {
+		RefCount int64
+		Size     int64
+	}
+	type volume struct {
+		CreatedAt string `json:",omitempty"`
+		Name      string
+		UsageData *ud
+	}
+	type buildcache struct {
+		ID     string
+		Type   string
+		Shared bool
+		Size   int64
+	}
+	type DiskUsage struct {
+		LayersSize  int64
+		Images      []interface{} // *ImageSummary
+		Containers  []interface{} // *Container
+		Volumes     []interface{} // *volume.Volume
+		BuildCache  []interface{} // *BuildCache
+		BuilderSize int64
+	}
+	// only the requested types are collected (API 1.42), or all of them
+	types := map[string]bool{}
+	for _, t := range c.QueryArray("type") {
+		switch t {
+		case "container", "image", "volume", "build-cache":
+			types[t] = true
+		default:
+			httpError(c.Writer, fmt.Sprintf("invalid type: %s", t), http.StatusBadRequest)
+			return
+		}
+	}
+	want := func(t string) bool {
+		return len(types) == 0 || types[t]
+	}
+	var du DiskUsage
+	if want("image") {
+		images, err := backend.Images()
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		du.Images = make([]interface{}, 0)
+		for _, i := range images {
+			du.Images = append(du.Images, &image{ID: i["ID"].(string), Size: 0})
+		}
+	}
+	if want("container") {
+		containers, err := backend.Containers(true)
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		du.Containers = make([]interface{}, 0)
+		for _, c := range containers {
+			du.Containers = append(du.Containers, &container{ID: c["ID"].(string), SizeRw: 0, SizeRootFs: 0})
+		}
+	}
+	if want("volume") {
+		volumes, err := backend.Volumes(nil)
+		if err != nil {
+			httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		du.Volumes = make([]interface{}, 0)
+		for _, v := range volumes {
+			du.Volumes = append(du.Volumes, &volume{Name: v["Name"].(string), UsageData: &ud{RefCount: -1, Size: 0}})
+		}
+	}
+	if want("build-cache") {
+		du.BuildCache = make([]interface{}, 0)
+		for _, r := range backend.BuildCache() {
+			du.BuildCache = append(du.BuildCache, &buildcache{ID: r["ID"].(string), Type: r["Type"].(string), Shared: r["Shared"].(bool), Size: 0})
+		}
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, du)
+}
+
+// pruneSystem prunes the stopped containers, the unused networks, the dangling images (with all,
+// the unused images) and the build cache, and with volumes also the unused volumes, like
+// "docker system prune". All the steps are run, and the failed ones are in the Errors.
+func pruneSystem(c *gin.Context) {
+	all := c.Query("all") == "1" || c.Query("all") == "true"
+	volumes := c.Query("volumes") == "1" || c.Query("volumes") == "true"
+	var sp struct {
+		Message           string `json:"message,omitempty"`
+		ContainersDeleted []string
+		NetworksDeleted   []string
+		VolumesDeleted    []string
+		ImagesDeleted     []map[string]string
+		CachesDeleted     []string
+		SpaceReclaimed    int64
+		Errors            []string
+	}
+	sp.ImagesDeleted = []map[string]string{}
+	sp.CachesDeleted = []string{}
+	sp.Errors = []string{}
+	failed := func(step string, err error) {
+		logRequest(c, "system prune: %s: %v", step, err)
+		sp.Errors = append(sp.Errors, fmt.Sprintf("%s: %v", step, err))
+	}
+	var err error
+	if sp.ContainersDeleted, err = backend.PruneContainers(); err != nil {
+		failed("containers", err)
+	}
+	for _, id := range sp.ContainersDeleted {
+		takeCreated(id)
+	}
+	if sp.NetworksDeleted, err = backend.PruneNetworks(); err != nil {
+		failed("networks", err)
+	}
+	if volumes {
+		names, _ := pruneCandidates(nil)
+		sizes := backend.VolumeSizes(names)
+		if sp.VolumesDeleted, err = backend.PruneVolumes(false); err != nil {
+			failed("volumes", err)
+		}
+		sp.SpaceReclaimed += volumesDestroyed(sp.VolumesDeleted, sizes)
+	}
+	if deleted, err := backend.PruneImages(all); err != nil {
+		failed("images", err)
+	} else {
+		for _, name := range deleted {
+			sp.ImagesDeleted = append(sp.ImagesDeleted, map[string]string{"Deleted": name})
+		}
+	}
+	// builds are optional, so skip the build cache without buildkit
+	if backend.BuildWorker() != "" {
+		cache := backend.BuildCache()
+		if space, err := backend.BuildPrune(); err != nil {
+			failed("build cache", err)
+		} else {
+			sp.CachesDeleted = prunableCaches(cache)
+			sp.SpaceReclaimed += space
+		}
+	}
+	for _, list := range []*[]string{&sp.ContainersDeleted, &sp.NetworksDeleted, &sp.VolumesDeleted} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	status := http.StatusOK
+	if len(sp.Errors) > 0 {
+		sp.Message = strings.Join(sp.Errors, "; ")
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, sp)
+}
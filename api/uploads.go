@@ -0,0 +1,201 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// uploadOffsetHeader is the size uploaded so far, to resume from (like in the tus protocol)
+const uploadOffsetHeader = "Upload-Offset"
+
+// reUploadID is the ID of an upload, which is also the name of the file
+var reUploadID = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// uploads are spooled to disk, in chunks, so that large images can be uploaded and resumed.
+// The busy ones are being written (or loaded), by one request at a time.
+var uploads = struct {
+	sync.Mutex
+	busy map[string]bool
+}{busy: map[string]bool{}}
+
+// uploadTTL is how long an upload is kept without any chunks, before it is abandoned
+const uploadTTL = 24 * time.Hour
+
+// lockUpload marks the upload as busy, or returns an error if it already is
+func lockUpload(id string) (func(), error) {
+	uploads.Lock()
+	defer uploads.Unlock()
+	if uploads.busy[id] {
+		return nil, fmt.Errorf("upload %s is in use", id)
+	}
+	uploads.busy[id] = true
+	return func() {
+		uploads.Lock()
+		delete(uploads.busy, id)
+		uploads.Unlock()
+	}, nil
+}
+
+// expireUploads removes the uploads that have not been written to for the TTL
+func expireUploads() {
+	entries, err := os.ReadDir(uploadDir())
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil || !reUploadID.MatchString(entry.Name()) || time.Since(fi.ModTime()) < uploadTTL {
+			continue
+		}
+		unlock, err := lockUpload(entry.Name())
+		if err != nil {
+			continue
+		}
+		log.Printf("uploads: removing the abandoned upload %s", entry.Name())
+		os.Remove(filepath.Join(uploadDir(), entry.Name()))
+		unlock()
+	}
+}
+
+func uploadDir() string {
+	return filepath.Join(os.TempDir(), "nerdctld-uploads")
+}
+
+// uploadPath returns the file of the upload, or an error if it doesn't exist
+func uploadPath(id string) (string, error) {
+	if !reUploadID.MatchString(id) {
+		return "", fmt.Errorf("No such upload: %s", id)
+	}
+	path := filepath.Join(uploadDir(), id)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("No such upload: %s", id)
+	}
+	return path, nil
+}
+
+func createUpload(c *gin.Context) {
+	expireUploads()
+	if err := os.MkdirAll(uploadDir(), 0700); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := randomID()
+	if err := os.WriteFile(filepath.Join(uploadDir(), id), nil, 0600); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Header(uploadOffsetHeader, "0")
+	c.JSON(http.StatusCreated, map[string]interface{}{"Id": id, "Size": 0})
+}
+
+func getUpload(c *gin.Context) {
+	path, err := uploadPath(c.Param("id"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Header(uploadOffsetHeader, strconv.FormatInt(fi.Size(), 10))
+	c.JSON(http.StatusOK, map[string]interface{}{"Id": c.Param("id"), "Size": fi.Size()})
+}
+
+// appendUpload writes the chunk at the offset, which has to be the size so far
+func appendUpload(c *gin.Context) {
+	path, err := uploadPath(c.Param("id"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	offset := c.Request.Header.Get(uploadOffsetHeader)
+	if offset == "" {
+		offset = c.DefaultQuery("offset", "0")
+	}
+	off, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unlock, err := lockUpload(c.Param("id"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusConflict)
+		return
+	}
+	defer unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if off != fi.Size() {
+		c.Header(uploadOffsetHeader, strconv.FormatInt(fi.Size(), 10))
+		httpError(c.Writer, fmt.Sprintf("offset %d does not match the size %d", off, fi.Size()), http.StatusConflict)
+		return
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, c.Request.Body)
+	// keep what was written, so the upload can be resumed from there
+	c.Header(uploadOffsetHeader, strconv.FormatInt(off+n, 10))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func removeUpload(c *gin.Context) {
+	path, err := uploadPath(c.Param("id"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	unlock, err := lockUpload(c.Param("id"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusConflict)
+		return
+	}
+	defer unlock()
+	if err := os.Remove(path); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockUpload(t *testing.T) {
+	id := randomID()
+	unlock, err := lockUpload(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockUpload(id); err == nil {
+		t.Error("expected an error for a busy upload")
+	}
+	// other uploads are not blocked
+	other, err := lockUpload(randomID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+	unlock()
+	if unlock, err = lockUpload(id); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestExpireUploads(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	if err := os.MkdirAll(uploadDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	old, recent, busy := randomID(), randomID(), randomID()
+	for _, id := range []string{old, recent, busy} {
+		if err := os.WriteFile(filepath.Join(uploadDir(), id), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-uploadTTL - time.Hour)
+	for _, id := range []string{old, busy} {
+		if err := os.Chtimes(filepath.Join(uploadDir(), id), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	unlock, err := lockUpload(busy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	expireUploads()
+	if _, err := uploadPath(old); err == nil {
+		t.Error("the abandoned upload was not removed")
+	}
+	if _, err := uploadPath(recent); err != nil {
+		t.Errorf("recent upload: %v", err)
+	}
+	if _, err := uploadPath(busy); err != nil {
+		t.Errorf("busy upload: %v", err)
+	}
+}
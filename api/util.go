@@ -0,0 +1,225 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+	"github.com/tj/go-naturaldate"
+)
+
+// unixTime parses the time of nerdctl, like "2006-01-02 15:04:05 -0700 MST"
+func unixTime(s string) (int64, error) {
+	i, err := time.Parse("2006-01-02T15:04:05Z", s)
+	if err == nil {
+		return i.Unix(), nil
+	}
+	t, err := time.Parse("2006-01-02 15:04:05 -0700 MST", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// unixNatural parses the relative time of nerdctl, like "2 hours ago"
+func unixNatural(s string) (int64, error) {
+	t, err := naturaldate.Parse(s, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// tempDirs are the temporary directories in use, to be removed on shutdown
+var tempDirs = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: map[string]bool{}}
+
+// sharedTempDir creates a temporary directory, that is also available to nerdctl
+// (which runs inside the lima or colima virtual machine, when not on linux).
+// It should be removed with removeTempDir, when done.
+func sharedTempDir(pattern string) (string, error) {
+	dir, err := os.MkdirTemp(backend.SharedDir, "nerdctld-"+pattern)
+	if err != nil {
+		return "", err
+	}
+	tempDirs.Lock()
+	tempDirs.m[dir] = true
+	tempDirs.Unlock()
+	return dir, nil
+}
+
+// removeTempDir removes the temporary directory, and everything in it
+func removeTempDir(dir string) {
+	tempDirs.Lock()
+	delete(tempDirs.m, dir)
+	tempDirs.Unlock()
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("remove %s: %v", dir, err)
+	}
+}
+
+// RemoveTempDirs removes the temporary directories still in use, on shutdown
+func RemoveTempDirs() {
+	tempDirs.Lock()
+	dirs := []string{}
+	for dir := range tempDirs.m {
+		dirs = append(dirs, dir)
+	}
+	tempDirs.Unlock()
+	for _, dir := range dirs {
+		removeTempDir(dir)
+	}
+}
+
+// reStaleTempDir matches the temporary directories of sharedTempDir (and of the auth),
+// but not the uploads, which are to be kept across restarts
+var reStaleTempDir = regexp.MustCompile(`^nerdctld-(build|archive|auth)[0-9]+$`)
+
+// staleTempDirAge is how old a temporary directory has to be, to be swept
+// (so that those of another nerdctld, of the same user, are left alone)
+const staleTempDirAge = time.Hour
+
+// SweepTempDirs removes the temporary directories left behind (like after a crash),
+// that are owned by this user, on startup
+func SweepTempDirs() {
+	dir := backend.SharedDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !reStaleTempDir.MatchString(entry.Name()) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil || time.Since(fi.ModTime()) < staleTempDirAge || !ownedFile(fi) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		log.Printf("removing the stale %s", path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("remove %s: %v", path, err)
+		}
+	}
+}
+
+// ownedFile checks that the file is owned by this user
+func ownedFile(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return !ok || int(st.Uid) == os.Geteuid()
+}
+
+func stringArray(options []interface{}) []string {
+	result := []string{}
+	for _, option := range options {
+		result = append(result, option.(string))
+	}
+	return result
+}
+
+// httpError replies with the error message as JSON, like docker: {"message": "..."}
+// (with the ID of the request added, for finding it in the logs)
+func httpError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	body := map[string]string{"message": message}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// bindJSON decodes the request body into v, allowing it to be empty.
+// It returns false (after sending 400 Bad Request), if decoding failed.
+func bindJSON(c *gin.Context, v interface{}) bool {
+	if c.Request.Body == nil || c.Request.ContentLength == 0 {
+		return true
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(v); err != nil && err != io.EOF {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// registryAuth decodes the X-Registry-Auth header, returning nil if there is none
+func registryAuth(c *gin.Context) *backend.AuthConfig {
+	header := c.GetHeader("X-Registry-Auth")
+	if header == "" {
+		return nil
+	}
+	// base64url, but some clients send it with padding and some without
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(header, "="))
+	if err != nil {
+		logRequest(c, "X-Registry-Auth: %v", err)
+		return nil
+	}
+	var auth backend.AuthConfig
+	if err := json.Unmarshal(data, &auth); err != nil {
+		logRequest(c, "X-Registry-Auth: %v", err)
+		return nil
+	}
+	if auth.Username == "" && auth.Auth == "" && auth.IdentityToken == "" {
+		return nil
+	}
+	return &auth
+}
+
+// loopError logs the errors of a background loop, but only when the error changes,
+// so that a loop running every second doesn't flood the log while nerdctl is down
+type loopError struct {
+	mu   sync.Mutex
+	last string
+}
+
+func (l *loopError) log(prefix string, err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if msg == l.last {
+		return
+	}
+	if err != nil {
+		log.Printf("%s: %v", prefix, err)
+	} else if l.last != "" {
+		log.Printf("%s: recovered", prefix)
+	}
+	l.last = msg
+}
@@ -0,0 +1,61 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+)
+
+func TestSweepTempDirs(t *testing.T) {
+	dir := t.TempDir()
+	saved := backend.SharedDir
+	backend.SharedDir = dir
+	defer func() { backend.SharedDir = saved }()
+
+	past := time.Now().Add(-2 * staleTempDirAge)
+	for _, name := range []string{"nerdctld-build123", "nerdctld-auth456", "nerdctld-archive789", "nerdctld-uploads", "other-build1", "nerdctld-build999"} {
+		path := filepath.Join(dir, name)
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if name != "nerdctld-build999" {
+			if err := os.Chtimes(path, past, past); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	SweepTempDirs()
+	for name, kept := range map[string]bool{
+		"nerdctld-build123":   false,
+		"nerdctld-auth456":    false,
+		"nerdctld-archive789": false,
+		"nerdctld-uploads":    true,
+		"other-build1":        true,
+		"nerdctld-build999":   true, // recent, maybe of another nerdctld
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("%s: exists %v, want %v", name, exists, kept)
+		}
+	}
+}
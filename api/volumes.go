@@ -0,0 +1,290 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+)
+
+// volumeOptionsLabel is the label with the driver options of the volume, as JSON,
+// since nerdctl doesn't keep them
+const volumeOptionsLabel = "nerdctld/volume-options"
+
+type volumeUsage struct {
+	RefCount int64 `json:"RefCount"`
+	Size     int64 `json:"Size"`
+}
+
+// dockerVolume is a volume, with the fields of docker
+type dockerVolume struct {
+	CreatedAt  string `json:",omitempty"`
+	Driver     string
+	Labels     map[string]string
+	Mountpoint string
+	Name       string
+	Options    map[string]string
+	Scope      string
+	Status     map[string]interface{} `json:",omitempty"`
+	UsageData  *volumeUsage           `json:",omitempty"`
+}
+
+// convertVolume converts a volume of nerdctl (from inspect or ls), to a docker volume
+func convertVolume(volume map[string]interface{}) dockerVolume {
+	vol := dockerVolume{Driver: "local", Scope: "local", Options: map[string]string{}}
+	vol.Name, _ = volume["Name"].(string)
+	vol.Mountpoint, _ = volume["Mountpoint"].(string)
+	if driver, _ := volume["Driver"].(string); driver != "" {
+		vol.Driver = driver
+	}
+	if scope, _ := volume["Scope"].(string); scope != "" {
+		vol.Scope = scope
+	}
+	// the labels are a map in inspect, but a string in ls
+	if labels, ok := volume["Labels"].(string); ok {
+		vol.Labels = splitLabels(labels)
+	} else {
+		vol.Labels = stringMap(volume["Labels"])
+	}
+	if options, ok := vol.Labels[volumeOptionsLabel]; ok {
+		_ = json.Unmarshal([]byte(options), &vol.Options)
+		delete(vol.Labels, volumeOptionsLabel)
+	}
+	// docker has the time in seconds
+	if createdAt, _ := volume["CreatedAt"].(string); createdAt != "" {
+		if t, err := time.Parse(time.RFC3339Nano, createdAt); err == nil {
+			vol.CreatedAt = t.Format(time.RFC3339)
+		} else {
+			vol.CreatedAt = createdAt
+		}
+	}
+	return vol
+}
+
+// anonymousVolumesLabel is the label of nerdctl, with the anonymous volumes of the container as JSON
+const anonymousVolumesLabel = "nerdctl/anonymous-volumes"
+
+// reAnonymousVolume is the generated name of an anonymous volume
+var reAnonymousVolume = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// anonymousVolumes returns the anonymous volumes of the container, that are removed with it
+func anonymousVolumes(inspect map[string]interface{}) []string {
+	config, _ := inspect["Config"].(map[string]interface{})
+	var names []string
+	if label, ok := stringMap(config["Labels"])[anonymousVolumesLabel]; ok {
+		_ = json.Unmarshal([]byte(label), &names)
+		return names
+	}
+	// older nerdctl doesn't have the label, so go by the name
+	mounts, _ := inspect["Mounts"].([]interface{})
+	for _, mount := range mounts {
+		m, _ := mount.(map[string]interface{})
+		name, _ := m["Name"].(string)
+		if m["Type"] == "volume" && reAnonymousVolume.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// volumeNames returns the names of all the volumes
+func volumeNames() ([]string, error) {
+	volumes, err := backend.Volumes(nil)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, volume := range volumes {
+		name, _ := volume["Name"].(string)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// pruneCandidates returns the names of the volumes that are not in use (with the label filters),
+// which are the ones that prune can remove
+func pruneCandidates(labels []string) ([]string, error) {
+	volumes, err := backend.Volumes(append([]string{"dangling=true"}, labels...))
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, volume := range volumes {
+		name, _ := volume["Name"].(string)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// volumesDestroyed publishes the "destroy" events of the removed volumes, since there are
+// no containerd events for volumes, and returns the space that was reclaimed
+func volumesDestroyed(names []string, sizes map[string]int64) int64 {
+	var space int64
+	for _, name := range names {
+		space += sizes[name]
+		t := time.Now()
+		ev := &Event{Type: "volume", Action: "destroy", Scope: "local", Time: t.Unix(), TimeNano: t.UnixNano()}
+		ev.Actor = Actor{ID: name, Attributes: map[string]string{"driver": "local"}}
+		events.publish(ev)
+	}
+	return space
+}
+
+func getVolumes(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	volumes, err := backend.Volumes(filterArgs(filters, "dangling", "label", "name"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	names := []string{}
+	for _, volume := range volumes {
+		name, _ := volume["Name"].(string)
+		names = append(names, name)
+	}
+	// ls has no creation time, and the labels are flattened
+	inspects := map[string]map[string]interface{}{}
+	if inspect, err := backend.InspectVolumes(names); err == nil {
+		for _, volume := range inspect {
+			name, _ := volume["Name"].(string)
+			inspects[name] = volume
+		}
+	} else {
+		logRequest(c, "volume inspect: %v", err)
+	}
+	vols := []dockerVolume{}
+	for _, volume := range volumes {
+		name, _ := volume["Name"].(string)
+		if inspect, ok := inspects[name]; ok {
+			for k, v := range volume {
+				if _, ok := inspect[k]; !ok {
+					inspect[k] = v
+				}
+			}
+			volume = inspect
+		}
+		vols = append(vols, convertVolume(volume))
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	data := map[string]interface{}{"Volumes": vols, "Warnings": []string{}}
+	c.JSON(http.StatusOK, data)
+}
+
+func inspectVolume(c *gin.Context) {
+	name := c.Param("name")
+	volume, err := backend.Volume(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusNotFound)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusOK, convertVolume(volume))
+}
+
+func createVolume(c *gin.Context) {
+	var req struct {
+		Name       string
+		Driver     string
+		DriverOpts map[string]string
+		Labels     map[string]string
+	}
+	if !bindJSON(c, &req) {
+		return
+	}
+	if req.Driver != "" && req.Driver != "local" {
+		httpError(c.Writer, fmt.Sprintf("volume driver %q not supported", req.Driver), http.StatusBadRequest)
+		return
+	}
+	labels := map[string]string{}
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	if len(req.DriverOpts) > 0 {
+		data, _ := json.Marshal(req.DriverOpts)
+		labels[volumeOptionsLabel] = string(data)
+	}
+	name, err := backend.CreateVolume(req.Name, labels)
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	volume, err := backend.Volume(name)
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.JSON(http.StatusCreated, convertVolume(volume))
+}
+
+func removeVolume(c *gin.Context) {
+	name := c.Param("name")
+	force := c.Query("force")
+	err := backend.RemoveVolume(name, force == "1" || force == "true")
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func pruneVolumes(c *gin.Context) {
+	filters, err := parseFilters(c.Query("filters"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	all := len(filters["all"]) > 0 && (filters["all"][0] == "1" || filters["all"][0] == "true")
+	// only the volumes that can be removed are sized
+	names, err := pruneCandidates(filterArgs(filters, "label"))
+	if err != nil {
+		httpError(c.Writer, err.Error(), errorStatus(err))
+		return
+	}
+	sizes := backend.VolumeSizes(names)
+	var deleted []string
+	if len(filters["label"]) > 0 {
+		// nerdctl prune has no filters, so remove the labeled volumes that are not in use
+		deleted = []string{}
+		for _, name := range names {
+			if err := backend.RemoveVolume(name, false); err == nil {
+				deleted = append(deleted, name)
+			}
+		}
+	} else if deleted, err = backend.PruneVolumes(all); err != nil {
+		httpError(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var vp struct {
+		VolumesDeleted []string
+		SpaceReclaimed int64
+	}
+	vp.VolumesDeleted = deleted
+	vp.SpaceReclaimed = volumesDestroyed(deleted, sizes)
+	c.JSON(http.StatusOK, vp)
+}
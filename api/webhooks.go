@@ -0,0 +1,118 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook is an url, that the matching events are posted to (as JSON),
+// or published to with NATS (nats://host/subject) or MQTT (mqtt://host/topic)
+type Webhook struct {
+	URL string
+	// Filters are like for /events, for example {"event": ["die", "oom"]}
+	Filters map[string][]string
+}
+
+// ParseWebhook parses the url, with optional filters, like "https://example.com/hook event=die"
+func ParseWebhook(s string) (Webhook, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || !(strings.HasPrefix(fields[0], "http://") || strings.HasPrefix(fields[0], "https://") ||
+		strings.HasPrefix(fields[0], "nats://") || strings.HasPrefix(fields[0], "mqtt://")) {
+		return Webhook{}, fmt.Errorf("invalid webhook: %q", s)
+	}
+	hook := Webhook{URL: fields[0], Filters: map[string][]string{}}
+	for _, filter := range fields[1:] {
+		k, v, ok := strings.Cut(filter, "=")
+		if !ok {
+			return Webhook{}, fmt.Errorf("invalid webhook filter: %q", filter)
+		}
+		hook.Filters[k] = append(hook.Filters[k], v)
+	}
+	return hook, nil
+}
+
+// StartWebhooks posts the events to the webhooks, until the context is done
+func StartWebhooks(ctx context.Context, hooks []Webhook) {
+	if len(hooks) == 0 {
+		return
+	}
+	ch := events.subscribe()
+	queues := make([]chan *Event, len(hooks))
+	for i, hook := range hooks {
+		// every webhook gets its own queue, so a slow one doesn't delay the others
+		queues[i] = make(chan *Event, 64)
+		if strings.HasPrefix(hook.URL, "nats://") || strings.HasPrefix(hook.URL, "mqtt://") {
+			go publishEvents(hook, queues[i])
+		} else {
+			go postEvents(hook, queues[i])
+		}
+	}
+	go func() {
+		defer func() {
+			events.unsubscribe(ch)
+			for _, queue := range queues {
+				close(queue)
+			}
+		}()
+		for {
+			var ev *Event
+			select {
+			case <-ctx.Done():
+				return
+			case ev = <-ch:
+			}
+			for i, hook := range hooks {
+				if !matchEvent(hook.Filters, ev) {
+					continue
+				}
+				select {
+				case queues[i] <- ev:
+				default:
+					log.Printf("webhook %s: dropped %s %s", hook.URL, ev.Type, ev.Action)
+				}
+			}
+		}
+	}()
+}
+
+func postEvents(hook Webhook, queue chan *Event) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for ev := range queue {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			log.Printf("webhook %s: %v", hook.URL, err)
+			continue
+		}
+		resp, err := client.Post(hook.URL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			log.Printf("webhook %s: %v", hook.URL, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("webhook %s: %s", hook.URL, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// wsFrame is a websocket message, either binary (the terminal data) or text (a control message)
+type wsFrame struct {
+	binary bool
+	data   []byte
+}
+
+// wsCodec keeps the type of the frames, which websocket.Message doesn't
+var wsCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		f := v.(wsFrame)
+		if f.binary {
+			return f.data, websocket.BinaryFrame, nil
+		}
+		return f.data, websocket.TextFrame, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		f := v.(*wsFrame)
+		f.binary = payloadType == websocket.BinaryFrame
+		f.data = data
+		return nil
+	},
+}
+
+// wsControl is a control message of the websocket, like {"Resize":{"Height":24,"Width":80}}
+// from the client, or {"ExitCode":0} to the client when the exec has exited
+type wsControl struct {
+	Resize *struct {
+		Height uint16
+		Width  uint16
+	} `json:",omitempty"`
+	ExitCode *int `json:",omitempty"`
+}
+
+// wsWriter writes the output as binary frames, from both stdout and stderr
+type wsWriter struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := wsCodec.Send(w.ws, wsFrame{binary: true, data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// send writes a control message, as a text frame
+func (w *wsWriter) send(msg wsControl) {
+	data, _ := json.Marshal(msg)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = wsCodec.Send(w.ws, wsFrame{data: data})
+}
+
+// startExecWebsocket starts the exec over a websocket, for the web terminals in the browser
+// (which can't upgrade the connection like startExec). The input and output are binary frames,
+// and the text frames are control messages (to resize the tty). Text frames that are not
+// control messages are input too. Closing the websocket hangs up the tty, like a terminal.
+func startExecWebsocket(c *gin.Context) {
+	e := getExec(c.Param("id"))
+	if e == nil {
+		httpError(c.Writer, fmt.Sprintf("No such exec instance: %s", c.Param("id")), http.StatusNotFound)
+		return
+	}
+	if !e.start() {
+		httpError(c.Writer, fmt.Sprintf("Exec %s has already been started", e.ID), http.StatusConflict)
+		return
+	}
+	opts := e.options(false)
+	h, _ := strconv.ParseUint(c.Query("h"), 10, 16)
+	w, _ := strconv.ParseUint(c.Query("w"), 10, 16)
+	// no origin check, the socket is only for local clients (like dockerd)
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		out := &wsWriter{ws: ws}
+		code := execWebsocket(e, opts, ws, out, uint16(h), uint16(w))
+		out.send(wsControl{ExitCode: &code})
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// execWebsocket runs the exec with the input and output of the websocket, and returns the exit code
+func execWebsocket(e *execInstance, opts backend.ExecOptions, ws *websocket.Conn, out *wsWriter, h, w uint16) int {
+	cmd := backend.Exec(e.ContainerID, e.Config.Cmd, opts)
+	// receive calls input with the data, and resize with the size, until the websocket is closed
+	receive := func(input func([]byte), resize func(h, w uint16)) {
+		for {
+			var f wsFrame
+			if err := wsCodec.Receive(ws, &f); err != nil {
+				return
+			}
+			var msg wsControl
+			if !f.binary && json.Unmarshal(f.data, &msg) == nil && msg.Resize != nil {
+				resize(msg.Resize.Height, msg.Resize.Width)
+				continue
+			}
+			input(f.data)
+		}
+	}
+	if opts.Tty {
+		pty, err := backend.StartTty(cmd)
+		if err != nil {
+			e.finish(nil, err)
+			fmt.Fprintf(out, "%s\r\n", err)
+			return *e.ExitCode
+		}
+		e.mu.Lock()
+		e.pty = pty
+		e.Pid = cmd.Process.Pid
+		e.mu.Unlock()
+		if h > 0 && w > 0 {
+			_ = backend.ResizePty(pty, h, w)
+		}
+		go func() {
+			receive(func(data []byte) {
+				if opts.Interactive {
+					_, _ = pty.Write(data)
+				}
+			}, func(h, w uint16) {
+				_ = backend.ResizePty(pty, h, w)
+			})
+			// hang up, like when the terminal is closed
+			pty.Close()
+		}()
+		// the pty returns an error (EIO) when the process has exited
+		_, _ = io.Copy(out, pty)
+		err = cmd.Wait()
+		pty.Close()
+		e.finish(cmd, err)
+		return *e.ExitCode
+	}
+	if e.Config.AttachStdout {
+		cmd.Stdout = out
+	}
+	if e.Config.AttachStderr {
+		cmd.Stderr = out
+	}
+	var stdin io.WriteCloser
+	var err error
+	if opts.Interactive {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			e.finish(nil, err)
+			return *e.ExitCode
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		e.finish(nil, err)
+		fmt.Fprintf(out, "%s\n", err)
+		return *e.ExitCode
+	}
+	e.mu.Lock()
+	e.Pid = cmd.Process.Pid
+	e.mu.Unlock()
+	go func() {
+		receive(func(data []byte) {
+			if stdin != nil {
+				_, _ = stdin.Write(data)
+			}
+		}, func(h, w uint16) {})
+		if stdin != nil {
+			stdin.Close()
+		}
+	}()
+	err = cmd.Wait()
+	e.finish(cmd, err)
+	return *e.ExitCode
+}
@@ -0,0 +1,103 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// AuthConfig is the registry credentials, as sent in the X-Registry-Auth header
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// registryHost returns the registry of the image name, as used in the docker config
+func registryHost(name string) string {
+	i := strings.Index(name, "/")
+	if i > 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			return host
+		}
+	}
+	return "https://index.docker.io/v1/"
+}
+
+// dockerConfig writes the credentials to a new docker config directory, for
+// the commands to use (instead of the default), and returns the directory.
+// It is created in the shared directory, so that the remote commands can use it too.
+func dockerConfig(name string, auth *AuthConfig) (string, error) {
+	server := auth.ServerAddress
+	if server == "" || strings.Contains(server, "docker.io") {
+		server = registryHost(name)
+	}
+	entry := map[string]string{}
+	if auth.Auth != "" {
+		entry["auth"] = auth.Auth
+	} else if auth.Username != "" {
+		entry["auth"] = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	}
+	if auth.IdentityToken != "" {
+		entry["identitytoken"] = auth.IdentityToken
+	}
+	config := map[string]interface{}{"auths": map[string]interface{}{server: entry}}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(SharedDir, "nerdctld-auth")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), data, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// nerdctlAuthCommand returns the nerdctl command, using only the credentials (if not nil)
+// in a config directory of its own, so that they are not stored for the other clients.
+// The returned function removes the directory, after the command has finished.
+func nerdctlAuthCommand(name string, auth *AuthConfig, args ...string) (*exec.Cmd, func(), error) {
+	if auth == nil {
+		return nerdctlCommand(args...), func() {}, nil
+	}
+	dir, err := dockerConfig(name, auth)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	if len(RemoteCommand) > 0 {
+		// the environment is not passed to the remote command
+		args = append([]string{"DOCKER_CONFIG=" + dir, Nerdctl}, append(namespaceArgs(), args...)...)
+		return command(context.Background(), "env", args...), cleanup, nil
+	}
+	cmd := nerdctlCommand(args...)
+	cmd.Env = append(os.Environ(), "DOCKER_CONFIG="+dir)
+	return cmd, cleanup, nil
+}
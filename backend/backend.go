@@ -0,0 +1,241 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package backend runs the nerdctl (and buildctl) commands, and parses their output.
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Nerdctl is the nerdctl command to run
+var Nerdctl = "nerdctl"
+
+// Buildctl is the buildctl command to run
+var Buildctl = "buildctl"
+
+// RemoteCommand is the command that runs the commands on the containerd host,
+// like "limactl shell default" or "ssh user@host", or empty to run them here
+var RemoteCommand []string
+
+// BuildkitHost is the buildkit address on the containerd host, or empty to look for it
+var BuildkitHost string
+
+// SharedDir is the directory for temporary files that is also mounted on the
+// containerd host, or empty for the default
+var SharedDir string
+
+func init() {
+	if runtime.GOOS != "linux" {
+		if os.Getenv("LIMA_INSTANCE") == "" && IsColima("") {
+			UseColima("")
+		} else {
+			UseLima("")
+		}
+	}
+}
+
+// shellQuote quotes the argument for a shell, unless it only has safe characters
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+:,./@%") == "" {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// command returns the command, to be run on the containerd host
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if len(RemoteCommand) > 0 {
+		if filepath.Base(RemoteCommand[0]) == "ssh" {
+			// ssh runs the command with the remote shell, so quote the arguments
+			quoted := []string{}
+			for _, arg := range args {
+				quoted = append(quoted, shellQuote(arg))
+			}
+			name, args = shellQuote(name), quoted
+		}
+		args = append(append(append([]string{}, RemoteCommand[1:]...), name), args...)
+		name = RemoteCommand[0]
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// LookPath returns the full path of the command, on the containerd host
+func LookPath(name string) (string, error) {
+	if len(RemoteCommand) == 0 {
+		return exec.LookPath(name)
+	}
+	nc, err := command(context.Background(), "/bin/sh", "-c", "command -v "+shellQuote(name)).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: executable file not found", name)
+	}
+	return strings.TrimSpace(string(nc)), nil
+}
+
+// nerdctlFound is set once nerdctl has been found, to not look for it again
+var nerdctlFound atomic.Bool
+
+// Available returns an error, until the nerdctl command can be found
+func Available() error {
+	if nerdctlFound.Load() {
+		return nil
+	}
+	if _, err := LookPath(Nerdctl); err != nil {
+		return fmt.Errorf("nerdctl is not available: %w", err)
+	}
+	nerdctlFound.Store(true)
+	return nil
+}
+
+// Reachable returns an error, if nerdctl can't be run or can't reach containerd
+func Reachable(ctx context.Context) error {
+	if err := Available(); err != nil {
+		return err
+	}
+	if _, err := nerdctlCommandContext(ctx, "namespace", "ls", "--quiet").Output(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("containerd is not reachable: %w", ctx.Err())
+		}
+		return fmt.Errorf("containerd is not reachable: %w", commandError(err))
+	}
+	return nil
+}
+
+func nerdctlCommand(args ...string) *exec.Cmd {
+	return nerdctlCommandContext(context.Background(), args...)
+}
+
+func nerdctlCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return command(ctx, Nerdctl, append(namespaceArgs(), args...)...)
+}
+
+// decodeObjects decodes a stream of JSON objects (one per line), without any line length limit
+func decodeObjects(data []byte) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var object map[string]interface{}
+		err := decoder.Decode(&object)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// prunedNames returns the names listed by prune, after the "Deleted ...:" header
+func prunedNames(output []byte) []string {
+	names := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		names = append(names, line)
+	}
+	return names
+}
+
+// commandError returns the error output of the command, if available
+func commandError(err error) error {
+	if exiterr, ok := err.(*exec.ExitError); ok && len(exiterr.Stderr) > 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(exiterr.Stderr)))
+	}
+	return err
+}
+
+var reSize = regexp.MustCompile(`^[0-9.]+[[:blank:]]*[KkMmGgTt]?i?[Bb]?$`)
+
+// ParseSize parses a human readable size, returning an error if it is not one
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if !reSize.MatchString(s) {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return byteSize(s)
+}
+
+// ByteSize parses a human readable size, like "741.4kB" (or returns 0, if it is not one)
+func ByteSize(s string) int64 {
+	log.Printf("byteSize: %s\n", s)
+	size, err := byteSize(s)
+	if err != nil {
+		log.Print(err)
+	}
+	return size
+}
+
+func byteSize(s string) (int64, error) {
+
+	// split s into [match, number, unit], or return 0 if no pattern match is found, eg:
+	// "0B" -> ["0B" "0" "B"]
+	// "1.5GB" -> ["1.5GB", "1.5", "GB"]
+	// "741.4kB" -> ["741.4kB", "741.4", "kB"]
+	re := regexp.MustCompile(`^(.*?)([[:blank:]]*[KkMmGgTt]*i*[Bb]*[[:blank:]]*)$`)
+
+	sm := re.FindStringSubmatch(s)
+	if len(sm) != 3 {
+		return 0, fmt.Errorf("no pattern match found for %q: %v", s, sm)
+	}
+
+	n := 0.0
+	if sm[1] != "" {
+		var err error
+		if n, err = strconv.ParseFloat(sm[1], 64); err != nil {
+			return 0, fmt.Errorf("invalid size: %q", s)
+		}
+	}
+
+	m := 1.0
+	switch strings.ToLower(strings.TrimSpace(sm[2])) {
+	case "kb":
+		m = 1000
+	case "kib":
+		m = 1024
+	case "mb":
+		m = 1000 * 1000
+	case "mib":
+		m = 1024 * 1024
+	case "gb":
+		m = 1000 * 1000 * 1000
+	case "gib":
+		m = 1024 * 1024 * 1024
+	case "tb":
+		m = 1000 * 1000 * 1000 * 1000
+	case "tib":
+		m = 1024 * 1024 * 1024 * 1024
+	}
+
+	return int64(n * m), nil
+}
@@ -0,0 +1,109 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeObjects(t *testing.T) {
+	// larger than the default line limit of bufio.Scanner (64 KiB), like for many labels
+	labels := map[string]string{}
+	for i := 0; i < 1000; i++ {
+		labels[fmt.Sprintf("example.com/label-%d", i)] = strings.Repeat("v", 64)
+	}
+	big, err := json.Marshal(map[string]interface{}{"ID": "big", "Labels": labels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big) <= 64*1024 {
+		t.Fatalf("object is only %d bytes", len(big))
+	}
+	data := `{"ID":"first"}` + "\n" + string(big) + "\n" + `{"ID":"last"}` + "\n"
+
+	objects, err := decodeObjects([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 {
+		t.Fatalf("decoded %d objects, want 3", len(objects))
+	}
+	for i, id := range []string{"first", "big", "last"} {
+		if objects[i]["ID"] != id {
+			t.Errorf("object %d: ID %v, want %s", i, objects[i]["ID"], id)
+		}
+	}
+	if n := len(objects[1]["Labels"].(map[string]interface{})); n != len(labels) {
+		t.Errorf("decoded %d labels, want %d", n, len(labels))
+	}
+}
+
+func TestDecodeObjectsEmpty(t *testing.T) {
+	for _, data := range []string{"", "\n", "  \n\n"} {
+		objects, err := decodeObjects([]byte(data))
+		if err != nil || len(objects) != 0 {
+			t.Errorf("%q: %v, %v", data, objects, err)
+		}
+	}
+}
+
+func TestDecodeObjectsInvalid(t *testing.T) {
+	for _, data := range []string{`{"ID":"a"}` + "\n" + `{"ID":`, "not json", `["a"]`} {
+		if _, err := decodeObjects([]byte(data)); err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+}
+
+func TestJournalArgs(t *testing.T) {
+	t.Setenv("CONTAINERD_NAMESPACE", "")
+	const id = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, tc := range []struct {
+		opts LogsOptions
+		want []string
+	}{
+		// nerdctl writes the short ID as the SYSLOG_IDENTIFIER, and reads it back by that
+		{LogsOptions{}, []string{"--no-pager", "--output", "json", "--all", "SYSLOG_IDENTIFIER=0123456789ab"}},
+		{LogsOptions{Follow: true, Tail: "10"}, []string{"--no-pager", "--output", "json", "--all", "--follow", "--lines", "10", "SYSLOG_IDENTIFIER=0123456789ab"}},
+		{LogsOptions{Tail: "all", Since: "0"}, []string{"--no-pager", "--output", "json", "--all", "SYSLOG_IDENTIFIER=0123456789ab"}},
+		{LogsOptions{Since: "2024-01-02T03:04:05Z", Until: "2024-01-02T03:04:06.5Z"},
+			[]string{"--no-pager", "--output", "json", "--all", "--since", "@1704164645", "--until", "@1704164646", "SYSLOG_IDENTIFIER=0123456789ab"}},
+		// unless there is a tag, which is a template
+		{LogsOptions{Tag: "web"}, []string{"--no-pager", "--output", "json", "--all", "SYSLOG_IDENTIFIER=web"}},
+		{LogsOptions{Tag: "{{.Namespace}}/{{.ID}}"}, []string{"--no-pager", "--output", "json", "--all", "SYSLOG_IDENTIFIER=default/0123456789ab"}},
+		{LogsOptions{Tag: "app-{{.FullID}}"}, []string{"--no-pager", "--output", "json", "--all", "SYSLOG_IDENTIFIER=app-" + id}},
+	} {
+		args, err := journalArgs(id, tc.opts)
+		if err != nil {
+			t.Errorf("%+v: %v", tc.opts, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tc.want) {
+			t.Errorf("%+v: %q, want %q", tc.opts, args, tc.want)
+		}
+	}
+	for _, tag := range []string{"{{.ID", "{{.Name}}"} {
+		if _, err := journalArgs(id, LogsOptions{Tag: tag}); err == nil {
+			t.Errorf("%q: expected an error", tag)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/afbjorklund/nerdctld/stream"
+)
+
+func Build(dir string, sw *stream.Writer, t string, f string, o string, p string, ba map[string]interface{}, l map[string]interface{}) error {
+	args := []string{"build"}
+	if t != "" {
+		args = append(args, "-t")
+		args = append(args, t)
+	}
+	if f != "" {
+		args = append(args, "-f")
+		args = append(args, filepath.Join(dir, f))
+	}
+	if o != "" {
+		args = append(args, "--output")
+		args = append(args, o)
+	}
+	if p != "" {
+		args = append(args, "--platform")
+		args = append(args, p)
+	}
+	if len(ba) > 0 {
+		for k, v := range ba {
+			arg := fmt.Sprintf("%s=%s", k, v.(string))
+			args = append(args, "--build-arg="+arg)
+		}
+	}
+	if len(l) > 0 {
+		for k, v := range l {
+			arg := fmt.Sprintf("%s=%s", k, v.(string))
+			args = append(args, "--label="+arg)
+		}
+	}
+	args = append(args, dir)
+	log.Printf("build %v\n", args)
+	cmd := nerdctlCommand(args...)
+	return stream.Command(cmd, sw)
+}
+
+func BuildPrune() (int64, error) {
+	args := []string{"builder", "prune"}
+	nc, err := nerdctlCommand(args...).CombinedOutput()
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(string(nc), "\n")
+	size := int64(0)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Total:") {
+			s := strings.Replace(line, "Total:", "", 1)
+			size = ByteSize(strings.TrimSpace(s))
+		}
+	}
+	return size, nil
+}
+
+func buildctlCommand(args ...string) *exec.Cmd {
+	return command(context.Background(), Buildctl, args...)
+}
+
+func isUnixSocket(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.Mode().Type() == os.ModeSocket
+}
+
+func buildkitSocket(dir string, namespace string) string {
+	socks := []string{}
+	sock := "buildkitd.sock"
+	if namespace != "default" {
+		subdir := fmt.Sprintf("buildkit-%s", namespace)
+		socks = append(socks, filepath.Join(dir, subdir, sock))
+	}
+	socks = append(socks, filepath.Join(dir, "buildkit-default", sock))
+	for _, s := range socks {
+		if isUnixSocket(s) {
+			return s
+		}
+	}
+	return filepath.Join(dir, "buildkit", sock)
+}
+
+func buildArgs() []string {
+	args := []string{}
+	address := os.Getenv("BUILDKIT_HOST")
+	if address == "" {
+		address = BuildkitHost
+	}
+	if len(RemoteCommand) > 0 {
+		script := `find $XDG_RUNTIME_DIR -name buildkitd.sock -type s `
+		ns := "${CONTAINERD_NAMESPACE:-default}"
+		if args := namespaceArgs(); args != nil {
+			ns = args[1]
+		}
+		script += `2>/dev/null | grep buildkit-` + ns
+		if address == "" {
+			sock, err := command(context.Background(), "/bin/sh", "-c", script).Output()
+			if err != nil {
+				return args
+			}
+			address = "unix://" + strings.TrimSuffix(string(sock), "\n")
+		}
+		return append([]string{"--addr", address}, args...)
+	}
+	if uid := os.Geteuid(); uid != 0 {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			dir = fmt.Sprintf("/run/user/%d", uid)
+		}
+		if address == "" {
+			address = "unix://" + buildkitSocket(dir, Namespace())
+		}
+		args = append([]string{"--addr", address}, args...)
+	}
+	return args
+}
+
+func BuildCache() []map[string]interface{} {
+	args := []string{"du", "-v"}
+	args = append(buildArgs(), args...)
+	nc, err := buildctlCommand(args...).Output()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	var records []map[string]interface{}
+	var record = make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(nc))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(record) > 0 && (strings.HasPrefix(line, "ID") || strings.HasPrefix(line, "Total")) {
+			records = append(records, record)
+			record = make(map[string]interface{})
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch strings.TrimSuffix(fields[0], ":") {
+		case "ID":
+			record["ID"] = fields[1]
+		case "Reclaimable":
+			if reclaimable, err := strconv.ParseBool(fields[1]); err == nil {
+				record["InUse"] = !reclaimable
+			}
+		case "Shared":
+			if shared, err := strconv.ParseBool(fields[1]); err == nil {
+				record["Shared"] = shared
+			}
+		case "Size":
+			record["Size"] = fields[1]
+		case "Type":
+			record["Type"] = fields[1]
+		}
+	}
+	return records
+}
+
+func BuildWorker() string {
+	args := []string{"debug", "workers", "--format=json"}
+	args = append(buildArgs(), args...)
+	nc, err := buildctlCommand(args...).Output()
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	var workers []map[string]interface{}
+	err = json.Unmarshal(nc, &workers)
+	if err != nil {
+		log.Print(err)
+		return ""
+	}
+	for _, worker := range workers {
+		labels := worker["labels"].(map[string]interface{})
+		executor := labels["org.mobyproject.buildkit.worker.executor"].(string)
+		return executor
+	}
+	return ""
+}
@@ -0,0 +1,281 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the clock ticks per second, of /proc/stat and cpuacct.stat
+const clockTicks = 100
+
+// ReadCgroupStats reads the stats of the process (the container task) from the cgroup files,
+// instead of running nerdctl for them. It only works for a local containerd (not in a VM).
+func ReadCgroupStats(pid int) (*CgroupStats, error) {
+	if len(RemoteCommand) > 0 || pid <= 0 {
+		return nil, fmt.Errorf("no local cgroup")
+	}
+	paths, err := cgroupPaths(pid)
+	if err != nil {
+		return nil, err
+	}
+	stats := &CgroupStats{MemoryStats: map[string]uint64{}}
+	if path, ok := paths[""]; ok && fileExists("/sys/fs/cgroup/cgroup.controllers") {
+		err = readCgroup2(filepath.Join("/sys/fs/cgroup", path), stats)
+	} else {
+		err = readCgroup1(paths, stats)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stats.SystemUsage, stats.OnlineCPUs = systemUsage()
+	if stats.MemoryLimit == 0 {
+		stats.MemoryLimit = memTotal()
+	}
+	stats.Networks = netDev(pid)
+	return stats, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// cgroupPaths returns the path of the cgroup for each controller, and "" for cgroup v2
+func cgroupPaths(pid int) (map[string]string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, nil
+}
+
+// readUint returns the number in the file, or zero for "max" (and missing files)
+func readUint(path string) uint64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+// readKeyValues returns the "key value" lines of the file, like memory.stat
+func readKeyValues(path string) map[string]uint64 {
+	values := map[string]uint64{}
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values
+}
+
+func readCgroup2(dir string, stats *CgroupStats) error {
+	if !fileExists(dir) {
+		return fmt.Errorf("no cgroup %s", dir)
+	}
+	cpu := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	stats.CPUUsage = cpu["usage_usec"] * 1000
+	stats.CPUUser = cpu["user_usec"] * 1000
+	stats.CPUSystem = cpu["system_usec"] * 1000
+	stats.Periods = cpu["nr_periods"]
+	stats.ThrottledPeriods = cpu["nr_throttled"]
+	stats.ThrottledTime = cpu["throttled_usec"] * 1000
+	stats.MemoryUsage = readUint(filepath.Join(dir, "memory.current"))
+	stats.MemoryMaxUsage = readUint(filepath.Join(dir, "memory.peak"))
+	stats.MemoryLimit = readUint(filepath.Join(dir, "memory.max"))
+	stats.MemoryStats = readKeyValues(filepath.Join(dir, "memory.stat"))
+	stats.Pids = readUint(filepath.Join(dir, "pids.current"))
+	stats.PidsLimit = readUint(filepath.Join(dir, "pids.max"))
+	data, _ := os.ReadFile(filepath.Join(dir, "io.stat"))
+	stats.IoServiceBytes, stats.IoServiced = parseIoStat(data)
+	return nil
+}
+
+func readCgroup1(paths map[string]string, stats *CgroupStats) error {
+	dir := func(controller string) string {
+		return filepath.Join("/sys/fs/cgroup", controller, paths[controller])
+	}
+	cpuacct := dir("cpuacct")
+	if _, ok := paths["cpuacct"]; !ok || !fileExists(cpuacct) {
+		return fmt.Errorf("no cgroup for cpuacct")
+	}
+	stats.CPUUsage = readUint(filepath.Join(cpuacct, "cpuacct.usage"))
+	if data, err := os.ReadFile(filepath.Join(cpuacct, "cpuacct.usage_percpu")); err == nil {
+		for _, field := range strings.Fields(string(data)) {
+			n, _ := strconv.ParseUint(field, 10, 64)
+			stats.PercpuUsage = append(stats.PercpuUsage, n)
+		}
+	}
+	acct := readKeyValues(filepath.Join(cpuacct, "cpuacct.stat"))
+	stats.CPUUser = acct["user"] * 1e9 / clockTicks
+	stats.CPUSystem = acct["system"] * 1e9 / clockTicks
+	cpu := readKeyValues(filepath.Join(dir("cpu"), "cpu.stat"))
+	stats.Periods = cpu["nr_periods"]
+	stats.ThrottledPeriods = cpu["nr_throttled"]
+	stats.ThrottledTime = cpu["throttled_time"]
+	memory := dir("memory")
+	stats.MemoryUsage = readUint(filepath.Join(memory, "memory.usage_in_bytes"))
+	stats.MemoryMaxUsage = readUint(filepath.Join(memory, "memory.max_usage_in_bytes"))
+	stats.MemoryLimit = readUint(filepath.Join(memory, "memory.limit_in_bytes"))
+	if stats.MemoryLimit >= 1<<62 {
+		// unlimited, is the largest page aligned number
+		stats.MemoryLimit = 0
+	}
+	stats.MemoryStats = readKeyValues(filepath.Join(memory, "memory.stat"))
+	stats.Pids = readUint(filepath.Join(dir("pids"), "pids.current"))
+	stats.PidsLimit = readUint(filepath.Join(dir("pids"), "pids.max"))
+	// like "8:0 Read 1024"
+	blkio := func(name string) []BlkioEntry {
+		entries := []BlkioEntry{}
+		data, _ := os.ReadFile(filepath.Join(dir("blkio"), name))
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				continue
+			}
+			major, minor, ok := parseDevice(fields[0])
+			if !ok {
+				continue
+			}
+			n, _ := strconv.ParseUint(fields[2], 10, 64)
+			entries = append(entries, BlkioEntry{major, minor, fields[1], n})
+		}
+		return entries
+	}
+	stats.IoServiceBytes = blkio("blkio.throttle.io_service_bytes_recursive")
+	stats.IoServiced = blkio("blkio.throttle.io_serviced_recursive")
+	return nil
+}
+
+// systemUsage returns the total cpu time of the host (like docker), and the number of cpus
+func systemUsage() (uint64, uint32) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	var total uint64
+	var cpus uint32
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch {
+		case fields[0] == "cpu":
+			// user nice system idle iowait irq softirq (and steal)
+			for _, field := range fields[1:min(len(fields), 9)] {
+				n, _ := strconv.ParseUint(field, 10, 64)
+				total += n
+			}
+		case strings.HasPrefix(fields[0], "cpu"):
+			cpus++
+		}
+	}
+	return total * 1e9 / clockTicks, cpus
+}
+
+// memTotal returns the memory of the host, as the limit for containers without one
+func memTotal() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
+
+// netDev returns the traffic of the interfaces, in the network namespace of the process
+func netDev(pid int) map[string]NetworkStats {
+	networks := map[string]NetworkStats{}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return networks
+	}
+	return parseNetDev(data)
+}
+
+// ProcessStartTime returns when the process (like the container task) was started, from /proc
+func ProcessStartTime(pid int) (time.Time, error) {
+	if len(RemoteCommand) > 0 || pid <= 0 {
+		return time.Time{}, fmt.Errorf("no local process")
+	}
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// the command is in parentheses, and can contain spaces
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("invalid stat for %d", pid)
+	}
+	// the start time is field 22, in clock ticks since boot
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("invalid stat for %d", pid)
+	}
+	ticks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	boot := readKeyValues("/proc/stat")["btime"]
+	if boot == 0 {
+		return time.Time{}, fmt.Errorf("no boot time")
+	}
+	return time.Unix(int64(boot), 0).Add(time.Duration(ticks) * time.Second / clockTicks), nil
+}
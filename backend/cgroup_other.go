@@ -0,0 +1,34 @@
+//go:build !linux
+
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReadCgroupStats is only implemented on linux
+func ReadCgroupStats(pid int) (*CgroupStats, error) {
+	return nil, fmt.Errorf("cgroups not supported")
+}
+
+// ProcessStartTime is only implemented on linux
+func ProcessStartTime(pid int) (time.Time, error) {
+	return time.Time{}, fmt.Errorf("processes not supported")
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// colimaHome returns the colima configuration directory, like "~/.colima"
+func colimaHome() string {
+	if home := os.Getenv("COLIMA_HOME"); home != "" {
+		return home
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".colima")
+}
+
+// colimaProfile returns the colima profile, from $COLIMA_PROFILE
+func colimaProfile() string {
+	if profile := os.Getenv("COLIMA_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// IsColima returns whether the colima profile is running with containerd,
+// which forwards the containerd socket to the profile directory
+func IsColima(profile string) bool {
+	if profile == "" {
+		profile = colimaProfile()
+	}
+	return isUnixSocket(filepath.Join(colimaHome(), profile, "containerd.sock"))
+}
+
+// UseColima runs the commands in the colima profile (as root, like "colima nerdctl")
+func UseColima(profile string) {
+	if profile == "" {
+		profile = colimaProfile()
+	}
+	RemoteCommand = []string{"colima", "--profile", profile, "ssh", "--", "sudo"}
+	// colima runs a rootful buildkitd, next to containerd
+	BuildkitHost = "unix:///run/buildkit/buildkitd.sock"
+	SharedDir = "/tmp/colima"
+}
+
+// UseLima runs the commands in the lima instance, or the one in $LIMA_INSTANCE
+func UseLima(instance string) {
+	RemoteCommand = []string{"lima"}
+	if instance != "" {
+		RemoteCommand = []string{"limactl", "shell", instance}
+	}
+	BuildkitHost = ""
+	SharedDir = "/tmp/lima"
+}
+
+// UseLocal runs the commands on this host
+func UseLocal() {
+	RemoteCommand = nil
+	BuildkitHost = ""
+	SharedDir = ""
+}
@@ -0,0 +1,684 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func Containers(all bool, filters ...string) ([]map[string]interface{}, error) {
+	args := []string{"ps"}
+	if all {
+		args = append(args, "-a")
+	}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	args = append(args, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return decodeObjects(nc)
+}
+
+// ContainerNames returns the names of all the containers, by their full ID
+func ContainerNames() (map[string]string, error) {
+	args := []string{"ps", "-a", "--no-trunc", "--format", "{{.ID}} {{.Names}}"}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	names := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(nc)), "\n") {
+		id, name, _ := strings.Cut(line, " ")
+		if id != "" {
+			names[id] = name
+		}
+	}
+	return names, nil
+}
+
+// ContainerIDs returns the full IDs of the containers that inspect finds for the name
+// (by full ID, name or ID prefix), which is more than one for an ambiguous name
+func ContainerIDs(name string) ([]string, error) {
+	args := []string{"container", "inspect", "--mode", "dockercompat", name, "--format", "{{.Id}}"}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	ids := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(nc)), "\n") {
+		if line != "" {
+			ids = append(ids, line)
+		}
+	}
+	return ids, nil
+}
+
+func Container(name string) (map[string]interface{}, error) {
+	args := []string{"container", "inspect", "--mode", "dockercompat"}
+	args = append(args, name, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	var image map[string]interface{}
+	err = json.Unmarshal(nc, &image)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// ContainerSpec returns the OCI runtime spec of the container, from the native inspect
+func ContainerSpec(name string) (map[string]interface{}, error) {
+	args := []string{"container", "inspect", "--mode", "native"}
+	args = append(args, name, "--format", "{{json .Spec}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(nc, &spec); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// LogsOptions are the options for showing the logs of a container
+type LogsOptions struct {
+	Follow     bool
+	Timestamps bool
+	Tail       string
+	Since      string
+	Until      string
+	// Driver is the logging driver of the container, "journald" is read with journalctl
+	Driver string
+	// Tag is the "tag" option of the logging driver, which is the identifier in the journal
+	Tag string
+}
+
+// Logs writes the logs of the container to stdout and stderr (either may be nil),
+// until the logs end or the context is done when following them.
+func Logs(ctx context.Context, name string, stdout io.Writer, stderr io.Writer, opts LogsOptions) error {
+	if opts.Driver == "journald" {
+		return journalLogs(ctx, name, stdout, stderr, opts)
+	}
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	if opts.Tail != "" && opts.Tail != "all" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	if opts.Since != "" && opts.Since != "0" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Until != "" && opts.Until != "0" {
+		args = append(args, "--until", opts.Until)
+	}
+	args = append(args, name)
+	cmd := nerdctlCommandContext(ctx, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		// the client went away
+		return nil
+	}
+	return err
+}
+
+// ContainerOptions are the options for creating a container
+type ContainerOptions struct {
+	Name              string
+	Hostname          string
+	Domainname        string
+	MacAddress        string
+	User              string
+	Env               []string
+	Labels            map[string]string
+	WorkingDir        string
+	Entrypoint        []string
+	StopSignal        string
+	StopTimeout       *int
+	Tty               bool
+	Interactive       bool
+	Publish           []string
+	Volumes           []string
+	Mounts            []string
+	Networks          []string
+	IP                string
+	IP6               string
+	VolumesFrom       []string
+	Privileged        bool
+	Init              bool
+	AutoRemove        bool
+	Restart           string
+	CapAdd            []string
+	CapDrop           []string
+	AddHosts          []string
+	DNS               []string
+	DNSSearch         []string
+	DNSOptions        []string
+	Tmpfs             []string
+	Devices           []string
+	SecurityOpt       []string
+	Healthcheck       *Healthcheck
+	Memory            int64
+	MemoryReservation int64
+	MemorySwap        int64
+	CPUs              float64
+	CPUShares         int64
+	CPUQuota          int64
+	CPUPeriod         int64
+	CpusetCpus        string
+	CpusetMems        string
+	BlkioWeight       uint16
+	PidsLimit         int64
+	OomScoreAdj       int
+	OomKillDisable    bool
+	ShmSize           int64
+	CgroupParent      string
+	Cgroupns          string
+	GroupAdd          []string
+	ReadOnly          bool
+	Sysctls           []string
+	Ulimits           []string
+	Pid               string
+	IPC               string
+	Runtime           string
+	LogDriver         string
+	LogOpts           []string
+	Platform          string
+	Pull              string
+	Annotations       []string
+}
+
+// Healthcheck is the health check of a container, with the command run by the shell
+type Healthcheck struct {
+	Cmd         string
+	Disable     bool
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+// CreateContainer creates a container from the image, and returns the ID
+func CreateContainer(image string, cmd []string, opts ContainerOptions) (string, error) {
+	args := []string{"create"}
+	if opts.Name != "" {
+		args = append(args, "--name", opts.Name)
+	}
+	if opts.Hostname != "" {
+		args = append(args, "--hostname", opts.Hostname)
+	}
+	if opts.Domainname != "" {
+		args = append(args, "--domainname", opts.Domainname)
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	for _, env := range opts.Env {
+		args = append(args, "--env", env)
+	}
+	for k, v := range opts.Labels {
+		args = append(args, "--label", k+"="+v)
+	}
+	if opts.WorkingDir != "" {
+		args = append(args, "--workdir", opts.WorkingDir)
+	}
+	if len(opts.Entrypoint) > 0 {
+		// nerdctl only takes the executable, so the rest goes before the command
+		args = append(args, "--entrypoint", opts.Entrypoint[0])
+		cmd = append(append([]string{}, opts.Entrypoint[1:]...), cmd...)
+	} else if opts.Entrypoint != nil {
+		// an empty entrypoint resets the one of the image
+		args = append(args, "--entrypoint", "")
+	}
+	if opts.StopSignal != "" {
+		args = append(args, "--stop-signal", opts.StopSignal)
+	}
+	if opts.StopTimeout != nil {
+		args = append(args, "--stop-timeout", strconv.Itoa(*opts.StopTimeout))
+	}
+	if opts.Tty {
+		args = append(args, "--tty")
+	}
+	if opts.Interactive {
+		args = append(args, "--interactive")
+	}
+	for _, publish := range opts.Publish {
+		args = append(args, "--publish", publish)
+	}
+	for _, volume := range opts.Volumes {
+		args = append(args, "--volume", volume)
+	}
+	for _, mount := range opts.Mounts {
+		args = append(args, "--mount", mount)
+	}
+	for _, network := range opts.Networks {
+		args = append(args, "--network", network)
+	}
+	if opts.IP != "" {
+		args = append(args, "--ip", opts.IP)
+	}
+	if opts.IP6 != "" {
+		args = append(args, "--ip6", opts.IP6)
+	}
+	if opts.MacAddress != "" {
+		args = append(args, "--mac-address", opts.MacAddress)
+	}
+	for _, from := range opts.VolumesFrom {
+		args = append(args, "--volumes-from", from)
+	}
+	if opts.Privileged {
+		args = append(args, "--privileged")
+	}
+	if opts.Init {
+		args = append(args, "--init")
+	}
+	if opts.CgroupParent != "" {
+		args = append(args, "--cgroup-parent", opts.CgroupParent)
+	}
+	if opts.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(opts.PidsLimit, 10))
+	}
+	if opts.OomScoreAdj != 0 {
+		args = append(args, "--oom-score-adj", strconv.Itoa(opts.OomScoreAdj))
+	}
+	if opts.OomKillDisable {
+		args = append(args, "--oom-kill-disable")
+	}
+	if opts.Cgroupns != "" {
+		args = append(args, "--cgroupns", opts.Cgroupns)
+	}
+	for _, group := range opts.GroupAdd {
+		args = append(args, "--group-add", group)
+	}
+	if opts.AutoRemove {
+		args = append(args, "--rm")
+	}
+	if opts.Restart != "" {
+		args = append(args, "--restart", opts.Restart)
+	}
+	for _, capability := range opts.CapAdd {
+		args = append(args, "--cap-add", capability)
+	}
+	for _, capability := range opts.CapDrop {
+		args = append(args, "--cap-drop", capability)
+	}
+	for _, host := range opts.AddHosts {
+		args = append(args, "--add-host", host)
+	}
+	for _, dns := range opts.DNS {
+		args = append(args, "--dns", dns)
+	}
+	for _, search := range opts.DNSSearch {
+		args = append(args, "--dns-search", search)
+	}
+	for _, opt := range opts.DNSOptions {
+		args = append(args, "--dns-opt", opt)
+	}
+	for _, tmpfs := range opts.Tmpfs {
+		args = append(args, "--tmpfs", tmpfs)
+	}
+	for _, device := range opts.Devices {
+		args = append(args, "--device", device)
+	}
+	for _, opt := range opts.SecurityOpt {
+		args = append(args, "--security-opt", opt)
+	}
+	if hc := opts.Healthcheck; hc != nil {
+		if hc.Disable {
+			args = append(args, "--no-healthcheck")
+		} else if hc.Cmd != "" {
+			args = append(args, "--health-cmd", hc.Cmd)
+		}
+		if hc.Interval > 0 {
+			args = append(args, "--health-interval", hc.Interval.String())
+		}
+		if hc.Timeout > 0 {
+			args = append(args, "--health-timeout", hc.Timeout.String())
+		}
+		if hc.StartPeriod > 0 {
+			args = append(args, "--health-start-period", hc.StartPeriod.String())
+		}
+		if hc.Retries > 0 {
+			args = append(args, "--health-retries", strconv.Itoa(hc.Retries))
+		}
+	}
+	if opts.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(opts.Memory, 10))
+	}
+	if opts.MemoryReservation > 0 {
+		args = append(args, "--memory-reservation", strconv.FormatInt(opts.MemoryReservation, 10))
+	}
+	if opts.MemorySwap != 0 {
+		args = append(args, "--memory-swap", strconv.FormatInt(opts.MemorySwap, 10))
+	}
+	if opts.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(opts.CPUs, 'f', -1, 64))
+	}
+	if opts.CPUShares > 0 {
+		args = append(args, "--cpu-shares", strconv.FormatInt(opts.CPUShares, 10))
+	}
+	if opts.CPUQuota > 0 {
+		args = append(args, "--cpu-quota", strconv.FormatInt(opts.CPUQuota, 10))
+	}
+	if opts.CPUPeriod > 0 {
+		args = append(args, "--cpu-period", strconv.FormatInt(opts.CPUPeriod, 10))
+	}
+	if opts.CpusetCpus != "" {
+		args = append(args, "--cpuset-cpus", opts.CpusetCpus)
+	}
+	if opts.CpusetMems != "" {
+		args = append(args, "--cpuset-mems", opts.CpusetMems)
+	}
+	if opts.BlkioWeight > 0 {
+		args = append(args, "--blkio-weight", strconv.FormatUint(uint64(opts.BlkioWeight), 10))
+	}
+	if opts.ShmSize > 0 {
+		args = append(args, "--shm-size", strconv.FormatInt(opts.ShmSize, 10))
+	}
+	if opts.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	for _, sysctl := range opts.Sysctls {
+		args = append(args, "--sysctl", sysctl)
+	}
+	for _, ulimit := range opts.Ulimits {
+		args = append(args, "--ulimit", ulimit)
+	}
+	if opts.Pid != "" {
+		args = append(args, "--pid", opts.Pid)
+	}
+	if opts.IPC != "" {
+		args = append(args, "--ipc", opts.IPC)
+	}
+	if opts.Runtime != "" {
+		args = append(args, "--runtime", opts.Runtime)
+	}
+	if opts.LogDriver != "" {
+		args = append(args, "--log-driver", opts.LogDriver)
+	}
+	for _, opt := range opts.LogOpts {
+		args = append(args, "--log-opt", opt)
+	}
+	for _, annotation := range opts.Annotations {
+		args = append(args, "--annotation", annotation)
+	}
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+	if opts.Pull != "" {
+		args = append(args, "--pull", opts.Pull)
+	}
+	args = append(args, image)
+	args = append(args, cmd...)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	// the ID is on the last line, after any pull progress
+	lines := strings.Split(strings.TrimSpace(string(nc)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]), nil
+}
+
+// StartContainer starts the container
+func StartContainer(name string) error {
+	args := []string{"start", name}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// StopContainer stops the container, killing it after timeout seconds (if not negative)
+func StopContainer(name string, timeout int) error {
+	args := []string{"stop"}
+	if timeout >= 0 {
+		args = append(args, "--time", strconv.Itoa(timeout))
+	}
+	args = append(args, name)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// PruneContainers removes all stopped containers, and returns their IDs
+func PruneContainers() ([]string, error) {
+	args := []string{"container", "prune", "--force"}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return prunedNames(nc), nil
+}
+
+// RestartContainer restarts the container, killing it after timeout seconds (if not negative)
+func RestartContainer(name string, timeout int) error {
+	args := []string{"restart"}
+	if timeout >= 0 {
+		args = append(args, "--time", strconv.Itoa(timeout))
+	}
+	args = append(args, name)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// UpdateOptions are the resources that can be updated, for a running container
+type UpdateOptions struct {
+	CPUShares         int64
+	CPUPeriod         int64
+	CPUQuota          int64
+	CPUs              float64
+	CpusetCpus        string
+	CpusetMems        string
+	Memory            int64
+	MemoryReservation int64
+	MemorySwap        int64
+	PidsLimit         int64
+	BlkioWeight       uint16
+	Restart           string
+}
+
+// UpdateContainer updates the resources (and restart policy) of the container
+func UpdateContainer(name string, opts UpdateOptions) error {
+	args := []string{"update"}
+	if opts.CPUShares > 0 {
+		args = append(args, "--cpu-shares", strconv.FormatInt(opts.CPUShares, 10))
+	}
+	if opts.CPUPeriod > 0 {
+		args = append(args, "--cpu-period", strconv.FormatInt(opts.CPUPeriod, 10))
+	}
+	if opts.CPUQuota > 0 {
+		args = append(args, "--cpu-quota", strconv.FormatInt(opts.CPUQuota, 10))
+	}
+	if opts.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(opts.CPUs, 'f', -1, 64))
+	}
+	if opts.CpusetCpus != "" {
+		args = append(args, "--cpuset-cpus", opts.CpusetCpus)
+	}
+	if opts.CpusetMems != "" {
+		args = append(args, "--cpuset-mems", opts.CpusetMems)
+	}
+	if opts.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(opts.Memory, 10))
+	}
+	if opts.MemoryReservation > 0 {
+		args = append(args, "--memory-reservation", strconv.FormatInt(opts.MemoryReservation, 10))
+	}
+	if opts.MemorySwap != 0 {
+		args = append(args, "--memory-swap", strconv.FormatInt(opts.MemorySwap, 10))
+	}
+	if opts.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(opts.PidsLimit, 10))
+	}
+	if opts.BlkioWeight > 0 {
+		args = append(args, "--blkio-weight", strconv.FormatUint(uint64(opts.BlkioWeight), 10))
+	}
+	if opts.Restart != "" {
+		args = append(args, "--restart", opts.Restart)
+	}
+	args = append(args, name)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// KillContainer sends the signal (like "SIGHUP", default SIGKILL) to the container
+func KillContainer(name string, signal string) error {
+	args := []string{"kill"}
+	if signal != "" {
+		args = append(args, "--signal", signal)
+	}
+	args = append(args, name)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// PauseContainer pauses all the processes in the container
+func PauseContainer(name string) error {
+	args := []string{"pause", name}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// UnpauseContainer unpauses all the processes in the container
+func UnpauseContainer(name string) error {
+	args := []string{"unpause", name}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// RenameContainer renames the container
+func RenameContainer(name string, newName string) error {
+	args := []string{"rename", name, newName}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// RemoveContainer removes the container, and (with volumes) its anonymous volumes
+func RemoveContainer(name string, force bool, volumes bool) error {
+	args := []string{"rm"}
+	if force {
+		args = append(args, "--force")
+	}
+	if volumes {
+		args = append(args, "--volumes")
+	}
+	args = append(args, name)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// WaitContainer waits for the container to stop, and returns the exit code
+func WaitContainer(ctx context.Context, name string) (int, error) {
+	args := []string{"wait", name}
+	nc, err := nerdctlCommandContext(ctx, args...).Output()
+	if err != nil {
+		return -1, commandError(err)
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(nc)))
+	if err != nil {
+		return -1, err
+	}
+	return code, nil
+}
+
+// CopyFrom copies the path in the container, to the local path
+func CopyFrom(container string, path string, local string) error {
+	args := []string{"cp", container + ":" + path, local}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// CopyTo copies the local path, to the path in the container
+func CopyTo(local string, container string, path string) error {
+	args := []string{"cp", local, container + ":" + path}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// Top returns the processes running in the container, as a ps table
+func Top(name string, psArgs string) (string, error) {
+	args := []string{"top", name}
+	args = append(args, strings.Fields(psArgs)...)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	return string(nc), nil
+}
+
+// CommitOptions are the options for committing a container to an image
+type CommitOptions struct {
+	Author  string
+	Message string
+	Changes []string
+	Pause   bool
+}
+
+// Commit creates an image from the container, and returns the image ID
+func Commit(container string, ref string, opts CommitOptions) (string, error) {
+	args := []string{"commit"}
+	if opts.Author != "" {
+		args = append(args, "--author", opts.Author)
+	}
+	if opts.Message != "" {
+		args = append(args, "--message", opts.Message)
+	}
+	for _, change := range opts.Changes {
+		args = append(args, "--change", change)
+	}
+	if !opts.Pause {
+		args = append(args, "--pause=false")
+	}
+	args = append(args, container, ref)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	return strings.TrimSpace(string(nc)), nil
+}
+
+// Export writes the filesystem of the container as a tar archive
+func Export(container string, w io.Writer) error {
+	args := []string{"export", container}
+	cmd := nerdctlCommand(args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
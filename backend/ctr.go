@@ -0,0 +1,176 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Ctr is the ctr command to run, for the fallback when there is no nerdctl
+var Ctr = "ctr"
+
+// ctrFound is set once ctr has been found, to not look for it again
+var ctrFound atomic.Bool
+
+// Fallback checks if nerdctl is unavailable but ctr is, so that a reduced (read-only)
+// API can be served with ctr instead. It switches back as soon as nerdctl is available.
+func Fallback() bool {
+	if Available() == nil {
+		return false
+	}
+	if ctrFound.Load() {
+		return true
+	}
+	if _, err := LookPath(Ctr); err != nil {
+		return false
+	}
+	ctrFound.Store(true)
+	return true
+}
+
+func ctrCommand(ctx context.Context, args ...string) ([]byte, error) {
+	nc, err := command(ctx, Ctr, append(namespaceArgs(), args...)...).Output()
+	return nc, commandError(err)
+}
+
+// CtrImages returns the images, from "ctr images ls" (with the size in bytes), like:
+// {"Ref":"docker.io/library/alpine:latest","Digest":"sha256:...","Size":3400000,"Platforms":"linux/amd64"}
+func CtrImages() ([]map[string]interface{}, error) {
+	nc, err := ctrCommand(context.Background(), "images", "ls")
+	if err != nil {
+		return nil, err
+	}
+	images := []map[string]interface{}{}
+	for i, line := range strings.Split(strings.TrimSpace(string(nc)), "\n") {
+		// REF TYPE DIGEST SIZE PLATFORMS LABELS, where the size is like "3.2 MiB"
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 6 {
+			continue
+		}
+		images = append(images, map[string]interface{}{
+			"Ref":       fields[0],
+			"Digest":    fields[2],
+			"Size":      ByteSize(fields[3] + fields[4]),
+			"Platforms": fields[5],
+		})
+	}
+	return images, nil
+}
+
+// CtrContainers returns the containers, from "ctr containers info" and the status of their
+// tasks (like "RUNNING"), with the fields of the containerd container:
+// {"ID":"...","Labels":{...},"Image":"...","CreatedAt":"...","Status":"RUNNING","Tty":false}
+func CtrContainers() ([]map[string]interface{}, error) {
+	ctx := context.Background()
+	nc, err := ctrCommand(ctx, "containers", "ls", "--quiet")
+	if err != nil {
+		return nil, err
+	}
+	tasks := map[string]string{}
+	if tc, err := ctrCommand(ctx, "tasks", "ls"); err == nil {
+		for _, line := range strings.Split(string(tc), "\n") {
+			// TASK PID STATUS
+			if fields := strings.Fields(line); len(fields) == 3 {
+				tasks[fields[0]] = fields[2]
+			}
+		}
+	}
+	containers := []map[string]interface{}{}
+	for _, id := range strings.Fields(string(nc)) {
+		ic, err := ctrCommand(ctx, "containers", "info", id)
+		if err != nil {
+			continue
+		}
+		var container map[string]interface{}
+		if err := json.Unmarshal(ic, &container); err != nil {
+			continue
+		}
+		// the spec is large, and only the terminal is needed
+		spec, _ := container["Spec"].(map[string]interface{})
+		process, _ := spec["process"].(map[string]interface{})
+		container["Tty"], _ = process["terminal"].(bool)
+		delete(container, "Spec")
+		container["Status"] = tasks[id]
+		if container["Status"] == "" {
+			container["Status"] = "CREATED"
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// CtrLogs writes the logs of the container, from the json-file log that nerdctl
+// keeps in its data store (only "tail" and "timestamps" are supported)
+func CtrLogs(ctx context.Context, container map[string]interface{}, stdout io.Writer, stderr io.Writer, opts LogsOptions) error {
+	id, _ := container["ID"].(string)
+	labels, _ := container["Labels"].(map[string]interface{})
+	uri, _ := labels["nerdctl/log-uri"].(string)
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	dataStore := u.Query().Get("_NERDCTL_INTERNAL_LOGGING")
+	ns := "default"
+	if args := namespaceArgs(); len(args) == 2 {
+		ns = args[1]
+	}
+	path := filepath.Join(dataStore, "containers", ns, id, id+"-json.log")
+	data, err := command(ctx, "cat", path).Output()
+	if err != nil {
+		return commandError(err)
+	}
+	lines := [][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), 16*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+	if tail, err := strconv.Atoi(opts.Tail); err == nil && tail >= 0 && tail < len(lines) {
+		lines = lines[len(lines)-tail:]
+	}
+	for _, line := range lines {
+		var entry struct {
+			Log    string `json:"log"`
+			Stream string `json:"stream"`
+			Time   string `json:"time"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		w := stdout
+		if entry.Stream == "stderr" {
+			w = stderr
+		}
+		text := entry.Log
+		if opts.Timestamps {
+			text = entry.Time + " " + text
+		}
+		if _, err := io.WriteString(w, text); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
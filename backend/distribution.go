@@ -0,0 +1,286 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Descriptor is the descriptor of the image manifest (or index), in the registry
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Platform is a platform that the image is available for
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	OSVersion    string `json:"os.version,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// registryClient talks to the registry v2 api, for a repository
+type registryClient struct {
+	base  string
+	repo  string
+	auth  *AuthConfig
+	token string
+	http  *http.Client
+}
+
+// parseReference splits the image name, into the registry, repository and tag (or digest)
+func parseReference(name string) (string, string, string) {
+	host := "docker.io"
+	if i := strings.Index(name, "/"); i > 0 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			host = first
+			name = name[i+1:]
+		}
+	}
+	ref := "latest"
+	if i := strings.Index(name, "@"); i > 0 {
+		name, ref = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref = name[:i], name[i+1:]
+	}
+	if host == "docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return host, name, ref
+}
+
+// authorize gets a token for the challenge, from the registry response
+func (r *registryClient) authorize(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if strings.EqualFold(scheme, "basic") {
+		r.token = ""
+		return nil
+	}
+	if !strings.EqualFold(scheme, "bearer") {
+		return fmt.Errorf("unsupported authentication: %s", scheme)
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			values[k] = strings.Trim(v, "\"")
+		}
+	}
+	query := url.Values{}
+	if service := values["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if r.repo != "" {
+		query.Set("scope", "repository:"+r.repo+":pull")
+	}
+	req, err := http.NewRequest(http.MethodGet, values["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	r.setBasicAuth(req)
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("authentication failed: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+	return nil
+}
+
+func (r *registryClient) setBasicAuth(req *http.Request) {
+	if r.auth == nil {
+		return
+	}
+	username, password := r.auth.Username, r.auth.Password
+	if r.auth.Auth != "" {
+		if data, err := base64.StdEncoding.DecodeString(r.auth.Auth); err == nil {
+			username, password, _ = strings.Cut(string(data), ":")
+		}
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+}
+
+// get fetches the path from the repository, authenticating when challenged
+func (r *registryClient) get(path string, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, r.base+"/v2/"+r.repo+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		} else if attempt > 0 {
+			r.setBasicAuth(req)
+		}
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			if err := r.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNotFound {
+				return nil, fmt.Errorf("manifest unknown: %s not found", r.repo)
+			}
+			return nil, fmt.Errorf("%s: %s", r.repo, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// registryBase returns the url of the registry api, for the host
+func registryBase(host string) string {
+	if host == "docker.io" {
+		return "https://registry-1.docker.io"
+	} else if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.") {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// Login checks the credentials with the registry, without storing them anywhere
+// (the client sends them again with every request, in the X-Registry-Auth header)
+func Login(auth *AuthConfig) error {
+	host := auth.ServerAddress
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "" || strings.HasSuffix(host, "docker.io") {
+		host = "docker.io"
+	}
+	r := &registryClient{base: registryBase(host), auth: auth, http: &http.Client{Timeout: time.Minute}}
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, r.base+"/v2/", nil)
+		if err != nil {
+			return err
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		} else if attempt > 0 {
+			r.setBasicAuth(req)
+		}
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			return fmt.Errorf("login failed: %s", resp.Status)
+		}
+		if attempt == 0 {
+			if err := r.authorize(resp.Header.Get("WWW-Authenticate")); err != nil {
+				break
+			}
+		}
+	}
+	return fmt.Errorf("unauthorized: incorrect username or password")
+}
+
+// DistributionInspect returns the descriptor of the image in the registry, and its platforms
+func DistributionInspect(name string, auth *AuthConfig) (Descriptor, []Platform, error) {
+	host, repo, ref := parseReference(name)
+	r := &registryClient{base: registryBase(host), repo: repo, auth: auth, http: &http.Client{Timeout: time.Minute}}
+	resp, err := r.get("/manifests/"+ref, manifestTypes)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Platform *Platform `json:"platform"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Descriptor{}, nil, err
+	}
+	desc := Descriptor{MediaType: manifest.MediaType, Digest: resp.Header.Get("Docker-Content-Digest"), Size: int64(len(data))}
+	if desc.MediaType == "" {
+		desc.MediaType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	}
+	if desc.Digest == "" {
+		desc.Digest = fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	}
+	platforms := []Platform{}
+	for _, m := range manifest.Manifests {
+		// skip the attestations, which are not for a platform
+		if m.Platform != nil && m.Platform.OS != "unknown" {
+			platforms = append(platforms, *m.Platform)
+		}
+	}
+	if manifest.Config.Digest != "" {
+		// a single manifest, so the platform is in the image config
+		resp, err := r.get("/blobs/"+manifest.Config.Digest, []string{"*/*"})
+		if err != nil {
+			return Descriptor{}, nil, err
+		}
+		defer resp.Body.Close()
+		var platform Platform
+		if err := json.NewDecoder(resp.Body).Decode(&platform); err != nil {
+			return Descriptor{}, nil, err
+		}
+		platforms = append(platforms, platform)
+	}
+	return desc, platforms, nil
+}
@@ -0,0 +1,46 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Events runs "nerdctl events" and calls fn for every containerd event, like:
+// {"Timestamp":"...","Namespace":"default","Topic":"/tasks/start","Event":"{...}"}
+// It returns when the command exits, or the context is done.
+func Events(ctx context.Context, fn func(event map[string]interface{})) error {
+	args := []string{"events", "--format", "{{json .}}"}
+	cmd := nerdctlCommandContext(ctx, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(stdout)
+	for {
+		var event map[string]interface{}
+		if err := decoder.Decode(&event); err != nil {
+			break
+		}
+		fn(event)
+	}
+	return cmd.Wait()
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// ExecOptions are the options for running a command in a container
+type ExecOptions struct {
+	Interactive bool
+	Tty         bool
+	Detach      bool
+	User        string
+	WorkingDir  string
+	Env         []string
+	Privileged  bool
+}
+
+// Exec returns the command, for running cmd in the container
+func Exec(container string, cmd []string, opts ExecOptions) *exec.Cmd {
+	args := []string{"exec"}
+	if opts.Interactive {
+		args = append(args, "-i")
+	}
+	if opts.Tty {
+		args = append(args, "-t")
+	}
+	if opts.Detach {
+		args = append(args, "-d")
+	}
+	if opts.User != "" {
+		args = append(args, "-u", opts.User)
+	}
+	if opts.WorkingDir != "" {
+		args = append(args, "-w", opts.WorkingDir)
+	}
+	for _, env := range opts.Env {
+		args = append(args, "-e", env)
+	}
+	if opts.Privileged {
+		args = append(args, "--privileged")
+	}
+	args = append(args, container)
+	args = append(args, cmd...)
+	return nerdctlCommand(args...)
+}
+
+// Attach returns the command, for attaching to the main process of the container
+func Attach(container string) *exec.Cmd {
+	args := []string{"attach", container}
+	return nerdctlCommand(args...)
+}
+
+// StartAttach returns the command, for starting the container attached to its stdio
+func StartAttach(ctx context.Context, container string) *exec.Cmd {
+	args := []string{"start", "--attach", container}
+	return nerdctlCommandContext(ctx, args...)
+}
+
+// StartTty starts the command with a new pseudo-terminal for stdio,
+// and returns the master side of the terminal for the caller to use.
+func StartTty(cmd *exec.Cmd) (*os.File, error) {
+	ptmx, pts, err := OpenPty()
+	if err != nil {
+		return nil, err
+	}
+	defer pts.Close()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = pts, pts, pts
+	cmd.SysProcAttr = ttyAttr()
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
@@ -0,0 +1,87 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MinNerdctlVersion is the oldest nerdctl, that has the commands and options used
+const MinNerdctlVersion = "1.0.0"
+
+// Features are the nerdctl versions that added the commands used by the endpoints,
+// for those that are newer than MinNerdctlVersion
+var Features = map[string]string{
+	"attach":           "1.6.0",
+	"rename":           "1.1.0",
+	"network-ipv6":     "1.1.0",
+	"network-internal": "2.0.0",
+	"domainname":       "2.0.0",
+}
+
+// installedVersion is the version of nerdctl, once it has been probed
+var installedVersion struct {
+	sync.Mutex
+	version string
+}
+
+// InstalledVersion returns the version of nerdctl, which is only probed once it runs
+func InstalledVersion() string {
+	installedVersion.Lock()
+	defer installedVersion.Unlock()
+	if installedVersion.version == "" {
+		installedVersion.version, _ = NerdctlVersion()
+	}
+	return installedVersion.version
+}
+
+// VersionLess compares two versions like "1.7.0", ignoring any suffix (like "-beta.1")
+func VersionLess(a string, b string) bool {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		nums := []int{}
+		for _, s := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(s)
+			nums = append(nums, n)
+		}
+		return nums
+	}
+	va, vb := parse(a), parse(b)
+	for i := 0; i < len(va) && i < len(vb); i++ {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+	return len(va) < len(vb)
+}
+
+// SupportsFeature checks that the installed nerdctl is new enough for the feature,
+// or assumes that it is when the version is not known (yet)
+func SupportsFeature(feature string) error {
+	required, ok := Features[feature]
+	if !ok {
+		return nil
+	}
+	version := InstalledVersion()
+	if version == "" || !VersionLess(version, required) {
+		return nil
+	}
+	return fmt.Errorf("%s requires nerdctl %s or later, but the installed version is %s", feature, required, version)
+}
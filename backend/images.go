@@ -0,0 +1,193 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/afbjorklund/nerdctld/stream"
+)
+
+func Images(filters ...string) ([]map[string]interface{}, error) {
+	args := []string{"images"}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	args = append(args, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return decodeObjects(nc)
+}
+
+func Image(name string) (map[string]interface{}, error) {
+	args := []string{"image", "inspect", "--mode", "dockercompat"}
+	args = append(args, name, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	// TODO: handle both one or many
+	nc = bytes.Split(nc, []byte{'\n'})[0]
+	var image map[string]interface{}
+	err = json.Unmarshal(nc, &image)
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func History(name string) ([]map[string]interface{}, error) {
+	args := []string{"history", "--human=false"}
+	args = append(args, name, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	history, err := decodeObjects(nc)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func Tag(source string, target string) error {
+	args := []string{"tag"}
+	args = append(args, source)
+	args = append(args, target)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// Pull pulls the image (for the platform, if not empty), using the credentials
+// (if not nil) instead of the default
+func Pull(name string, platform string, auth *AuthConfig, sw *stream.Writer) error {
+	args := []string{"pull"}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	args = append(args, name)
+	cmd, cleanup, err := nerdctlAuthCommand(name, auth, args...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return stream.Command(cmd, sw)
+}
+
+// Push pushes the image, using the credentials (if not nil) instead of the default
+func Push(name string, auth *AuthConfig, sw *stream.Writer) error {
+	args := []string{"push"}
+	args = append(args, name)
+	cmd, cleanup, err := nerdctlAuthCommand(name, auth, args...)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return stream.Command(cmd, sw)
+}
+
+func Load(quiet bool, r io.Reader, sw *stream.Writer) error {
+	args := []string{"load"}
+	cmd := nerdctlCommand(args...)
+	cmd.Stdin = r
+	return stream.Command(cmd, sw)
+}
+
+// Save writes the images as a tar archive, streaming it as it is written.
+// The format is "docker" (the default, when empty) or "oci" for an OCI image layout.
+func Save(ctx context.Context, names []string, format string, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	args := []string{"save"}
+	if format != "" && format != "docker" {
+		args = append(args, "--format", format)
+	}
+	args = append(args, names...)
+	cmd := nerdctlCommandContext(ctx, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(w, stdout)
+	if copyErr != nil {
+		// the client is gone (like a broken pipe), so kill save instead of it blocking on the pipe
+		cancel()
+	}
+	err = cmd.Wait()
+	if copyErr != nil {
+		return copyErr
+	}
+	if err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s", strings.TrimSpace(stderr.String()))
+		}
+		return err
+	}
+	return nil
+}
+
+func Rmi(name string, w io.Writer) error {
+	args := []string{"rmi"}
+	args = append(args, name)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return err
+	}
+	removed := []map[string]string{}
+	lines := strings.Split(string(nc), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Untagged: ") {
+			image := strings.Replace(line, "Untagged: ", "", 1)
+			removed = append(removed, map[string]string{"Untagged": image})
+		} else if strings.HasPrefix(line, "Deleted:") {
+			image := strings.Replace(line, "Deleted: ", "", 1)
+			removed = append(removed, map[string]string{"Deleted": image})
+		}
+	}
+	d, _ := json.Marshal(removed)
+	_, err = w.Write(d)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PruneImages removes dangling (or, with all, unused) images, and returns their names
+func PruneImages(all bool) ([]string, error) {
+	args := []string{"image", "prune", "--force"}
+	if all {
+		args = append(args, "--all")
+	}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return prunedNames(nc), nil
+}
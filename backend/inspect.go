@@ -0,0 +1,127 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// inspectCache is a small LRU cache of inspect results, keyed by ID and update
+type inspectCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key   string
+	value map[string]interface{}
+}
+
+func newInspectCache(size int) *inspectCache {
+	return &inspectCache{size: size, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *inspectCache) get(key string) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*cacheEntry).value, true
+	}
+	return nil, false
+}
+
+func (c *inspectCache) add(key string, value map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*cacheEntry).value = value
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value})
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*cacheEntry).key)
+	}
+}
+
+var containerCache = newInspectCache(1024)
+var imageCache = newInspectCache(1024)
+
+// inspectBatch is the maximum number of objects to inspect with one command
+const inspectBatch = 256
+
+// inspectAll inspects all the objects, using as few commands as possible.
+// The updated strings change whenever the object changes, for the cache.
+func inspectAll(kind string, cache *inspectCache, updated map[string]string) (map[string]map[string]interface{}, error) {
+	result := map[string]map[string]interface{}{}
+	missing := []string{}
+	for id, upd := range updated {
+		if value, ok := cache.get(id + "@" + upd); ok {
+			result[id] = value
+			continue
+		}
+		missing = append(missing, id)
+	}
+	for len(missing) > 0 {
+		n := len(missing)
+		if n > inspectBatch {
+			n = inspectBatch
+		}
+		batch := missing[:n]
+		missing = missing[n:]
+		args := []string{kind, "inspect", "--mode", "dockercompat"}
+		args = append(args, batch...)
+		args = append(args, "--format", "{{json .}}")
+		// objects removed meanwhile fail, but the others are still printed
+		nc, _ := nerdctlCommand(args...).Output()
+		objects, err := decodeObjects(nc)
+		if err != nil {
+			return nil, fmt.Errorf("%s inspect: %w", kind, err)
+		}
+		for _, object := range objects {
+			full, _ := object["Id"].(string)
+			full = strings.TrimPrefix(full, "sha256:")
+			for _, id := range batch {
+				if strings.HasPrefix(full, strings.TrimPrefix(id, "sha256:")) {
+					result[id] = object
+					cache.add(id+"@"+updated[id], object)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// InspectContainers inspects many containers at once, with the ID mapped to a string
+// that changes whenever the container does (like the time of its last event), for caching.
+// The returned objects are shared with the cache, and must not be modified.
+func InspectContainers(updated map[string]string) (map[string]map[string]interface{}, error) {
+	return inspectAll("container", containerCache, updated)
+}
+
+// InspectImages inspects many images at once, see InspectContainers.
+func InspectImages(updated map[string]string) (map[string]map[string]interface{}, error) {
+	return inspectAll("image", imageCache, updated)
+}
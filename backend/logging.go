@@ -0,0 +1,198 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// LogConfig is the logging config of a container, like nerdctl keeps it in log-config.json
+type LogConfig struct {
+	Driver string            `json:"driver"`
+	Opts   map[string]string `json:"opts,omitempty"`
+}
+
+// ContainerLogConfig returns the logging config of the container, from the log config that
+// nerdctl keeps in its data store (which is in the "nerdctl/log-uri" label), where the
+// driver is "json-file" when it is not logging with nerdctl
+func ContainerLogConfig(container map[string]interface{}) LogConfig {
+	defaultConfig := LogConfig{Driver: "json-file"}
+	config, _ := container["Config"].(map[string]interface{})
+	labels, _ := config["Labels"].(map[string]interface{})
+	uri, _ := labels["nerdctl/log-uri"].(string)
+	id, _ := container["Id"].(string)
+	u, err := url.Parse(uri)
+	if err != nil || id == "" {
+		return defaultConfig
+	}
+	dataStore := u.Query().Get("_NERDCTL_INTERNAL_LOGGING")
+	if dataStore == "" {
+		// not logging with nerdctl, like to a file
+		return defaultConfig
+	}
+	ns := "default"
+	if args := namespaceArgs(); len(args) == 2 {
+		ns = args[1]
+	}
+	path := filepath.Join(dataStore, "containers", ns, id, "log-config.json")
+	data, err := command(context.Background(), "cat", path).Output()
+	if err != nil {
+		return defaultConfig
+	}
+	var logConfig LogConfig
+	if err := json.Unmarshal(data, &logConfig); err != nil || logConfig.Driver == "" {
+		return defaultConfig
+	}
+	return logConfig
+}
+
+// LogsReadable checks if the logs can be read, for the logging driver
+func LogsReadable(driver string) bool {
+	switch driver {
+	case "", "json-file", "journald":
+		return true
+	}
+	return false
+}
+
+// journalTime returns the time for journalctl, which doesn't take RFC 3339
+func journalTime(s string) string {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return fmt.Sprintf("@%d", t.Unix())
+	}
+	return s
+}
+
+// journalIdentifier returns the SYSLOG_IDENTIFIER of the journal entries of the container,
+// which is the short ID unless the "tag" option is set (a template, like for nerdctl)
+func journalIdentifier(id string, tag string) (string, error) {
+	shortID := id
+	if len(shortID) > 12 {
+		shortID = shortID[:12]
+	}
+	if tag == "" {
+		return shortID, nil
+	}
+	tmpl, err := template.New("tag").Parse(tag)
+	if err != nil {
+		return "", fmt.Errorf("invalid log tag %q: %w", tag, err)
+	}
+	var b strings.Builder
+	info := struct {
+		ID        string
+		FullID    string
+		Namespace string
+	}{ID: shortID, FullID: id, Namespace: Namespace()}
+	if err := tmpl.Execute(&b, info); err != nil {
+		return "", fmt.Errorf("invalid log tag %q: %w", tag, err)
+	}
+	return b.String(), nil
+}
+
+// journalArgs returns the journalctl arguments, for the logs of the container
+func journalArgs(id string, opts LogsOptions) ([]string, error) {
+	identifier, err := journalIdentifier(id, opts.Tag)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--no-pager", "--output", "json", "--all"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Tail != "" && opts.Tail != "all" {
+		args = append(args, "--lines", opts.Tail)
+	}
+	if opts.Since != "" && opts.Since != "0" {
+		args = append(args, "--since", journalTime(opts.Since))
+	}
+	if opts.Until != "" && opts.Until != "0" {
+		args = append(args, "--until", journalTime(opts.Until))
+	}
+	args = append(args, "SYSLOG_IDENTIFIER="+identifier)
+	return args, nil
+}
+
+// journalLogs writes the logs of the container from the journal, where nerdctl writes the
+// entries with the SYSLOG_IDENTIFIER field and the stderr lines with priority 3
+func journalLogs(ctx context.Context, id string, stdout io.Writer, stderr io.Writer, opts LogsOptions) error {
+	args, err := journalArgs(id, opts)
+	if err != nil {
+		return err
+	}
+	cmd := command(ctx, "journalctl", args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), 16*1024*1024)
+	for scanner.Scan() {
+		var entry struct {
+			Message   json.RawMessage `json:"MESSAGE"`
+			Priority  string          `json:"PRIORITY"`
+			Timestamp string          `json:"__REALTIME_TIMESTAMP"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		// the message is an array of bytes, when it is not valid UTF-8
+		var message string
+		if err := json.Unmarshal(entry.Message, &message); err != nil {
+			var data []byte
+			var values []int
+			if json.Unmarshal(entry.Message, &values) != nil {
+				continue
+			}
+			for _, v := range values {
+				data = append(data, byte(v))
+			}
+			message = string(data)
+		}
+		line := message + "\n"
+		if opts.Timestamps {
+			usec, _ := strconv.ParseInt(entry.Timestamp, 10, 64)
+			line = time.UnixMicro(usec).UTC().Format(time.RFC3339Nano) + " " + line
+		}
+		w := stdout
+		if entry.Priority == "3" {
+			w = stderr
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			break
+		}
+	}
+	_ = cmd.Process.Kill()
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		// the client went away
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var namespace struct {
+	sync.RWMutex
+	name string
+}
+
+// containerd namespaces are like labels, starting with a letter or number
+var reNamespace = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// Namespace returns the containerd namespace, that the commands run in
+func Namespace() string {
+	namespace.RLock()
+	defer namespace.RUnlock()
+	if namespace.name != "" {
+		return namespace.name
+	}
+	if ns := os.Getenv("CONTAINERD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "default"
+}
+
+// SetNamespace changes the containerd namespace, for all the following commands
+func SetNamespace(name string) error {
+	if !reNamespace.MatchString(name) {
+		return fmt.Errorf("invalid namespace: %q", name)
+	}
+	namespace.Lock()
+	defer namespace.Unlock()
+	namespace.name = name
+	return nil
+}
+
+// namespaceArgs returns the global nerdctl option, for the namespace that was set
+func namespaceArgs() []string {
+	namespace.RLock()
+	defer namespace.RUnlock()
+	if namespace.name == "" {
+		return nil
+	}
+	return []string{"--namespace", namespace.name}
+}
+
+// Namespaces returns the containerd namespaces, with the number of objects in them, like:
+// {"Name":"default","Containers":2,"Images":5,"Volumes":1,"Labels":""}
+func Namespaces() ([]map[string]interface{}, error) {
+	nc, err := nerdctlCommand("namespace", "ls").Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	namespaces := []map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(nc))
+	// NAME    CONTAINERS    IMAGES    VOLUMES    LABELS
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[0] == "NAME" {
+			continue
+		}
+		ns := map[string]interface{}{"Name": fields[0], "Labels": strings.Join(fields[4:], " ")}
+		for i, key := range []string{"Containers", "Images", "Volumes"} {
+			n, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("namespace %s: %w", fields[0], err)
+			}
+			ns[key] = n
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
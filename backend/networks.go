@@ -0,0 +1,165 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+)
+
+func Networks(filters []string) ([]map[string]interface{}, error) {
+	args := []string{"network", "ls"}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	args = append(args, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return decodeObjects(nc)
+}
+
+func Network(name string) (map[string]interface{}, error) {
+	args := []string{"network", "inspect"}
+	args = append(args, name, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s", exiterr.Stderr)
+		}
+		return nil, err
+	}
+	var network map[string]interface{}
+	err = json.Unmarshal(nc, &network)
+	if err != nil {
+		return nil, err
+	}
+	return network, nil
+}
+
+// InspectNetworks returns the inspect of the networks, with one command
+func InspectNetworks(names []string) ([]map[string]interface{}, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"network", "inspect", "--format", "{{json .}}"}, names...)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return decodeObjects(nc)
+}
+
+// cniPaths are the default directories of the CNI plugins, for nerdctl (also in rootless mode)
+var cniPaths = []string{"/opt/cni/bin", "/usr/local/libexec/cni", "/usr/libexec/cni", "/usr/local/lib/cni", "/usr/lib/cni"}
+
+// CNIPlugins returns the names of the installed CNI plugins, on the containerd host,
+// from $CNI_PATH (like nerdctl) and from the default directories
+func CNIPlugins() ([]string, error) {
+	dirs := []string{"${CNI_PATH:-}", "\"$HOME/.local/libexec/cni\""}
+	for _, dir := range cniPaths {
+		dirs = append(dirs, shellQuote(dir))
+	}
+	script := "for d in " + strings.Join(dirs, " ") + "; do [ -d \"$d\" ] && ls \"$d\"; done; true"
+	nc, err := command(context.Background(), "/bin/sh", "-c", script).Output()
+	if err != nil {
+		return nil, err
+	}
+	plugins := []string{}
+	for _, name := range strings.Fields(string(nc)) {
+		if !slices.Contains(plugins, name) {
+			plugins = append(plugins, name)
+		}
+	}
+	sort.Strings(plugins)
+	return plugins, nil
+}
+
+// NetworkOptions are the options for creating a network
+type NetworkOptions struct {
+	Driver     string
+	IPAMDriver string
+	Subnets    []string
+	Gateway    string
+	IPRange    string
+	IPv6       bool
+	Internal   bool
+	Labels     map[string]string
+	Options    map[string]string
+}
+
+// CreateNetwork creates a network, and returns the ID
+func CreateNetwork(name string, opts NetworkOptions) (string, error) {
+	args := []string{"network", "create"}
+	if opts.Driver != "" {
+		args = append(args, "--driver", opts.Driver)
+	}
+	if opts.IPAMDriver != "" {
+		args = append(args, "--ipam-driver", opts.IPAMDriver)
+	}
+	for _, subnet := range opts.Subnets {
+		args = append(args, "--subnet", subnet)
+	}
+	if opts.Gateway != "" {
+		args = append(args, "--gateway", opts.Gateway)
+	}
+	if opts.IPRange != "" {
+		args = append(args, "--ip-range", opts.IPRange)
+	}
+	if opts.IPv6 {
+		args = append(args, "--ipv6")
+	}
+	if opts.Internal {
+		args = append(args, "--internal")
+	}
+	for k, v := range opts.Labels {
+		args = append(args, "--label", k+"="+v)
+	}
+	for k, v := range opts.Options {
+		args = append(args, "--opt", k+"="+v)
+	}
+	args = append(args, name)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	return strings.TrimSpace(string(nc)), nil
+}
+
+// RemoveNetwork removes the network
+func RemoveNetwork(name string) error {
+	args := []string{"network", "rm", name}
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// PruneNetworks removes all unused networks, and returns their names
+func PruneNetworks() ([]string, error) {
+	args := []string{"network", "prune", "-f"}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return prunedNames(nc), nil
+}
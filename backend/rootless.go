@@ -0,0 +1,47 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Rootless returns whether nerdctl runs as a user, with containerd in RootlessKit
+func Rootless() bool {
+	if len(RemoteCommand) == 0 {
+		return os.Geteuid() != 0
+	}
+	nc, err := command(context.Background(), "id", "-u").Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(nc)) != "0"
+}
+
+// UnprivilegedPortStart returns the first port that users can bind,
+// from the "net.ipv4.ip_unprivileged_port_start" sysctl (default 1024)
+func UnprivilegedPortStart() (int, error) {
+	path := "/proc/sys/net/ipv4/ip_unprivileged_port_start"
+	nc, err := command(context.Background(), "cat", path).Output()
+	if err != nil {
+		return 0, commandError(err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(nc)))
+}
@@ -0,0 +1,165 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stats returns a sample of the resource usage of the container, like:
+// {"BlockIO":"0B / 0B","CPUPerc":"0.00%","MemUsage":"1MiB / 1GiB","NetIO":"0B / 0B","PIDs":"1"}
+func Stats(name string) (map[string]interface{}, error) {
+	args := []string{"stats", "--no-stream", "--format", "{{json .}}", name}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	stats, err := decodeObjects(nc)
+	if err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("no stats for %s", name)
+	}
+	return stats[0], nil
+}
+
+// BlkioEntry is the block io of a device, for an operation like "Read"
+type BlkioEntry struct {
+	Major uint64
+	Minor uint64
+	Op    string
+	Value uint64
+}
+
+// NetworkStats is the traffic of a network interface
+type NetworkStats struct {
+	RxBytes, RxPackets, RxErrors, RxDropped uint64
+	TxBytes, TxPackets, TxErrors, TxDropped uint64
+}
+
+// CgroupStats is the resource usage of a container, read from its cgroup (times in nanoseconds)
+type CgroupStats struct {
+	CPUUsage         uint64
+	PercpuUsage      []uint64
+	CPUUser          uint64
+	CPUSystem        uint64
+	SystemUsage      uint64
+	OnlineCPUs       uint32
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    uint64
+	MemoryUsage      uint64
+	MemoryMaxUsage   uint64
+	MemoryLimit      uint64
+	MemoryStats      map[string]uint64
+	Pids             uint64
+	PidsLimit        uint64
+	IoServiceBytes   []BlkioEntry
+	IoServiced       []BlkioEntry
+	Networks         map[string]NetworkStats
+}
+
+// parseDevice parses a "major:minor" device
+func parseDevice(s string) (uint64, uint64, bool) {
+	major, minor, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, false
+	}
+	a, err1 := strconv.ParseUint(major, 10, 64)
+	b, err2 := strconv.ParseUint(minor, 10, 64)
+	return a, b, err1 == nil && err2 == nil
+}
+
+// parseIoStat parses the cgroup v2 io.stat, into the bytes and the operations of each device,
+// like "8:0 rbytes=1024 wbytes=0 rios=1 wios=0 dbytes=0 dios=0"
+func parseIoStat(data []byte) ([]BlkioEntry, []BlkioEntry) {
+	bytes, serviced := []BlkioEntry{}, []BlkioEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		major, minor, ok := parseDevice(fields[0])
+		if !ok {
+			continue
+		}
+		io := map[string]uint64{}
+		for _, field := range fields[1:] {
+			k, v, _ := strings.Cut(field, "=")
+			io[k], _ = strconv.ParseUint(v, 10, 64)
+		}
+		bytes = append(bytes,
+			BlkioEntry{major, minor, "read", io["rbytes"]}, BlkioEntry{major, minor, "write", io["wbytes"]})
+		serviced = append(serviced,
+			BlkioEntry{major, minor, "read", io["rios"]}, BlkioEntry{major, minor, "write", io["wios"]})
+	}
+	return bytes, serviced
+}
+
+// parseNetDev parses /proc/net/dev, into the traffic of each interface (except for loopback)
+func parseNetDev(data []byte) map[string]NetworkStats {
+	networks := map[string]NetworkStats{}
+	lines := strings.Split(string(data), "\n")
+	if len(lines) < 2 {
+		return networks
+	}
+	// the first two lines are headers
+	for _, line := range lines[2:] {
+		name, counters, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			continue
+		}
+		n := make([]uint64, 16)
+		for i := range n {
+			n[i], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		networks[name] = NetworkStats{
+			RxBytes: n[0], RxPackets: n[1], RxErrors: n[2], RxDropped: n[3],
+			TxBytes: n[8], TxPackets: n[9], TxErrors: n[10], TxDropped: n[11]}
+	}
+	return networks
+}
+
+// deviceStatsSeparator separates the net/dev and io.stat, in the output of DeviceStats
+const deviceStatsSeparator = "--- io.stat"
+
+// DeviceStats returns the traffic of each network interface and the block io of each device,
+// for the process (the container task) on the containerd host, also when it is remote.
+// The block io is only read for cgroup v2, where the cgroup is the same for all controllers.
+func DeviceStats(pid int) (map[string]NetworkStats, []BlkioEntry, []BlkioEntry, error) {
+	if pid <= 0 {
+		return nil, nil, nil, fmt.Errorf("no process")
+	}
+	script := fmt.Sprintf("cat /proc/%d/net/dev; echo '%s'; cat \"/sys/fs/cgroup$(sed -n 's/^0:://p' /proc/%d/cgroup)/io.stat\" 2>/dev/null; true",
+		pid, deviceStatsSeparator, pid)
+	nc, err := command(context.Background(), "/bin/sh", "-c", script).Output()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	netdev, iostat, _ := strings.Cut(string(nc), deviceStatsSeparator+"\n")
+	bytes, serviced := parseIoStat([]byte(iostat))
+	return parseNetDev([]byte(netdev)), bytes, serviced, nil
+}
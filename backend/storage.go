@@ -0,0 +1,94 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// snapshotters are the names of the known containerd snapshotters
+var snapshotters = []string{"overlayfs", "native", "stargz", "fuse-overlayfs", "btrfs", "zfs", "devmapper", "nydus", "soci"}
+
+// Snapshotter returns the full name of the snapshotter, for when the driver name was truncated ("over")
+func Snapshotter(driver string) string {
+	if driver == "" {
+		return "overlayfs"
+	}
+	for _, name := range snapshotters {
+		if name == driver {
+			return name
+		}
+	}
+	for _, name := range snapshotters {
+		if strings.HasPrefix(name, driver) {
+			return name
+		}
+	}
+	return driver
+}
+
+// snapshotterDir returns the directory of the containerd snapshotter (for the user, when rootless)
+func snapshotterDir(driver string) string {
+	name := "io.containerd.snapshotter.v1." + driver
+	if Rootless() {
+		return `"${XDG_DATA_HOME:-$HOME/.local/share}/containerd/` + name + `"`
+	}
+	return "/var/lib/containerd/" + name
+}
+
+// backingFilesystem returns the filesystem of the directory, like docker shows it ("extfs", "xfs", ...)
+func backingFilesystem(dir string) (string, error) {
+	nc, err := command(context.Background(), "/bin/sh", "-c", "stat -f -c %T "+dir).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	fs := strings.TrimSpace(string(nc))
+	if fs == "ext2/ext3" {
+		fs = "extfs"
+	}
+	return fs, nil
+}
+
+// supportsDType checks that the filesystem reports the file types (which overlay needs),
+// which is only optional for xfs (ftype=1)
+func supportsDType(fs string, dir string) bool {
+	if fs != "xfs" {
+		return true
+	}
+	nc, err := command(context.Background(), "/bin/sh", "-c", "xfs_info "+dir).Output()
+	if err != nil {
+		return true
+	}
+	return !strings.Contains(string(nc), "ftype=0")
+}
+
+// DriverStatus returns the status of the storage driver (the containerd snapshotter), like docker
+func DriverStatus(driver string) [][2]string {
+	status := [][2]string{{"driver-type", "io.containerd.snapshotter.v1"}}
+	dir := snapshotterDir(driver)
+	fs, err := backingFilesystem(dir)
+	if err != nil {
+		return status
+	}
+	status = append(status, [2]string{"Backing Filesystem", fs})
+	if driver == "overlayfs" {
+		status = append(status, [2]string{"Supports d_type", fmt.Sprint(supportsDType(fs, dir))})
+	}
+	return status
+}
@@ -0,0 +1,225 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+func NerdctlVersion() (string, map[string]string) {
+	nv, err := nerdctlCommand("--version").Output()
+	if err != nil {
+		// log stderr for basic troubleshooting
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			log.Print(string(exiterr.Stderr))
+		}
+		log.Print(err)
+		return "", nil
+	}
+	v := strings.TrimSuffix(string(nv), "\n")
+	v = strings.Replace(v, "nerdctl version ", "", 1)
+	return v, nil
+}
+
+func ContainerdVersion() (string, map[string]string) {
+	nv, err := command(context.Background(), "containerd", "--version").Output()
+	if err != nil {
+		log.Print(err)
+		return "", nil
+	}
+	v := strings.TrimSuffix(string(nv), "\n")
+	// containerd github.com/containerd/containerd Version GitCommit
+	c := strings.SplitN(v, " ", 4)
+	if len(c) == 4 && c[0] == "containerd" {
+		v = strings.Replace(c[2], "v", "", 1)
+		if c[3] != "" {
+			return v, map[string]string{"GitCommit": c[3]}
+		}
+	}
+	return v, nil
+}
+
+func CtrVersion() (string, map[string]string) {
+	nv, err := command(context.Background(), "ctr", "--version").Output()
+	if err != nil {
+		log.Print(err)
+		return "", nil
+	}
+	v := strings.TrimSuffix(string(nv), "\n")
+	// ctr github.com/containerd/containerd Version GitCommit
+	c := strings.SplitN(v, " ", 4)
+	if len(c) == 4 && c[0] == "ctr" {
+		v = strings.Replace(c[2], "v", "", 1)
+		if c[3] != "" {
+			return v, map[string]string{"GitCommit": c[3]}
+		}
+	}
+	return v, nil
+}
+
+func BuildctlVersion() (string, map[string]string) {
+	nv, err := command(context.Background(), Buildctl, "--version").Output()
+	if err != nil {
+		log.Print(err)
+		return "", nil
+	}
+	v := strings.TrimSuffix(string(nv), "\n")
+	// buildctl github.com/moby/buildkit Version GitCommit
+	c := strings.SplitN(v, " ", 4)
+	if len(c) == 4 && c[0] == "buildctl" {
+		v = strings.Replace(c[2], "v", "", 1)
+		if c[3] != "" {
+			return v, map[string]string{"GitCommit": c[3]}
+		}
+	}
+	return v, nil
+}
+
+func RuncVersion() (string, map[string]string) {
+	nv, err := command(context.Background(), "runc", "--version").Output()
+	if err != nil {
+		log.Print(err)
+		return "", nil
+	}
+	l := strings.Split(string(nv), "\n")
+	if len(l) == 0 {
+		return "", nil
+	}
+	// runc version Version
+	v := strings.Replace(l[0], "runc version ", "", 1)
+	if len(l) > 1 && strings.HasPrefix(l[1], "commit: ") {
+		s := strings.Replace(l[1], "commit: ", "", 1)
+		if strings.Contains(s, "g") {
+			s = strings.Split(s, "g")[1]
+		}
+		return v, map[string]string{"GitCommit": s}
+	}
+	return v, nil
+}
+
+func TiniVersion() (string, map[string]string) {
+	nv, err := command(context.Background(), "tini", "--version").Output()
+	if err != nil {
+		// tini is optional (--init-binary)
+		return "", nil
+	}
+	v := strings.TrimSuffix(string(nv), "\n")
+	// tini version Version - git.da39a3e
+	v = strings.Replace(v, "tini version ", "", 1)
+	c := strings.SplitN(v, " ", 3)
+	if len(c) == 3 && c[1] == "-" {
+		v = c[0]
+		s := strings.Replace(c[2], "git.", "", 1)
+		return v, map[string]string{"GitCommit": s}
+	}
+	return v, nil
+}
+
+func Version() (map[string]interface{}, error) {
+	nc, err := nerdctlCommand("version", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	var version map[string]interface{}
+	err = json.Unmarshal(nc, &version)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+type ComponentVersion struct {
+	Name    string
+	Version string
+	Details map[string]string `json:",omitempty"`
+}
+
+func Components() []ComponentVersion {
+	var cmp []ComponentVersion
+	version, details := NerdctlVersion()
+	cmp = append(cmp, ComponentVersion{Name: "nerdctl", Version: version, Details: details})
+	if version, details := BuildctlVersion(); version != "" {
+		cmp = append(cmp, ComponentVersion{Name: "buildctl", Version: version, Details: details})
+	}
+	if version, details = ContainerdVersion(); version != "" {
+		cmp = append(cmp, ComponentVersion{Name: "containerd", Version: version, Details: details})
+	} else if version, details = CtrVersion(); version != "" { // use client version as fallback
+		cmp = append(cmp, ComponentVersion{Name: "containerd", Version: version, Details: details})
+	}
+	if version, details := RuncVersion(); version != "" {
+		cmp = append(cmp, ComponentVersion{Name: "runc", Version: version, Details: details})
+	}
+	if version, details := TiniVersion(); version != "" { // renamed to "docker-init" in docker
+		cmp = append(cmp, ComponentVersion{Name: "tini", Version: version, Details: details})
+	}
+	return cmp
+}
+
+type clientVersion struct {
+	Version    string
+	GitCommit  string
+	GoVersion  string
+	Os         string // GOOS
+	Arch       string // GOARCH
+	Components []ComponentVersion
+}
+
+type serverVersion struct {
+	Components []ComponentVersion
+}
+
+type versionInfo struct {
+	Client clientVersion
+	Server serverVersion
+}
+
+func RemoteComponents() []ComponentVersion {
+	var cmp []ComponentVersion
+	nc, err := nerdctlCommand("version", "--format", "{{json .}}").Output()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	var version versionInfo
+	err = json.Unmarshal(nc, &version)
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	cmp = append(cmp, ComponentVersion{Name: "nerdctl", Version: version.Client.Version})
+	cmp = append(cmp, version.Client.Components...)
+	cmp = append(cmp, version.Server.Components...)
+	return cmp
+}
+
+func Info() (map[string]interface{}, error) {
+	nc, err := nerdctlCommand("info", "--format", "{{json .}}").Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	var info map[string]interface{}
+	err = json.Unmarshal(nc, &info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
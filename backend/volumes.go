@@ -0,0 +1,122 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package backend
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+func Volumes(filters []string) ([]map[string]interface{}, error) {
+	args := []string{"volume", "ls"}
+	for _, filter := range filters {
+		args = append(args, "--filter", filter)
+	}
+	args = append(args, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return decodeObjects(nc)
+}
+
+func Volume(name string) (map[string]interface{}, error) {
+	args := []string{"volume", "inspect"}
+	args = append(args, name, "--format", "{{json .}}")
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var volume map[string]interface{}
+	err = json.Unmarshal(nc, &volume)
+	if err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// InspectVolumes returns the inspect of the volumes, with one command
+func InspectVolumes(names []string) ([]map[string]interface{}, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"volume", "inspect", "--format", "{{json .}}"}, names...)
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return decodeObjects(nc)
+}
+
+// VolumeSizes returns the size of the volumes (that exist), by name
+func VolumeSizes(names []string) map[string]int64 {
+	sizes := map[string]int64{}
+	if len(names) == 0 {
+		return sizes
+	}
+	args := append([]string{"volume", "inspect", "--size", "--format", "{{json .}}"}, names...)
+	// the volumes that don't exist are errors, but the others are still printed
+	nc, _ := nerdctlCommand(args...).Output()
+	volumes, _ := decodeObjects(nc)
+	for _, volume := range volumes {
+		name, _ := volume["Name"].(string)
+		size, _ := volume["Size"].(float64)
+		sizes[name] = int64(size)
+	}
+	return sizes
+}
+
+// CreateVolume creates a volume, and returns the name (generated, if empty)
+func CreateVolume(name string, labels map[string]string) (string, error) {
+	args := []string{"volume", "create"}
+	for k, v := range labels {
+		args = append(args, "--label", k+"="+v)
+	}
+	if name != "" {
+		args = append(args, name)
+	}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return "", commandError(err)
+	}
+	return strings.TrimSpace(string(nc)), nil
+}
+
+// RemoveVolume removes the volume
+func RemoveVolume(name string, force bool) error {
+	args := []string{"volume", "rm"}
+	if force {
+		args = append(args, "-f")
+	}
+	args = append(args, name)
+	_, err := nerdctlCommand(args...).Output()
+	return commandError(err)
+}
+
+// PruneVolumes removes all unused volumes, and returns their names
+func PruneVolumes(all bool) ([]string, error) {
+	args := []string{"volume", "prune", "-f"}
+	if all {
+		args = append(args, "-a")
+	}
+	nc, err := nerdctlCommand(args...).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return prunedNames(nc), nil
+}
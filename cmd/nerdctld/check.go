@@ -0,0 +1,179 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/afbjorklund/nerdctld"
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/spf13/cobra"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the environment, before running the daemon",
+	Long: `Checks that nerdctl can be run and can reach containerd, that nerdctl is not too old,
+that buildkitd is reachable for builds, the cgroup configuration and the socket permissions.
+
+It prints a diagnosis for each, with the problems that need fixing marked FAIL.`,
+	Args: cobra.NoArgs,
+	RunE: check,
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
+
+// checkResult is the diagnosis of a check, with the level "OK", "WARN" or "FAIL"
+type checkResult struct {
+	level  string
+	detail string
+}
+
+func checkOK(format string, a ...interface{}) checkResult {
+	return checkResult{"OK", fmt.Sprintf(format, a...)}
+}
+
+func checkWarn(format string, a ...interface{}) checkResult {
+	return checkResult{"WARN", fmt.Sprintf(format, a...)}
+}
+
+func checkFail(format string, a ...interface{}) checkResult {
+	return checkResult{"FAIL", fmt.Sprintf(format, a...)}
+}
+
+func checkNerdctl() checkResult {
+	path, err := backend.LookPath(backend.Nerdctl)
+	if err != nil {
+		return checkFail("%v", err)
+	}
+	version := backend.InstalledVersion()
+	if version == "" {
+		return checkFail("%s does not run", path)
+	}
+	if backend.VersionLess(version, backend.MinNerdctlVersion) {
+		return checkWarn("%s is version %s, older than %s", path, version, backend.MinNerdctlVersion)
+	}
+	features := []string{}
+	for feature := range backend.Features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	for _, feature := range features {
+		if required := backend.Features[feature]; backend.VersionLess(version, required) {
+			return checkWarn("%s is version %s, %s requires %s", path, version, feature, required)
+		}
+	}
+	return checkOK("%s is version %s", path, version)
+}
+
+func checkContainerd(info map[string]interface{}, err error) checkResult {
+	if err != nil {
+		return checkFail("cannot connect: %v", err)
+	}
+	version, _ := info["ServerVersion"].(string)
+	return checkOK("connected, version %s", version)
+}
+
+func checkCgroups(info map[string]interface{}, err error) checkResult {
+	if err != nil {
+		return checkWarn("unknown, without containerd")
+	}
+	driver, _ := info["CgroupDriver"].(string)
+	version, _ := info["CgroupVersion"].(string)
+	switch {
+	case driver == "none" || driver == "":
+		return checkWarn("no cgroup driver, so the resource limits and stats are not available")
+	case version == "1":
+		return checkWarn("cgroup v1 with %s, use cgroup v2 for rootless limits", driver)
+	}
+	return checkOK("cgroup v%s with %s", version, driver)
+}
+
+func checkBuildkit() checkResult {
+	path, err := backend.LookPath(backend.Buildctl)
+	if err != nil {
+		return checkWarn("%v, so builds are not available", err)
+	}
+	worker := backend.BuildWorker()
+	if worker == "" {
+		return checkWarn("buildkitd is not reachable with %s, so builds are not available", path)
+	}
+	return checkOK("buildkitd is reachable, with the %s worker", worker)
+}
+
+// checkSocket checks that the socket can be created, and is not in use already
+func checkSocket(address string) checkResult {
+	path, ok := strings.CutPrefix(address, "unix://")
+	if !ok || strings.HasPrefix(path, "@") {
+		return checkOK("%s is not a socket file", address)
+	}
+	dir := filepath.Dir(path)
+	if err := syscall.Access(dir, 2 /* W_OK */); err != nil {
+		return checkFail("cannot create %s: %v", path, err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return checkWarn("%s is in use, by a running daemon", path)
+		}
+		return checkOK("%s is left over, and will be replaced", path)
+	}
+	return checkOK("%s can be created", path)
+}
+
+func check(cmd *cobra.Command, args []string) error {
+	nerdctld.UseBackend(nerdctld.Options{
+		Nerdctl:       nerdctlPath,
+		Buildctl:      buildctlPath,
+		Backend:       backendName,
+		LimaInstance:  limaInstance,
+		RemoteCommand: strings.Fields(remoteCommand),
+	})
+	if addr == "" && socket != "" {
+		addr = "unix://" + socket
+	}
+	info, err := backend.Info()
+	checks := []struct {
+		name   string
+		result checkResult
+	}{
+		{"nerdctl", checkNerdctl()},
+		{"containerd", checkContainerd(info, err)},
+		{"cgroups", checkCgroups(info, err)},
+		{"buildkit", checkBuildkit()},
+		{"socket", checkSocket(addr)},
+	}
+	failed := 0
+	for _, c := range checks {
+		fmt.Fprintf(cmd.OutOrStdout(), "%-4s %s: %s\n", c.result.level, c.name, c.result.detail)
+		if c.result.level == "FAIL" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
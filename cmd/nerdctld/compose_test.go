@@ -0,0 +1,201 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// composeProject is the name of the project of the compose test
+const composeProject = "nerdctld-e2e"
+
+// composeFile has two services, with a named network and a named volume
+const composeFile = `services:
+  db:
+    image: %[1]s
+    command: ["sh", "-c", "echo ready > /data/db; exec sleep 600"]
+    volumes: [data:/data]
+    networks: [back]
+  app:
+    image: %[1]s
+    command: ["sh", "-c", "echo hello from app; exec sleep 600"]
+    depends_on: [db]
+    volumes: [data:/data]
+    networks: [back]
+networks:
+  back: {}
+volumes:
+  data: {}
+`
+
+// composeCommand returns the compose command, either the plugin of docker or docker-compose
+func composeCommand() []string {
+	if _, err := exec.LookPath("docker"); err == nil {
+		if exec.Command("docker", "compose", "version").Run() == nil {
+			return []string{"docker", "compose"}
+		}
+	}
+	if _, err := exec.LookPath("docker-compose"); err == nil {
+		return []string{"docker-compose"}
+	}
+	return nil
+}
+
+// decodeComposePs decodes "compose ps --format json", which is an array in the older
+// versions of compose, and one object per line in the newer ones
+func decodeComposePs(data []byte) ([]map[string]interface{}, error) {
+	var services []map[string]interface{}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		err := json.Unmarshal(data, &services)
+		return services, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var service map[string]interface{}
+		if err := decoder.Decode(&service); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func TestDecodeComposePs(t *testing.T) {
+	for _, data := range []string{
+		`[{"Service":"app","State":"running"},{"Service":"db","State":"running"}]`,
+		`{"Service":"app","State":"running"}` + "\n" + `{"Service":"db","State":"running"}` + "\n",
+	} {
+		services, err := decodeComposePs([]byte(data))
+		if err != nil || len(services) != 2 || services[1]["Service"] != "db" {
+			t.Errorf("%s: %v, %v", data, services, err)
+		}
+	}
+}
+
+// TestCompose runs a project with compose through an in-process daemon: up, ps, logs and down.
+// It is skipped without compose, or when containerd can't be reached.
+func TestCompose(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the compose test in short mode")
+	}
+	compose := composeCommand()
+	if compose == nil {
+		t.Skip("skipping the compose test: no docker compose or docker-compose")
+	}
+	image := os.Getenv("NERDCTLD_CONFORMANCE_IMAGE")
+	if image == "" {
+		image = "alpine:latest"
+	}
+	sock := startDaemon(t)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "compose.yaml")
+	if err := os.WriteFile(file, []byte(fmt.Sprintf(composeFile, image)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"DOCKER_HOST=unix://" + sock}
+	for _, e := range os.Environ() {
+		// the context would be used instead of the host
+		if !strings.HasPrefix(e, "DOCKER_HOST=") && !strings.HasPrefix(e, "DOCKER_CONTEXT=") {
+			env = append(env, e)
+		}
+	}
+	run := func(args ...string) ([]byte, error) {
+		args = append(append(compose[1:], "--project-name", composeProject, "--file", file), args...)
+		cmd := exec.Command(compose[0], args...)
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Logf("compose %s: %s", strings.Join(args, " "), stderr.String())
+		}
+		return out, err
+	}
+	t.Cleanup(func() { _, _ = run("down", "--volumes", "--timeout", "1") })
+
+	if _, err := run("up", "--detach", "--wait"); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	out, err := run("ps", "--format", "json")
+	if err != nil {
+		t.Fatalf("ps: %v", err)
+	}
+	services, err := decodeComposePs(out)
+	if err != nil {
+		t.Fatalf("ps: %v: %s", err, out)
+	}
+	running := map[string]bool{}
+	for _, service := range services {
+		name, _ := service["Service"].(string)
+		running[name] = service["State"] == "running"
+	}
+	if !running["db"] || !running["app"] {
+		t.Errorf("ps: %s", out)
+	}
+
+	// the output of the container can take a moment to get to the log
+	for i := 0; ; i++ {
+		out, err := run("logs", "app")
+		if err != nil {
+			t.Fatalf("logs: %v", err)
+		}
+		if bytes.Contains(out, []byte("hello from app")) {
+			break
+		}
+		if i == 50 {
+			t.Errorf("logs: %s", out)
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, err := run("down", "--volumes", "--timeout", "1"); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	cc, err := newConformanceClient("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var containers []map[string]interface{}
+	filters := `{"label":["com.docker.compose.project=` + composeProject + `"]}`
+	if err := cc.getJSON("/containers/json", url.Values{"all": {"1"}, "filters": {filters}}, &containers); err != nil {
+		t.Fatal(err)
+	}
+	if len(containers) > 0 {
+		t.Errorf("down left %d containers", len(containers))
+	}
+	for _, path := range []string{"/networks/" + composeProject + "_back", "/volumes/" + composeProject + "_data"} {
+		resp, _, err := cc.do("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("down left %s: status %d", path, resp.StatusCode)
+		}
+	}
+}
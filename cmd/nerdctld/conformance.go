@@ -0,0 +1,656 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/afbjorklund/nerdctld/api"
+	"github.com/spf13/cobra"
+)
+
+var conformanceCmd = &cobra.Command{
+	Use:   "conformance",
+	Short: "Check the API of a running daemon against the Docker API",
+	Long: `Checks the listings and inspects of a running nerdctld (or dockerd) against
+the field mappings that clients expect, and then drives a container through its
+lifecycle: pull, tag, volume and network create, container create, start, exec,
+logs, stop and remove, and the removal of the network, volume and image again.
+
+The requests are plain HTTP, not the Docker Go SDK. It does not cover all of
+the endpoints, like build, load, archive, commit and events.
+
+It talks to a real containerd, so it should be run against a test host.`,
+	Args: cobra.NoArgs,
+	RunE: conformance,
+}
+
+func init() {
+	conformanceCmd.Flags().StringVar(&conformanceImage, "image", "alpine:latest", "image to pull, for the lifecycle checks")
+	conformanceCmd.Flags().BoolVar(&conformanceReadOnly, "read-only", false, "skip the lifecycle checks, that create and remove objects")
+	rootCmd.AddCommand(conformanceCmd)
+}
+
+var conformanceImage string
+var conformanceReadOnly bool
+
+// conformanceClient talks HTTP to the daemon, using the versioned API
+type conformanceClient struct {
+	http    *http.Client
+	version string
+}
+
+func newConformanceClient(addr string) (*conformanceClient, error) {
+	addrSlice := strings.SplitN(addr, "://", 2)
+	if len(addrSlice) < 2 {
+		return nil, fmt.Errorf("did you mean unix://%s", addr)
+	}
+	proto, address := addrSlice[0], addrSlice[1]
+	if proto != "unix" && proto != "tcp" {
+		return nil, fmt.Errorf("addr %s not supported", addr)
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, proto, address)
+		},
+	}
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Minute}
+	return &conformanceClient{http: client, version: "v" + api.CurrentAPIVersion}, nil
+}
+
+func (cc *conformanceClient) do(method string, path string, query url.Values) (*http.Response, []byte, error) {
+	return cc.doJSON(method, path, query, nil)
+}
+
+// doJSON does the request, with the JSON of v as the body (unless nil)
+func (cc *conformanceClient) doJSON(method string, path string, query url.Values, v interface{}) (*http.Response, []byte, error) {
+	u := "http://docker/" + cc.version + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if v != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := cc.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// getJSON does a GET request, expects 200 OK and decodes the body into v
+func (cc *conformanceClient) getJSON(path string, query url.Values, v interface{}) error {
+	resp, body, err := cc.do("GET", path, query)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		return fmt.Errorf("GET %s: Content-Type %q", path, ct)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// expect does the request, and checks the status code (returning the body)
+func (cc *conformanceClient) expect(method string, path string, query url.Values, v interface{}, code int) ([]byte, error) {
+	resp, body, err := cc.doJSON(method, path, query, v)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != code {
+		return nil, fmt.Errorf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, code, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+type conformanceCheck struct {
+	name string
+	run  func(cc *conformanceClient) error
+}
+
+// dockerStates are the container states in the docker state machine
+var dockerStates = []string{"created", "running", "paused", "restarting", "removing", "exited", "dead"}
+
+func requireFields(what string, obj map[string]interface{}, fields ...string) error {
+	for _, field := range fields {
+		if _, ok := obj[field]; !ok {
+			return fmt.Errorf("%s: missing field %q", what, field)
+		}
+	}
+	return nil
+}
+
+func requireString(what string, obj map[string]interface{}, fields ...string) error {
+	for _, field := range fields {
+		if s, ok := obj[field].(string); !ok || s == "" {
+			return fmt.Errorf("%s: field %q is not a non-empty string: %v", what, field, obj[field])
+		}
+	}
+	return nil
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"ping", func(cc *conformanceClient) error {
+		req, err := http.NewRequest("HEAD", "http://docker/_ping", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := cc.http.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("HEAD /_ping: status %d", resp.StatusCode)
+		}
+		if resp.Header.Get("API-Version") == "" {
+			return fmt.Errorf("HEAD /_ping: missing API-Version header")
+		}
+		return nil
+	}},
+	{"version", func(cc *conformanceClient) error {
+		var v map[string]interface{}
+		if err := cc.getJSON("/version", nil, &v); err != nil {
+			return err
+		}
+		if err := requireString("version", v, "Version", "ApiVersion", "MinAPIVersion", "Os", "Arch"); err != nil {
+			return err
+		}
+		if api.CompareVersions(v["MinAPIVersion"].(string), v["ApiVersion"].(string)) > 0 {
+			return fmt.Errorf("version: MinAPIVersion %v > ApiVersion %v", v["MinAPIVersion"], v["ApiVersion"])
+		}
+		return nil
+	}},
+	{"info", func(cc *conformanceClient) error {
+		var info map[string]interface{}
+		if err := cc.getJSON("/info", nil, &info); err != nil {
+			return err
+		}
+		if err := requireString("info", info, "ID", "Driver", "ServerVersion", "OSType", "Architecture"); err != nil {
+			return err
+		}
+		total, _ := info["Containers"].(float64)
+		running, _ := info["ContainersRunning"].(float64)
+		paused, _ := info["ContainersPaused"].(float64)
+		stopped, _ := info["ContainersStopped"].(float64)
+		if running+paused+stopped != total {
+			return fmt.Errorf("info: Containers %v != %v running + %v paused + %v stopped", total, running, paused, stopped)
+		}
+		return nil
+	}},
+	{"images", func(cc *conformanceClient) error {
+		var images []map[string]interface{}
+		if err := cc.getJSON("/images/json", nil, &images); err != nil {
+			return err
+		}
+		for _, image := range images {
+			if err := requireString("images", image, "Id"); err != nil {
+				return err
+			}
+			if err := requireFields("images", image, "RepoTags", "RepoDigests", "Created", "Size"); err != nil {
+				return err
+			}
+			if created, _ := image["Created"].(float64); created <= 0 {
+				return fmt.Errorf("images: %v: Created %v", image["Id"], image["Created"])
+			}
+			if size, _ := image["Size"].(float64); size < 0 {
+				return fmt.Errorf("images: %v: Size %v", image["Id"], image["Size"])
+			}
+		}
+		if len(images) == 0 {
+			return nil
+		}
+		id := images[0]["Id"].(string)
+		var image map[string]interface{}
+		if err := cc.getJSON("/images/"+id+"/json", nil, &image); err != nil {
+			return err
+		}
+		if err := requireString("image inspect", image, "Id"); err != nil {
+			return err
+		}
+		var history []map[string]interface{}
+		if err := cc.getJSON("/images/"+id+"/history", nil, &history); err != nil {
+			return err
+		}
+		for _, h := range history {
+			if err := requireFields("image history", h, "Id", "Created", "CreatedBy", "Size", "Comment"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"containers", func(cc *conformanceClient) error {
+		var containers []map[string]interface{}
+		if err := cc.getJSON("/containers/json", url.Values{"all": {"1"}}, &containers); err != nil {
+			return err
+		}
+		for _, container := range containers {
+			if err := requireString("containers", container, "Id", "Image", "Status"); err != nil {
+				return err
+			}
+			state, _ := container["State"].(string)
+			valid := false
+			for _, s := range dockerStates {
+				if state == s {
+					valid = true
+				}
+			}
+			if !valid {
+				return fmt.Errorf("containers: %v: State %q for Status %q", container["Id"], state, container["Status"])
+			}
+			if names, _ := container["Names"].([]interface{}); len(names) > 0 {
+				if name, _ := names[0].(string); !strings.HasPrefix(name, "/") {
+					return fmt.Errorf("containers: %v: name %q without slash", container["Id"], name)
+				}
+			}
+		}
+		if len(containers) == 0 {
+			return nil
+		}
+		id := containers[0]["Id"].(string)
+		var container map[string]interface{}
+		if err := cc.getJSON("/containers/"+id+"/json", nil, &container); err != nil {
+			return err
+		}
+		return requireFields("container inspect", container, "Id", "State", "Config", "HostConfig")
+	}},
+	{"container not found", func(cc *conformanceClient) error {
+		resp, _, err := cc.do("GET", "/containers/nerdctld-conformance-missing/json", nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("GET missing container: status %d", resp.StatusCode)
+		}
+		return nil
+	}},
+	{"container restart not found", func(cc *conformanceClient) error {
+		resp, _, err := cc.do("POST", "/containers/nerdctld-conformance-missing/restart", nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("POST restart missing container: status %d", resp.StatusCode)
+		}
+		return nil
+	}},
+	{"containers health filter", func(cc *conformanceClient) error {
+		// autoheal looks for the unhealthy containers, to restart them
+		var containers []map[string]interface{}
+		filters := `{"health":["unhealthy"]}`
+		if err := cc.getJSON("/containers/json", url.Values{"filters": {filters}}, &containers); err != nil {
+			return err
+		}
+		for _, container := range containers {
+			if status, _ := container["Status"].(string); !strings.HasSuffix(status, "(unhealthy)") {
+				return fmt.Errorf("containers: %v: Status %q for health filter", container["Id"], status)
+			}
+		}
+		return nil
+	}},
+	{"volumes", func(cc *conformanceClient) error {
+		var volumes struct {
+			Volumes  []map[string]interface{}
+			Warnings []string
+		}
+		if err := cc.getJSON("/volumes", nil, &volumes); err != nil {
+			return err
+		}
+		if volumes.Volumes == nil {
+			return fmt.Errorf("volumes: Volumes is null")
+		}
+		for _, volume := range volumes.Volumes {
+			if err := requireString("volumes", volume, "Name", "Driver", "Mountpoint"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"networks", func(cc *conformanceClient) error {
+		var networks []map[string]interface{}
+		if err := cc.getJSON("/networks", nil, &networks); err != nil {
+			return err
+		}
+		for _, network := range networks {
+			if err := requireString("networks", network, "Id", "Name", "Scope"); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"system df", func(cc *conformanceClient) error {
+		var du map[string]interface{}
+		if err := cc.getJSON("/system/df", nil, &du); err != nil {
+			return err
+		}
+		return requireFields("system df", du, "LayersSize", "Images", "Containers", "Volumes", "BuildCache")
+	}},
+}
+
+// lifecycleName is the name of the container, volume and network of the lifecycle checks
+const lifecycleName = "nerdctld-conformance"
+
+// lifecycleTag is the tag of the pulled image, for the lifecycle checks
+const lifecycleTag = lifecycleName + ":test"
+
+// lifecycleChecks create, use and remove the objects in order, so each check
+// depends on the ones before it
+func lifecycleChecks(image string) []conformanceCheck {
+	var id string
+	// state checks the state of the container, both in inspect and in ps
+	state := func(cc *conformanceClient, want string, status string) error {
+		var container map[string]interface{}
+		if err := cc.getJSON("/containers/"+id+"/json", nil, &container); err != nil {
+			return err
+		}
+		st, _ := container["State"].(map[string]interface{})
+		if s, _ := st["Status"].(string); s != want {
+			return fmt.Errorf("container inspect: State.Status %q, want %q", s, want)
+		}
+		if running, _ := st["Running"].(bool); running != (want == "running") {
+			return fmt.Errorf("container inspect: State.Running %v when %s", running, want)
+		}
+		var containers []map[string]interface{}
+		filters := `{"name":["` + lifecycleName + `"]}`
+		if err := cc.getJSON("/containers/json", url.Values{"all": {"1"}, "filters": {filters}}, &containers); err != nil {
+			return err
+		}
+		for _, container := range containers {
+			if container["Id"] != id {
+				continue
+			}
+			if container["State"] != want {
+				return fmt.Errorf("containers: State %q, want %q", container["State"], want)
+			}
+			if s, _ := container["Status"].(string); !strings.HasPrefix(s, status) {
+				return fmt.Errorf("containers: Status %q, want %q", s, status+"...")
+			}
+			return nil
+		}
+		return fmt.Errorf("containers: %s is not listed", id)
+	}
+	// removed checks that the object is gone (from inspect) after removing it
+	removed := func(cc *conformanceClient, path string, code int, inspect string) error {
+		if _, err := cc.expect("DELETE", path, nil, nil, code); err != nil {
+			return err
+		}
+		resp, _, err := cc.do("GET", inspect, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			return fmt.Errorf("GET %s: status %d after remove", inspect, resp.StatusCode)
+		}
+		return nil
+	}
+	return []conformanceCheck{
+		{"image pull", func(cc *conformanceClient) error {
+			body, err := cc.expect("POST", "/images/create", url.Values{"fromImage": {image}}, nil, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			// the errors are in the progress, after the status
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			for {
+				var msg struct {
+					Error string `json:"error"`
+				}
+				if err := decoder.Decode(&msg); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("image pull: %v", err)
+				}
+				if msg.Error != "" {
+					return fmt.Errorf("image pull: %s", msg.Error)
+				}
+			}
+		}},
+		{"image tag", func(cc *conformanceClient) error {
+			query := url.Values{"repo": {lifecycleName}, "tag": {"test"}}
+			if _, err := cc.expect("POST", "/images/"+image+"/tag", query, nil, http.StatusCreated); err != nil {
+				return err
+			}
+			var inspect map[string]interface{}
+			if err := cc.getJSON("/images/"+lifecycleTag+"/json", nil, &inspect); err != nil {
+				return err
+			}
+			if err := requireString("image inspect", inspect, "Id"); err != nil {
+				return err
+			}
+			if size, _ := inspect["Size"].(float64); size <= 0 {
+				return fmt.Errorf("image inspect: Size %v", inspect["Size"])
+			}
+			tags, _ := inspect["RepoTags"].([]interface{})
+			for _, tag := range tags {
+				if s, _ := tag.(string); strings.HasSuffix(s, lifecycleTag) {
+					return nil
+				}
+			}
+			return fmt.Errorf("image inspect: RepoTags %v without %s", inspect["RepoTags"], lifecycleTag)
+		}},
+		{"volume create", func(cc *conformanceClient) error {
+			body, err := cc.expect("POST", "/volumes/create", nil, map[string]interface{}{"Name": lifecycleName}, http.StatusCreated)
+			if err != nil {
+				return err
+			}
+			var volume map[string]interface{}
+			if err := json.Unmarshal(body, &volume); err != nil {
+				return err
+			}
+			if err := requireString("volume create", volume, "Name", "Driver", "Mountpoint"); err != nil {
+				return err
+			}
+			return cc.getJSON("/volumes/"+lifecycleName, nil, &volume)
+		}},
+		{"network create", func(cc *conformanceClient) error {
+			body, err := cc.expect("POST", "/networks/create", nil, map[string]interface{}{"Name": lifecycleName}, http.StatusCreated)
+			if err != nil {
+				return err
+			}
+			var network map[string]interface{}
+			if err := json.Unmarshal(body, &network); err != nil {
+				return err
+			}
+			if err := requireString("network create", network, "Id"); err != nil {
+				return err
+			}
+			if err := cc.getJSON("/networks/"+lifecycleName, nil, &network); err != nil {
+				return err
+			}
+			return requireString("network inspect", network, "Id", "Name", "Scope")
+		}},
+		{"container create", func(cc *conformanceClient) error {
+			config := map[string]interface{}{
+				"Image": lifecycleTag,
+				"Cmd":   []string{"sh", "-c", "echo hello; exec sleep 600"},
+				"HostConfig": map[string]interface{}{
+					"Binds":       []string{lifecycleName + ":/data"},
+					"NetworkMode": lifecycleName,
+				},
+			}
+			body, err := cc.expect("POST", "/containers/create", url.Values{"name": {lifecycleName}}, config, http.StatusCreated)
+			if err != nil {
+				return err
+			}
+			var created struct {
+				Id string
+			}
+			if err := json.Unmarshal(body, &created); err != nil {
+				return err
+			}
+			if created.Id == "" {
+				return fmt.Errorf("container create: no Id")
+			}
+			id = created.Id
+			return state(cc, "created", "Created")
+		}},
+		{"container start", func(cc *conformanceClient) error {
+			if _, err := cc.expect("POST", "/containers/"+id+"/start", nil, nil, http.StatusNoContent); err != nil {
+				return err
+			}
+			return state(cc, "running", "Up")
+		}},
+		{"container exec", func(cc *conformanceClient) error {
+			config := map[string]interface{}{"Cmd": []string{"sh", "-c", "echo exec; exit 3"}, "AttachStdout": true, "AttachStderr": true}
+			body, err := cc.expect("POST", "/containers/"+id+"/exec", nil, config, http.StatusCreated)
+			if err != nil {
+				return err
+			}
+			var exec struct {
+				Id string
+			}
+			if err := json.Unmarshal(body, &exec); err != nil {
+				return err
+			}
+			out, err := cc.expect("POST", "/exec/"+exec.Id+"/start", nil, map[string]interface{}{"Detach": false}, http.StatusOK)
+			if err != nil {
+				return err
+			}
+			if !bytes.Contains(out, []byte("exec\n")) {
+				return fmt.Errorf("exec start: output %q", out)
+			}
+			var inspect struct {
+				Running  bool
+				ExitCode *int
+			}
+			if err := cc.getJSON("/exec/"+exec.Id+"/json", nil, &inspect); err != nil {
+				return err
+			}
+			if inspect.Running || inspect.ExitCode == nil || *inspect.ExitCode != 3 {
+				return fmt.Errorf("exec inspect: Running %v, ExitCode %v", inspect.Running, inspect.ExitCode)
+			}
+			return nil
+		}},
+		{"container logs", func(cc *conformanceClient) error {
+			// the output of the container can take a moment to get to the log
+			var out []byte
+			for i := 0; i < 50; i++ {
+				var err error
+				out, err = cc.expect("GET", "/containers/"+id+"/logs", url.Values{"stdout": {"1"}, "stderr": {"1"}}, nil, http.StatusOK)
+				if err != nil {
+					return err
+				}
+				if bytes.Contains(out, []byte("hello\n")) {
+					return nil
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			return fmt.Errorf("container logs: output %q", out)
+		}},
+		{"container stop", func(cc *conformanceClient) error {
+			if _, err := cc.expect("POST", "/containers/"+id+"/stop", url.Values{"t": {"1"}}, nil, http.StatusNoContent); err != nil {
+				return err
+			}
+			return state(cc, "exited", "Exited")
+		}},
+		{"container remove", func(cc *conformanceClient) error {
+			return removed(cc, "/containers/"+id, http.StatusNoContent, "/containers/"+id+"/json")
+		}},
+		{"network remove", func(cc *conformanceClient) error {
+			return removed(cc, "/networks/"+lifecycleName, http.StatusNoContent, "/networks/"+lifecycleName)
+		}},
+		{"volume remove", func(cc *conformanceClient) error {
+			return removed(cc, "/volumes/"+lifecycleName, http.StatusNoContent, "/volumes/"+lifecycleName)
+		}},
+		{"image remove", func(cc *conformanceClient) error {
+			return removed(cc, "/images/"+lifecycleTag, http.StatusOK, "/images/"+lifecycleTag+"/json")
+		}},
+	}
+}
+
+// lifecycleCleanup removes what the lifecycle checks left behind, like after a failure
+func lifecycleCleanup(cc *conformanceClient) {
+	_, _, _ = cc.do("DELETE", "/containers/"+lifecycleName, url.Values{"force": {"1"}})
+	_, _, _ = cc.do("DELETE", "/networks/"+lifecycleName, nil)
+	_, _, _ = cc.do("DELETE", "/volumes/"+lifecycleName, nil)
+	_, _, _ = cc.do("DELETE", "/images/"+lifecycleTag, nil)
+}
+
+// runLifecycle runs the lifecycle checks, skipping the rest after a failure, and then cleans up
+func runLifecycle(cc *conformanceClient, image string, report func(name string, err error, skipped bool)) {
+	lifecycleCleanup(cc)
+	defer lifecycleCleanup(cc)
+	failed := false
+	for _, check := range lifecycleChecks(image) {
+		if failed {
+			report(check.name, nil, true)
+			continue
+		}
+		err := check.run(cc)
+		failed = err != nil
+		report(check.name, err, false)
+	}
+}
+
+func conformance(cmd *cobra.Command, args []string) error {
+	if addr == "" && socket != "" {
+		addr = "unix://" + socket
+	}
+	cc, err := newConformanceClient(addr)
+	if err != nil {
+		return err
+	}
+	failed, total := 0, 0
+	report := func(name string, err error, skipped bool) {
+		total++
+		switch {
+		case skipped:
+			fmt.Fprintf(cmd.OutOrStdout(), "SKIP %s\n", name)
+		case err != nil:
+			fmt.Fprintf(cmd.OutOrStdout(), "FAIL %s: %v\n", name, err)
+			failed++
+		default:
+			fmt.Fprintf(cmd.OutOrStdout(), "PASS %s\n", name)
+		}
+	}
+	for _, check := range conformanceChecks {
+		report(check.name, check.run(cc), false)
+	}
+	if !conformanceReadOnly {
+		runLifecycle(cc, conformanceImage, report)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, total)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/afbjorklund/nerdctld"
+	"github.com/afbjorklund/nerdctld/api"
+	"github.com/afbjorklund/nerdctld/backend"
+)
+
+// fakeDaemon answers the conformance requests with canned responses, where
+// the containers listing can be replaced to break the field mappings
+func fakeDaemon(t *testing.T, containers []map[string]interface{}) *conformanceClient {
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", api.CurrentAPIVersion)
+	})
+	prefix := "/v" + api.CurrentAPIVersion
+	mux.HandleFunc(prefix+"/version", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"Version": "0.0.0", "ApiVersion": api.CurrentAPIVersion,
+			"MinAPIVersion": api.MinimumAPIVersion, "Os": "linux", "Arch": "amd64"})
+	})
+	mux.HandleFunc(prefix+"/info", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"ID": "x", "Driver": "overlayfs", "ServerVersion": "0.0.0",
+			"OSType": "linux", "Architecture": "x86_64",
+			"Containers": 1, "ContainersRunning": 1, "ContainersPaused": 0, "ContainersStopped": 0})
+	})
+	mux.HandleFunc(prefix+"/images/json", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, []interface{}{})
+	})
+	mux.HandleFunc(prefix+"/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filters") != "" {
+			reply(w, []interface{}{})
+			return
+		}
+		reply(w, containers)
+	})
+	mux.HandleFunc(prefix+"/containers/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "nerdctld-conformance-missing") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		reply(w, map[string]interface{}{"Id": "abc", "State": map[string]interface{}{}, "Config": map[string]interface{}{}, "HostConfig": map[string]interface{}{}})
+	})
+	mux.HandleFunc(prefix+"/volumes", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"Volumes": []interface{}{}, "Warnings": nil})
+	})
+	mux.HandleFunc(prefix+"/networks", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, []interface{}{map[string]interface{}{"Id": "n", "Name": "bridge", "Scope": "local"}})
+	})
+	mux.HandleFunc(prefix+"/system/df", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{"LayersSize": 0, "Images": []interface{}{}, "Containers": []interface{}{}, "Volumes": []interface{}{}, "BuildCache": []interface{}{}})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cc, err := newConformanceClient("tcp://" + srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+// runChecks runs the conformance checks, and returns the errors by check name
+func runChecks(cc *conformanceClient) map[string]error {
+	failed := map[string]error{}
+	for _, check := range conformanceChecks {
+		if err := check.run(cc); err != nil {
+			failed[check.name] = err
+		}
+	}
+	return failed
+}
+
+func TestConformanceChecks(t *testing.T) {
+	good := []map[string]interface{}{{"Id": "abc", "Image": "alpine", "Status": "Up 2 seconds", "State": "running", "Names": []string{"/web"}}}
+	for name, err := range runChecks(fakeDaemon(t, good)) {
+		t.Errorf("%s: %v", name, err)
+	}
+
+	for name, containers := range map[string][]map[string]interface{}{
+		"ps status as state": {{"Id": "abc", "Image": "alpine", "Status": "Up 2 seconds", "State": "Up", "Names": []string{"/web"}}},
+		"name without slash": {{"Id": "abc", "Image": "alpine", "Status": "Up 2 seconds", "State": "running", "Names": []string{"web"}}},
+		"missing image":      {{"Id": "abc", "Status": "Up 2 seconds", "State": "running"}},
+	} {
+		failed := runChecks(fakeDaemon(t, containers))
+		if failed["containers"] == nil {
+			t.Errorf("%s: containers check passed", name)
+		}
+		if len(failed) != 1 {
+			t.Errorf("%s: other checks failed: %v", name, failed)
+		}
+	}
+}
+
+// fakeLifecycle answers the lifecycle requests, keeping the state of the objects,
+// and records the requests (as "METHOD path")
+func fakeLifecycle(t *testing.T, pullError string) (*conformanceClient, *[]string) {
+	var requests []string
+	tagged, volume, network := false, false, false
+	state := ""
+	prefix := "/v" + api.CurrentAPIVersion
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		reply := func(code int, v interface{}) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(v)
+		}
+		// found replies with v, or 404
+		found := func(exists bool, v interface{}) {
+			if !exists {
+				reply(http.StatusNotFound, map[string]string{"message": "not found"})
+				return
+			}
+			reply(http.StatusOK, v)
+		}
+		container := map[string]interface{}{"Id": "c1", "State": map[string]interface{}{"Status": state, "Running": state == "running"}}
+		status := map[string]string{"created": "Created", "running": "Up 1 second", "exited": "Exited (137) 1 second ago"}[state]
+		req := r.Method + " " + strings.TrimPrefix(r.URL.Path, prefix)
+		requests = append(requests, req)
+		switch req {
+		case "POST /images/create":
+			reply(http.StatusOK, map[string]string{"status": "Pulling"})
+			if pullError != "" {
+				json.NewEncoder(w).Encode(map[string]string{"error": pullError})
+			}
+		case "POST /images/alpine:latest/tag":
+			tagged = true
+			w.WriteHeader(http.StatusCreated)
+		case "GET /images/nerdctld-conformance:test/json":
+			found(tagged, map[string]interface{}{"Id": "sha256:abc", "Size": 100, "RepoTags": []string{"nerdctld-conformance:test"}})
+		case "DELETE /images/nerdctld-conformance:test":
+			tagged = false
+			found(true, []interface{}{})
+		case "POST /volumes/create":
+			volume = true
+			reply(http.StatusCreated, map[string]string{"Name": lifecycleName, "Driver": "local", "Mountpoint": "/data"})
+		case "GET /volumes/nerdctld-conformance":
+			found(volume, map[string]string{"Name": lifecycleName})
+		case "POST /networks/create":
+			network = true
+			reply(http.StatusCreated, map[string]string{"Id": "n1"})
+		case "GET /networks/nerdctld-conformance":
+			found(network, map[string]string{"Id": "n1", "Name": lifecycleName, "Scope": "local"})
+		case "DELETE /volumes/nerdctld-conformance", "DELETE /networks/nerdctld-conformance":
+			volume = volume && !strings.Contains(req, "volumes")
+			network = network && !strings.Contains(req, "networks")
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /containers/create":
+			state = "created"
+			reply(http.StatusCreated, map[string]string{"Id": "c1"})
+		case "GET /containers/c1/json":
+			found(state != "", container)
+		case "GET /containers/json":
+			reply(http.StatusOK, []interface{}{map[string]interface{}{"Id": "c1", "State": state, "Status": status}})
+		case "POST /containers/c1/start":
+			state = "running"
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /containers/c1/stop":
+			state = "exited"
+			w.WriteHeader(http.StatusNoContent)
+		case "DELETE /containers/c1":
+			state = ""
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /containers/c1/exec":
+			reply(http.StatusCreated, map[string]string{"Id": "e1"})
+		case "POST /exec/e1/start":
+			w.Write([]byte("exec\n"))
+		case "GET /exec/e1/json":
+			reply(http.StatusOK, map[string]interface{}{"Running": false, "ExitCode": 3})
+		case "GET /containers/c1/logs":
+			w.Write([]byte("hello\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(srv.Close)
+	cc, err := newConformanceClient("tcp://" + srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc, &requests
+}
+
+func TestLifecycleChecks(t *testing.T) {
+	cc, requests := fakeLifecycle(t, "")
+	runLifecycle(cc, "alpine:latest", func(name string, err error, skipped bool) {
+		if err != nil || skipped {
+			t.Errorf("%s: %v (skipped %v)", name, err, skipped)
+		}
+	})
+	last := (*requests)[len(*requests)-4:]
+	if want := "DELETE /containers/nerdctld-conformance"; last[0] != want {
+		t.Errorf("cleanup started with %q, want %q", last[0], want)
+	}
+
+	// a failed check skips the rest, and the cleanup still runs
+	cc, requests = fakeLifecycle(t, "pull access denied")
+	results := map[string]string{}
+	runLifecycle(cc, "alpine:latest", func(name string, err error, skipped bool) {
+		switch {
+		case skipped:
+			results[name] = "skip"
+		case err != nil:
+			results[name] = err.Error()
+		default:
+			results[name] = "pass"
+		}
+	})
+	if results["image pull"] != "image pull: pull access denied" {
+		t.Errorf("image pull: %q", results["image pull"])
+	}
+	if results["container start"] != "skip" || results["image remove"] != "skip" {
+		t.Errorf("checks after the failure were not skipped: %v", results)
+	}
+	if last := (*requests)[len(*requests)-1]; last != "DELETE /images/nerdctld-conformance:test" {
+		t.Errorf("no cleanup after the failure, last request %q", last)
+	}
+}
+
+// startDaemon starts an in-process daemon, with the nerdctl and containerd of the host,
+// and returns its socket. The test is skipped when containerd can't be reached.
+func startDaemon(t *testing.T) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := backend.Reachable(ctx); err != nil {
+		t.Skipf("skipping, containerd is not reachable: %v", err)
+	}
+	s, err := nerdctld.NewServer(nerdctld.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "nerdctl.sock")
+	go s.Serve("unix://" + sock)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return sock
+}
+
+// TestConformance runs the checks against an in-process daemon, with the nerdctl and
+// containerd of the host. It is skipped when containerd can't be reached.
+func TestConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the conformance test in short mode")
+	}
+	sock := startDaemon(t)
+	cc, err := newConformanceClient("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range conformanceChecks {
+		t.Run(check.name, func(t *testing.T) {
+			if err := check.run(cc); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	if image := os.Getenv("NERDCTLD_CONFORMANCE_IMAGE"); image != "" {
+		runLifecycle(cc, image, func(name string, err error, skipped bool) {
+			t.Run(name, func(t *testing.T) {
+				if skipped {
+					t.Skip("skipped after a failure")
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,246 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/afbjorklund/nerdctld"
+	"github.com/afbjorklund/nerdctld/api"
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:          "nerdctld",
+	Short:        "A docker api endpoint for nerdctl and containerd",
+	RunE:         run,
+	Version:      version(),
+	SilenceUsage: true,
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "debug mode")
+	rootCmd.PersistentFlags().BoolVar(&validate, "validate", false, "validate the requests against the API definition")
+	rootCmd.PersistentFlags().StringArrayVar(&webhooks, "webhook", nil, "url to post events to (or nats:// and mqtt://), with optional filters (like \"https://example.com/hook event=die\")")
+	rootCmd.PersistentFlags().BoolVar(&healthProbes, "health-probes", false, "run the healthchecks in nerdctld (for nerdctl without them)")
+	rootCmd.PersistentFlags().BoolVar(&pullMissing, "pull-missing", false, "pull missing images on container create (instead of 404)")
+	rootCmd.PersistentFlags().IntVar(&maxConcurrentBuilds, "max-concurrent-builds", 0, "number of builds to run at the same time, the others wait (0 is no limit)")
+	rootCmd.PersistentFlags().StringVar(&defaultRegistry, "default-registry", "", "registry for the unqualified image names, instead of docker.io")
+	rootCmd.PersistentFlags().StringArrayVar(&registryAliases, "registry-alias", nil, "short name of an image, like \"alpine=registry.example.com/library/alpine\"")
+	rootCmd.PersistentFlags().StringVar(&engineName, "name", "", "name of the engine in info (default hostname)")
+	rootCmd.PersistentFlags().StringArrayVar(&engineLabels, "label", nil, "label of the engine in info, like \"role=ci\"")
+	rootCmd.PersistentFlags().BoolVar(&supervise, "supervise", false, "restart the containers that exit, according to their restart policy")
+	rootCmd.PersistentFlags().StringVar(&stateDir, "state-dir", defaultStateDir(), "directory for the state, that is kept across restarts (empty is none)")
+	rootCmd.PersistentFlags().StringVar(&addr, "addr", "", "listening address")
+	rootCmd.PersistentFlags().StringVar(&socket, "socket", "nerdctl.sock", "location of socket file")
+	rootCmd.PersistentFlags().StringVar(&dockerSocket, "docker-socket", "", "symlink to the socket, while running (like docker.sock, replacing only a dangling link)")
+	rootCmd.PersistentFlags().Lookup("docker-socket").NoOptDefVal = nerdctld.DefaultDockerSocket
+	rootCmd.PersistentFlags().StringVar(&nerdctlPath, "nerdctl-path", os.Getenv("NERDCTLD_NERDCTL"), "nerdctl command (default \"nerdctl\")")
+	rootCmd.PersistentFlags().StringVar(&buildctlPath, "buildctl-path", os.Getenv("NERDCTLD_BUILDCTL"), "buildctl command (default \"buildctl\")")
+	rootCmd.PersistentFlags().StringVar(&backendName, "backend", "", "where to run nerdctl (local, lima or colima)")
+	rootCmd.PersistentFlags().StringVar(&limaInstance, "lima-instance", os.Getenv("LIMA_INSTANCE"), "lima instance to run nerdctl in")
+	rootCmd.PersistentFlags().StringVar(&remoteCommand, "remote-command", os.Getenv("NERDCTLD_REMOTE_COMMAND"), "command to run nerdctl with, like \"ssh user@host\"")
+	rootCmd.PersistentFlags().BoolVar(&wsl, "wsl", false, "also listen on the relay address, for windows")
+	rootCmd.PersistentFlags().StringVar(&wslAddr, "wsl-addr", "tcp://127.0.0.1:2375", "relay address, reachable from windows (loopback only)")
+	rootCmd.PersistentFlags().StringVar(&wslToken, "wsl-token", os.Getenv("NERDCTLD_TOKEN"), "relay token (defaults to a generated one)")
+}
+
+var debug bool
+var validate bool
+var webhooks []string
+var healthProbes bool
+var supervise bool
+var pullMissing bool
+var maxConcurrentBuilds int
+var defaultRegistry string
+var registryAliases []string
+var engineName string
+var engineLabels []string
+var stateDir string
+var addr string
+var dockerSocket string
+var socket string
+var nerdctlPath string
+var buildctlPath string
+var backendName string
+var limaInstance string
+var remoteCommand string
+var wsl bool
+var wslAddr string
+var wslToken string
+
+// defaultStateDir is /var/lib/nerdctld for root, and otherwise in the XDG state home
+func defaultStateDir() string {
+	if os.Geteuid() == 0 {
+		return "/var/lib/nerdctld"
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "nerdctld")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "nerdctld")
+}
+
+func run(cmd *cobra.Command, args []string) error {
+	switch backendName {
+	case "", "local", "lima", "colima":
+	default:
+		return fmt.Errorf("unknown backend: %s", backendName)
+	}
+	hooks := []api.Webhook{}
+	for _, webhook := range webhooks {
+		hook, err := api.ParseWebhook(webhook)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+	}
+	aliases := map[string]string{}
+	for _, alias := range registryAliases {
+		name, repo, err := api.ParseRegistryAlias(alias)
+		if err != nil {
+			return err
+		}
+		aliases[name] = repo
+	}
+	labels := []string{}
+	for _, label := range engineLabels {
+		label, err := api.ParseEngineLabel(label)
+		if err != nil {
+			return err
+		}
+		labels = append(labels, label)
+	}
+	s, err := nerdctld.NewServer(nerdctld.Options{
+		Debug:               debug,
+		Validate:            validate,
+		Webhooks:            hooks,
+		HealthProbes:        healthProbes,
+		Supervise:           supervise,
+		PullMissing:         pullMissing,
+		DefaultRegistry:     defaultRegistry,
+		MaxConcurrentBuilds: maxConcurrentBuilds,
+		RegistryAliases:     aliases,
+		StateDir:            stateDir,
+		Name:                engineName,
+		Labels:              labels,
+		Nerdctl:             nerdctlPath,
+		Buildctl:            buildctlPath,
+		Backend:             backendName,
+		LimaInstance:        limaInstance,
+		RemoteCommand:       strings.Fields(remoteCommand),
+		DockerSocket:        dockerSocket,
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{backend.Nerdctl, backend.Buildctl} {
+		if path, err := backend.LookPath(name); err != nil {
+			log.Print(err)
+		} else {
+			log.Printf("using %s", path)
+		}
+	}
+	if backend.Available() == nil {
+		if version := backend.InstalledVersion(); version != "" && backend.VersionLess(version, backend.MinNerdctlVersion) {
+			log.Printf("nerdctl %s is older than %s, which is not supported", version, backend.MinNerdctlVersion)
+		}
+	}
+
+	if wsl {
+		if err := serveRelay(s); err != nil {
+			return err
+		}
+	}
+	// deprecated parameter
+	if addr == "" && socket != "" {
+		addr = "unix://" + socket
+	}
+	done := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Print(err)
+		}
+		close(done)
+	}()
+	if err := s.Serve(addr); err != nil {
+		return err
+	}
+	// Serve returns when shut down, so wait for the cleanup
+	<-done
+	return nil
+}
+
+// shutdownTimeout is how long to wait for the requests in progress, when shutting down
+const shutdownTimeout = 10 * time.Second
+
+// serveRelay serves the API on the relay address, in the background
+func serveRelay(s *nerdctld.Server) error {
+	if !nerdctld.IsWSL() {
+		log.Print("not running in WSL")
+	}
+	source := "--wsl-token"
+	if wslToken == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		source = filepath.Join(dir, "nerdctld", "token")
+		wslToken, err = nerdctld.Token(source)
+		if err != nil {
+			return err
+		}
+	}
+	// don't log the token itself, only where to find it
+	log.Printf("relay on %s, add the token from %s to the windows docker config: {\"HttpHeaders\":{\"%s\":\"<token>\"}}",
+		wslAddr, source, nerdctld.TokenHeader)
+	go func() {
+		if err := s.ServeRelay(wslAddr, wslToken); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return nil
+}
+
+func version() string {
+	return "0.6.1"
+}
+
+func main() {
+	err := rootCmd.Execute()
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// portainerImage is the version of Portainer CE that the integration test is validated against
+const portainerImage = "portainer/portainer-ce:2.19.4"
+
+// portainerName is the name of the Portainer container of the test
+const portainerName = "nerdctld-portainer"
+
+// portainerClient talks to the API of Portainer, as the admin user
+type portainerClient struct {
+	url string
+	jwt string
+}
+
+func (pc *portainerClient) do(method string, path string, contentType string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest(method, pc.url+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if pc.jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+pc.jwt)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (pc *portainerClient) postJSON(path string, body interface{}, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return pc.do("POST", path, "application/json", bytes.NewReader(data), v)
+}
+
+// freePort returns a port on the loopback, that was free a moment ago
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+// TestPortainer runs the pinned Portainer CE in a container, with the socket of an in-process
+// daemon as its local environment, and checks that Portainer can snapshot it and manage it
+// through its docker proxy. It pulls the image and publishes a port, so it only runs
+// with NERDCTLD_PORTAINER_TEST=1 (and is skipped when containerd can't be reached).
+func TestPortainer(t *testing.T) {
+	if testing.Short() || os.Getenv("NERDCTLD_PORTAINER_TEST") != "1" {
+		t.Skip("skipping the Portainer test, set NERDCTLD_PORTAINER_TEST=1 to run it")
+	}
+	sock := startDaemon(t)
+	cc, err := newConformanceClient("unix://" + sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, tag, _ := strings.Cut(portainerImage, ":")
+	if _, err := cc.expect("POST", "/images/create", url.Values{"fromImage": {repo}, "tag": {tag}}, nil, http.StatusOK); err != nil {
+		t.Fatal(err)
+	}
+	port := freePort(t)
+	config := map[string]interface{}{
+		"Image": portainerImage,
+		"HostConfig": map[string]interface{}{
+			"Binds":        []string{sock + ":/var/run/docker.sock"},
+			"PortBindings": map[string]interface{}{"9000/tcp": []map[string]string{{"HostIp": "127.0.0.1", "HostPort": port}}},
+		},
+	}
+	_, _, _ = cc.do("DELETE", "/containers/"+portainerName, url.Values{"force": {"1"}})
+	if _, err := cc.expect("POST", "/containers/create", url.Values{"name": {portainerName}}, config, http.StatusCreated); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _, _ = cc.do("DELETE", "/containers/"+portainerName, url.Values{"force": {"1"}}) })
+	if _, err := cc.expect("POST", "/containers/"+portainerName+"/start", nil, nil, http.StatusNoContent); err != nil {
+		t.Fatal(err)
+	}
+
+	pc := &portainerClient{url: "http://127.0.0.1:" + port}
+	for i := 0; ; i++ {
+		err := pc.do("GET", "/api/system/status", "", nil, nil)
+		if err == nil {
+			break
+		}
+		if i == 120 {
+			t.Fatalf("Portainer did not start: %v", err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	login := map[string]string{"Username": "admin", "Password": "nerdctld-portainer-test"}
+	if err := pc.postJSON("/api/users/admin/init", login, nil); err != nil {
+		t.Fatal(err)
+	}
+	var auth struct {
+		JWT string `json:"jwt"`
+	}
+	if err := pc.postJSON("/api/auth", login, &auth); err != nil {
+		t.Fatal(err)
+	}
+	pc.jwt = auth.JWT
+
+	// the local environment, which is the socket of the daemon
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("Name", "local")
+	_ = mw.WriteField("EndpointCreationType", "1")
+	mw.Close()
+	var endpoint struct {
+		ID int `json:"Id"`
+	}
+	if err := pc.do("POST", "/api/endpoints", mw.FormDataContentType(), &form, &endpoint); err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf("/api/endpoints/%d", endpoint.ID)
+
+	// the snapshot is what the dashboard of Portainer shows
+	if err := pc.do("POST", prefix+"/snapshot", "", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot struct {
+		Status    int
+		Snapshots []struct {
+			DockerVersion         string
+			RunningContainerCount int
+			ImageCount            int
+		}
+	}
+	if err := pc.do("GET", prefix, "", nil, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Status != 1 || len(snapshot.Snapshots) == 0 || snapshot.Snapshots[0].DockerVersion == "" {
+		t.Fatalf("snapshot: %+v", snapshot)
+	}
+	if s := snapshot.Snapshots[0]; s.RunningContainerCount < 1 || s.ImageCount < 1 {
+		t.Errorf("snapshot without the Portainer container and image: %+v", s)
+	}
+
+	// and the management goes through its docker proxy
+	for _, path := range []string{"/info", "/version", "/containers/json?all=1", "/images/json", "/volumes", "/networks"} {
+		var v interface{}
+		if err := pc.do("GET", prefix+"/docker"+path, "", nil, &v); err != nil {
+			t.Error(err)
+		}
+	}
+	volume := map[string]string{"Name": portainerName}
+	if err := pc.postJSON(prefix+"/docker/volumes/create", volume, nil); err != nil {
+		t.Error(err)
+	} else if err := pc.do("DELETE", prefix+"/docker/volumes/"+portainerName, "", nil, nil); err != nil {
+		t.Error(err)
+	}
+	network := map[string]string{"Name": portainerName}
+	if err := pc.postJSON(prefix+"/docker/networks/create", network, nil); err != nil {
+		t.Error(err)
+	} else if err := pc.do("DELETE", prefix+"/docker/networks/"+portainerName, "", nil, nil); err != nil {
+		t.Error(err)
+	}
+}
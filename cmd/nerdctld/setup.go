@@ -0,0 +1,168 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var setupCmd = &cobra.Command{
+	Use:   "setup",
+	Short: "Install the systemd units, and a docker context for the socket",
+	Long: `Writes the nerdctl.service and nerdctl.socket units, for the user (rootless)
+or the system containerd, and starts the socket.
+
+For the system socket, it can also be made available to a group.
+Finally a docker context is created, for using the socket with docker.`,
+	Args: cobra.NoArgs,
+	RunE: setup,
+}
+
+func init() {
+	setupCmd.Flags().BoolVar(&setupUser, "user", os.Geteuid() != 0, "install the user units (rootless)")
+	setupCmd.Flags().StringVar(&setupGroup, "group", "", "group to grant access to the system socket")
+	setupCmd.Flags().StringVar(&setupContext, "context", "nerdctl", "docker context to create (empty to skip)")
+	rootCmd.AddCommand(setupCmd)
+}
+
+var setupUser bool
+var setupGroup string
+var setupContext string
+
+const setupService = `[Unit]
+Description=nerdctl
+Requires=nerdctl.socket containerd.service
+After=nerdctl.socket containerd.service
+Documentation=https://github.com/containerd/nerdctl
+
+[Service]
+Type=notify
+Environment=CONTAINERD_NAMESPACE=default
+ExecStart=%s --addr fd://
+
+[Install]
+WantedBy=%s
+`
+
+const setupSocket = `[Unit]
+Description=nerdctl
+Documentation=https://github.com/containerd/nerdctl
+
+[Socket]
+ListenStream=%t/nerdctl.sock
+SocketMode=0660
+
+[Install]
+WantedBy=sockets.target
+`
+
+const setupSocketGroup = `[Socket]
+UMask=0007
+Group=%s
+`
+
+// runCommand runs the command, with the output going to the terminal
+func runCommand(name string, args ...string) error {
+	fmt.Printf("+ %s %v\n", name, args)
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// writeUnit writes the unit file to the directory
+func writeUnit(dir string, name string, content string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	fmt.Printf("writing %s\n", path)
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+func setup(cmd *cobra.Command, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	systemctl := []string{"--system"}
+	dir := "/etc/systemd/system"
+	target := "multi-user.target"
+	socket := "/var/run/nerdctl.sock"
+	if setupUser {
+		if setupGroup != "" {
+			return fmt.Errorf("--group is only for the system socket")
+		}
+		systemctl = []string{"--user"}
+		config, err := os.UserConfigDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(config, "systemd", "user")
+		target = "default.target"
+		runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+		if runtimeDir == "" {
+			runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+		}
+		socket = filepath.Join(runtimeDir, "nerdctl.sock")
+	}
+
+	service := fmt.Sprintf(setupService, exe, target)
+	if err := writeUnit(dir, "nerdctl.service", service); err != nil {
+		return err
+	}
+	if err := writeUnit(dir, "nerdctl.socket", setupSocket); err != nil {
+		return err
+	}
+	if setupGroup != "" {
+		if err := runCommand("groupadd", "--force", "--system", setupGroup); err != nil {
+			return err
+		}
+		dropin := filepath.Join(dir, "nerdctl.socket.d")
+		if err := writeUnit(dropin, "10-group.conf", fmt.Sprintf(setupSocketGroup, setupGroup)); err != nil {
+			return err
+		}
+	}
+	if err := runCommand("systemctl", append(systemctl, "daemon-reload")...); err != nil {
+		return err
+	}
+	if err := runCommand("systemctl", append(systemctl, "enable", "--now", "nerdctl.socket")...); err != nil {
+		return err
+	}
+
+	host := "unix://" + socket
+	if setupContext == "" {
+		fmt.Printf("DOCKER_HOST=%s\n", host)
+		return nil
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		fmt.Printf("docker not found, use DOCKER_HOST=%s\n", host)
+		return nil
+	}
+	if exec.Command("docker", "context", "inspect", setupContext).Run() == nil {
+		fmt.Printf("docker context %s already exists\n", setupContext)
+		return nil
+	}
+	description := "nerdctl (" + systemctl[0][2:] + ")"
+	return runCommand("docker", "context", "create", setupContext,
+		"--description", description, "--docker", "host="+host)
+}
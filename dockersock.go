@@ -0,0 +1,146 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nerdctld
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultDockerSocket is where most tools look for the docker socket
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// dockerSocketRefresh is how often the link is checked (and created again, if removed)
+const dockerSocketRefresh = 10 * time.Second
+
+// replaceableLink checks that the path is a symlink owned by us, that is dangling or already
+// points to the target, so that it can be replaced. It returns the current link target.
+func replaceableLink(path string, target string) (string, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", fmt.Errorf("%s exists, and is not a symlink (is docker running?)", path)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() {
+		return "", fmt.Errorf("%s is a symlink owned by uid %d", path, st.Uid)
+	}
+	current, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if current == target {
+		return current, nil
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return "", fmt.Errorf("%s is a symlink to %s, which is in use", path, current)
+	}
+	return current, nil
+}
+
+// replaceLink points the symlink to the target, replacing it atomically (with a rename)
+func replaceLink(path string, target string) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// linkDockerSocket creates the symlink to the socket, and returns what the link was before
+// (a dangling link, that is to be restored), or "" if there was none
+func linkDockerSocket(path string, socket string) (string, error) {
+	target, err := filepath.Abs(socket)
+	if err != nil {
+		return "", err
+	}
+	previous, err := replaceableLink(path, target)
+	if err != nil {
+		return "", err
+	}
+	if previous == target {
+		// left by an earlier run, or already linked
+		return "", nil
+	}
+	if err := replaceLink(path, target); err != nil {
+		return "", err
+	}
+	log.Printf("linked %s to %s", path, target)
+	return previous, nil
+}
+
+// unlinkDockerSocket removes the symlink if it is still ours, or points it back to
+// where it was before (if it was a link)
+func unlinkDockerSocket(path string, socket string, previous string) {
+	target, err := filepath.Abs(socket)
+	if err != nil {
+		return
+	}
+	if current, err := replaceableLink(path, target); err != nil || current != target {
+		return
+	}
+	if previous != "" {
+		if err := replaceLink(path, previous); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+	_ = os.Remove(path)
+}
+
+// maintainDockerSocket links the docker socket, and keeps it linked until the context is done.
+// It returns what the link was before, for unlinkDockerSocket.
+func maintainDockerSocket(ctx context.Context, path string, socket string) (string, error) {
+	previous, err := linkDockerSocket(path, socket)
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		var last string
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(dockerSocketRefresh):
+			}
+			_, err := linkDockerSocket(path, socket)
+			if err != nil && err.Error() != last {
+				log.Print(err)
+			}
+			if err != nil {
+				last = err.Error()
+			} else {
+				last = ""
+			}
+		}
+	}()
+	return previous, nil
+}
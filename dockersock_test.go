@@ -0,0 +1,106 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nerdctld
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkDockerSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "nerdctl.sock")
+	if err := os.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "docker.sock")
+
+	// no link, so it is created and removed
+	previous, err := linkDockerSocket(link, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := os.Readlink(link); target != socket || previous != "" {
+		t.Errorf("link %q, previous %q", target, previous)
+	}
+	unlinkDockerSocket(link, socket, previous)
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("link was not removed: %v", err)
+	}
+
+	// a dangling link, so it is replaced and restored
+	gone := filepath.Join(dir, "gone.sock")
+	if err := os.Symlink(gone, link); err != nil {
+		t.Fatal(err)
+	}
+	previous, err = linkDockerSocket(link, socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := os.Readlink(link); target != socket || previous != gone {
+		t.Errorf("link %q, previous %q", target, previous)
+	}
+	unlinkDockerSocket(link, socket, previous)
+	if target, _ := os.Readlink(link); target != gone {
+		t.Errorf("link was not restored: %q", target)
+	}
+}
+
+func TestLinkDockerSocketInUse(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "nerdctl.sock")
+	other := filepath.Join(dir, "other.sock")
+	for _, path := range []string{socket, other} {
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a link to another socket that exists
+	link := filepath.Join(dir, "docker.sock")
+	if err := os.Symlink(other, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := linkDockerSocket(link, socket); err == nil {
+		t.Error("expected an error for a link in use")
+	}
+	unlinkDockerSocket(link, socket, "")
+	if target, _ := os.Readlink(link); target != other {
+		t.Errorf("link was changed: %q", target)
+	}
+
+	// not a link at all
+	if _, err := linkDockerSocket(other, socket); err == nil {
+		t.Error("expected an error for a file")
+	}
+}
+
+func TestDockerSocketAbstract(t *testing.T) {
+	s, err := NewServer(Options{DockerSocket: filepath.Join(t.TempDir(), "docker.sock")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+	if err := s.Serve("unix://@nerdctld-test"); err == nil {
+		t.Error("expected an error for an abstract socket")
+	}
+	if err := s.Serve("tcp://127.0.0.1:0"); err == nil {
+		t.Error("expected an error for a tcp socket")
+	}
+}
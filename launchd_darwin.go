@@ -0,0 +1,56 @@
+//go:build cgo
+
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nerdctld
+
+/*
+#include <launch.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// launchdListeners returns the sockets from launchd, for the Sockets entry in the plist
+func launchdListeners(name string) ([]net.Listener, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	var fds *C.int
+	var cnt C.size_t
+	if res := C.launch_activate_socket(cname, &fds, &cnt); res != 0 {
+		return nil, fmt.Errorf("launch_activate_socket %s: %w", name, syscall.Errno(res))
+	}
+	defer C.free(unsafe.Pointer(fds))
+	listeners := []net.Listener{}
+	for _, fd := range unsafe.Slice(fds, int(cnt)) {
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
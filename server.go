@@ -0,0 +1,317 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package nerdctld offers a docker api endpoint for nerdctl and containerd.
+//
+// It can be embedded in other programs, instead of running the nerdctld binary:
+//
+//	s, err := nerdctld.NewServer(nerdctld.Options{})
+//	go s.Serve("unix:///run/nerdctl.sock")
+//	...
+//	err = s.Shutdown(ctx)
+//
+// The options are process-wide (like the nerdctl command), so there can only be
+// one server at a time. Signals are left to the program, to call Shutdown on.
+package nerdctld
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/afbjorklund/nerdctld/api"
+	"github.com/afbjorklund/nerdctld/backend"
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/gin-gonic/gin"
+)
+
+// Options for the server
+type Options struct {
+	// Debug enables the debug mode of gin
+	Debug bool
+	// Validate checks the requests against the OpenAPI definition
+	Validate bool
+	// Webhooks are posted the events, that match their filters
+	Webhooks []api.Webhook
+	// HealthProbes runs the healthchecks in nerdctld, instead of in nerdctl
+	HealthProbes bool
+	// Supervise restarts the containers that exit, according to their restart policy
+	Supervise bool
+	// PullMissing pulls the missing images on container create, instead of replying 404
+	PullMissing bool
+	// MaxConcurrentBuilds is the number of builds that can run at the same time (0 is no limit)
+	MaxConcurrentBuilds int
+	// DefaultRegistry is the registry to pull and push the unqualified image names with
+	DefaultRegistry string
+	// RegistryAliases are the short names of images, like "alpine" for "registry.example.com/library/alpine"
+	RegistryAliases map[string]string
+	// Name is the name of the engine in info, defaults to the hostname
+	Name string
+	// Labels are the labels of the engine in info, like "role=ci"
+	Labels []string
+	// StateDir is where to keep the state that containerd doesn't, across restarts
+	// (like the recent events and the health results), empty keeps it in memory
+	StateDir string
+	// Nerdctl is the nerdctl command, defaults to "nerdctl"
+	Nerdctl string
+	// Buildctl is the buildctl command, defaults to "buildctl"
+	Buildctl string
+	// Backend is where to run nerdctl: "local", "lima" or "colima",
+	// defaults to "local" on linux (and otherwise detected)
+	Backend string
+	// LimaInstance is the lima instance to run nerdctl in, like "default"
+	LimaInstance string
+	// RemoteCommand is the command to run nerdctl with, like "ssh user@host"
+	// (defaults to "lima" when not on linux, and overrides LimaInstance)
+	RemoteCommand []string
+	// DockerSocket is a symlink to create to the unix socket, like "/var/run/docker.sock"
+	// (only replacing a dangling symlink, which is restored on shutdown)
+	DockerSocket string
+}
+
+// Server is the docker api endpoint
+type Server struct {
+	router       *gin.Engine
+	dockerSocket string
+	// previousLink is what the docker socket was linked to before, to restore it
+	previousLink string
+	socket       string
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	mu      sync.Mutex
+	servers []*http.Server
+	closed  bool
+}
+
+// active is the server of the process, since the options are process-wide
+var active struct {
+	sync.Mutex
+	server *Server
+}
+
+// UseBackend sets where and how to run nerdctl, from the options
+// (this is done by NewServer, but is also useful without a server)
+func UseBackend(opts Options) {
+	if opts.Nerdctl != "" {
+		backend.Nerdctl = opts.Nerdctl
+	}
+	if opts.Buildctl != "" {
+		backend.Buildctl = opts.Buildctl
+	}
+	switch opts.Backend {
+	case "local":
+		backend.UseLocal()
+	case "lima":
+		backend.UseLima(opts.LimaInstance)
+	case "colima":
+		backend.UseColima("")
+	default:
+		if opts.LimaInstance != "" {
+			backend.UseLima(opts.LimaInstance)
+		}
+	}
+	if len(opts.RemoteCommand) > 0 {
+		backend.RemoteCommand = opts.RemoteCommand
+	}
+}
+
+// NewServer returns a new server, with the given options,
+// or an error if there is already a server (that has not been shut down)
+func NewServer(opts Options) (*Server, error) {
+	active.Lock()
+	defer active.Unlock()
+	if active.server != nil {
+		return nil, errors.New("there is already a server in this process")
+	}
+	UseBackend(opts)
+	api.SweepTempDirs()
+	api.ValidateRequests = opts.Validate
+	if !opts.Debug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	api.StateDir = opts.StateDir
+	api.LoadState()
+	ctx, cancel := context.WithCancel(context.Background())
+	api.StartWebhooks(ctx, opts.Webhooks)
+	api.PullMissing = opts.PullMissing
+	api.MaxConcurrentBuilds = opts.MaxConcurrentBuilds
+	api.DefaultRegistry = opts.DefaultRegistry
+	if opts.RegistryAliases != nil {
+		api.RegistryAliases = opts.RegistryAliases
+	}
+	api.EngineName = opts.Name
+	if opts.Labels != nil {
+		api.EngineLabels = opts.Labels
+	}
+	api.HealthProbes = opts.HealthProbes
+	if opts.HealthProbes {
+		api.StartHealthProbes(ctx)
+	}
+	if opts.Supervise {
+		api.StartSupervisor(ctx)
+	}
+	active.server = &Server{router: api.NewRouter(), dockerSocket: opts.DockerSocket, ctx: ctx, cancel: cancel}
+	return active.server, nil
+}
+
+// Shutdown stops serving, waiting for the requests in progress (until the context is done),
+// and stops the background work and removes the socket and the temporary files.
+// After it, a new server can be created.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	servers := s.servers
+	s.mu.Unlock()
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.cancel()
+	// the state is saved a moment after it changes, so the last changes are still pending
+	api.FlushState()
+	api.RemoveTempDirs()
+	s.mu.Lock()
+	if s.socket != "" {
+		if s.dockerSocket != "" {
+			unlinkDockerSocket(s.dockerSocket, s.socket, s.previousLink)
+		}
+		os.Remove(s.socket)
+		s.socket = ""
+	}
+	s.mu.Unlock()
+	active.Lock()
+	if active.server == s {
+		active.server = nil
+	}
+	active.Unlock()
+	return errors.Join(errs...)
+}
+
+// serve serves the handler on the listener, until the server is shut down
+func (s *Server) serve(l net.Listener, h http.Handler) error {
+	srv := &http.Server{Handler: h}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.servers = append(s.servers, srv)
+	s.mu.Unlock()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Handler returns the HTTP handler, for serving the API yourself
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Serve listens on the address (unix://, tcp://, fd:// or launchd://) and serves the API,
+// where unix://@name is an abstract socket (on linux). It returns nil after Shutdown.
+func (s *Server) Serve(addr string) error {
+	r := s.router
+	addrSlice := strings.SplitN(addr, "://", 2)
+	if len(addrSlice) < 2 {
+		return fmt.Errorf("did you mean unix://%s", addr)
+	}
+	proto := addrSlice[0]
+	listenAddr := addrSlice[1]
+	if s.dockerSocket != "" && proto != "unix" {
+		return fmt.Errorf("docker socket %s requires a unix:// addr, not %s", s.dockerSocket, addr)
+	}
+	switch proto {
+	case "tcp":
+		l, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			return err
+		}
+		return s.serve(l, r)
+	case "fd":
+		_, err := daemon.SdNotify(false, daemon.SdNotifyReady)
+		if err != nil {
+			return err
+		}
+		files := activation.Files(true)
+		if len(files) == 0 {
+			return errors.New("no sockets passed by systemd")
+		}
+		l, err := net.FileListener(files[0])
+		if err != nil {
+			return err
+		}
+		return s.serve(l, r)
+	case "launchd":
+		// the name of the socket, in the Sockets dictionary of the plist
+		name := listenAddr
+		if name == "" {
+			name = "Listeners"
+		}
+		listeners, err := launchdListeners(name)
+		if err != nil {
+			return err
+		}
+		if len(listeners) == 0 {
+			return fmt.Errorf("no launchd sockets for %s", name)
+		}
+		return s.serve(listeners[0], r)
+	case "unix":
+		socket := listenAddr
+		if name, ok := strings.CutPrefix(socket, "@"); ok {
+			// an abstract socket, so there is no file to remove (or to link to)
+			if s.dockerSocket != "" {
+				return fmt.Errorf("docker socket %s can't link to the abstract socket %s", s.dockerSocket, socket)
+			}
+			l, err := abstractListener(name)
+			if err != nil {
+				return err
+			}
+			return s.serve(l, r)
+		}
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.socket = socket
+		s.mu.Unlock()
+		if s.dockerSocket != "" {
+			previous, err := maintainDockerSocket(s.ctx, s.dockerSocket, socket)
+			if err != nil {
+				l.Close()
+				return err
+			}
+			s.mu.Lock()
+			s.previousLink = previous
+			s.mu.Unlock()
+		}
+		return s.serve(l, r)
+	default:
+		return fmt.Errorf("addr %s not supported", addr)
+	}
+}
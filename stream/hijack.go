@@ -0,0 +1,103 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Stream types, for multiplexing stdin/stdout/stderr on the same connection
+const (
+	Stdin  = 0
+	Stdout = 1
+	Stderr = 2
+)
+
+// StdWriter prefixes each write with the 8-byte header, that docker uses
+// for multiplexing streams: {STREAM_TYPE, 0, 0, 0, SIZE1, SIZE2, SIZE3, SIZE4}
+type StdWriter struct {
+	w          io.Writer
+	mu         *sync.Mutex
+	streamType byte
+	raw        bool
+}
+
+// NewStdWriters returns writers for stdout and stderr, sharing the same output
+func NewStdWriters(w io.Writer) (*StdWriter, *StdWriter) {
+	mu := &sync.Mutex{}
+	return &StdWriter{w: w, mu: mu, streamType: Stdout}, &StdWriter{w: w, mu: mu, streamType: Stderr}
+}
+
+// NewRawWriters returns writers without the header, for containers with a tty
+func NewRawWriters(w io.Writer) (*StdWriter, *StdWriter) {
+	stdout, stderr := NewStdWriters(w)
+	stdout.raw, stderr.raw = true, true
+	return stdout, stderr
+}
+
+func (s *StdWriter) Write(p []byte) (int, error) {
+	size := uint32(len(p))
+	header := []byte{s.streamType, 0, 0, 0, byte(size >> 24), byte(size >> 16 & 0xff), byte(size >> 8 & 0xff), byte(size & 0xff)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.raw {
+		if _, err := s.w.Write(header); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.w.Write(p)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// Hijack takes over the connection from the HTTP server, for raw streams.
+// If the client asked for an upgrade, it will be switched to "tcp".
+func Hijack(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection can not be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	contentType := "application/vnd.docker.raw-stream"
+	if r.Header.Get("Upgrade") != "" {
+		fmt.Fprintf(rw, "HTTP/1.1 101 UPGRADED\r\nContent-Type: %s\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n", contentType)
+	} else {
+		fmt.Fprintf(rw, "HTTP/1.1 200 OK\r\nContent-Type: %s\r\n\r\n", contentType)
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// CloseWrite closes the writing side of the connection, if supported
+func CloseWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	}
+}
@@ -0,0 +1,176 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package stream writes progress messages to the client, as they happen.
+package stream
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Writer sends newline-delimited JSON messages to the client,
+// flushing after every message so that progress is seen in real time.
+type Writer struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func NewWriter(w http.ResponseWriter) *Writer {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	return &Writer{w: w}
+}
+
+func (s *Writer) Write(p []byte) (int, error) {
+	s.written = true
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	s.Flush()
+	return n, nil
+}
+
+func (s *Writer) Flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// WriteJSON writes a single message, followed by a newline
+func (s *Writer) WriteJSON(v interface{}) error {
+	l, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.Write(append(l, '\n'))
+	return err
+}
+
+// Error reports the error as a HTTP status if nothing has been sent yet,
+// otherwise as an error message in the stream (like docker does).
+// The ID of the request is added, if there is one (in the X-Request-Id header).
+func (s *Writer) Error(err error, code int) {
+	if !s.written {
+		s.w.Header().Set("Content-Type", "application/json")
+		s.w.Header().Del("Transfer-Encoding")
+		s.w.WriteHeader(code)
+		body := map[string]string{"message": err.Error()}
+		if id := s.w.Header().Get("X-Request-Id"); id != "" {
+			body["request_id"] = id
+		}
+		_ = json.NewEncoder(s.w).Encode(body)
+		return
+	}
+	data := map[string]interface{}{
+		"errorDetail": map[string]string{"message": err.Error()},
+		"error":       err.Error(),
+	}
+	if id := s.w.Header().Get("X-Request-Id"); id != "" {
+		data["request_id"] = id
+	}
+	_ = s.WriteJSON(data)
+}
+
+// maxLineSize is the longest output line accepted from a command
+const maxLineSize = 16 * 1024 * 1024
+
+// reFatal finds the message of the error, that nerdctl logs before exiting
+var reFatal = regexp.MustCompile(`level=(?:fatal|error) msg=("(?:[^"\\]|\\.)*")`)
+
+// commandError returns the error of the command, with the message from the error output
+func commandError(err error, stderr string) error {
+	if _, ok := err.(*exec.ExitError); !ok || stderr == "" {
+		return err
+	}
+	if m := reFatal.FindStringSubmatch(stderr); m != nil {
+		if msg, uerr := strconv.Unquote(m[1]); uerr == nil {
+			return errors.New(msg)
+		}
+	}
+	return errors.New(stderr)
+}
+
+// Command runs the command, and streams every output line as a message, as it happens.
+// The progress is on the error output (like for pull and push), so both outputs are
+// streamed, and the last error line is also the error when the command fails.
+func Command(cmd *exec.Cmd, sw *Writer) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	var writeErr error
+	var lastErr string
+	scan := func(r io.Reader, isStderr bool) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, bufio.MaxScanTokenSize), maxLineSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			mu.Lock()
+			if isStderr {
+				lastErr = line
+			}
+			if writeErr == nil {
+				writeErr = sw.WriteJSON(map[string]string{"stream": line + "\n"})
+				if writeErr != nil {
+					// client went away, so stop the command
+					_ = cmd.Process.Kill()
+				}
+			}
+			mu.Unlock()
+		}
+		return scanner.Err()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- scan(stderr, true)
+	}()
+	scanErr := scan(stdout, false)
+	if err := <-done; scanErr == nil {
+		scanErr = err
+	}
+	if scanErr != nil {
+		_ = cmd.Process.Kill()
+	}
+	err = cmd.Wait()
+	if writeErr != nil {
+		return writeErr
+	}
+	if scanErr != nil {
+		return scanErr
+	}
+	return commandError(err, lastErr)
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright The containerd Authors.
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package stream
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// messages returns the "stream" of the messages written, sorted
+// (since the two outputs are read at the same time)
+func messages(t *testing.T, body string) []string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(body), "\n") {
+		var m map[string]string
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			t.Fatalf("%q: %v", l, err)
+		}
+		lines = append(lines, m["stream"])
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestCommand(t *testing.T) {
+	w := httptest.NewRecorder()
+	sw := NewWriter(w)
+	cmd := exec.Command("sh", "-c", "echo Loaded image: alpine; echo unpacking >&2")
+	if err := Command(cmd, sw); err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(t, w.Body.String()); strings.Join(got, "") != "Loaded image: alpine\nunpacking\n" {
+		t.Errorf("messages %q", got)
+	}
+	if !w.Flushed {
+		t.Error("not flushed")
+	}
+}
+
+func TestCommandError(t *testing.T) {
+	for script, want := range map[string]string{
+		`echo resolving >&2; echo 'time="2024-01-01T00:00:00Z" level=fatal msg="failed to resolve \"nope\""' >&2; exit 1`: `failed to resolve "nope"`,
+		`echo no such file >&2; exit 2`: "no such file",
+		`exit 3`:                        "exit status 3",
+	} {
+		w := httptest.NewRecorder()
+		err := Command(exec.Command("sh", "-c", script), NewWriter(w))
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", script, err, want)
+		}
+	}
+}
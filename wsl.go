@@ -0,0 +1,108 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nerdctld
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenHeader is the header with the token, set with "HttpHeaders" in the docker config
+const TokenHeader = "X-Nerdctld-Token"
+
+// IsWSL returns whether running in the Windows Subsystem for Linux
+func IsWSL() bool {
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	_, err := os.Stat("/proc/sys/fs/binfmt_misc/WSLInterop")
+	return err == nil
+}
+
+// Token reads the token from the file, or writes a new random token to it
+func Token(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return token, os.WriteFile(path, []byte(token+"\n"), 0600)
+}
+
+// tokenHandler only lets through the requests, that have the token in the header
+func tokenHandler(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(TokenHeader)), []byte(token)) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "{\"message\":\"missing or wrong %s header\"}\n", TokenHeader)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// loopback returns whether the listen address is only reachable from this host
+// (the token is sent in the clear, so the relay does not listen on the network)
+func loopback(listenAddr string) bool {
+	host, _, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeRelay listens on the tcp:// address, and serves the API to the clients with the token,
+// like the docker cli on windows (reaching WSL on localhost). The address must be a loopback one.
+func (s *Server) ServeRelay(addr string, token string) error {
+	listenAddr, ok := strings.CutPrefix(addr, "tcp://")
+	if !ok {
+		return fmt.Errorf("relay addr %s not supported", addr)
+	}
+	if !loopback(listenAddr) {
+		return fmt.Errorf("relay addr %s is not a loopback address", addr)
+	}
+	if token == "" {
+		return fmt.Errorf("relay addr %s requires a token", addr)
+	}
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	return s.serve(l, tokenHandler(token, s.router))
+}
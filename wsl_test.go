@@ -0,0 +1,43 @@
+/*
+   Copyright 2022 Anders F Björklund
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package nerdctld
+
+import "testing"
+
+func TestLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:2375": true,
+		"localhost:2375": true,
+		"[::1]:2375":     true,
+		"0.0.0.0:2375":   false,
+		":2375":          false,
+		"10.0.0.1:2375":  false,
+		"example.com:80": false,
+		"127.0.0.1":      false,
+	} {
+		if got := loopback(addr); got != want {
+			t.Errorf("loopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestServeRelayNotLoopback(t *testing.T) {
+	s := &Server{}
+	if err := s.ServeRelay("tcp://0.0.0.0:2375", "secret"); err == nil {
+		t.Error("expected an error for a non-loopback relay address")
+	}
+}